@@ -0,0 +1,137 @@
+package typhon
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// BatchConfig configures a BatchFilter.
+type BatchConfig struct {
+	// Key groups requests which can be batched together; requests for which it returns "" are passed through
+	// individually.
+	Key func(req Request) string
+	// Window is how long the first request in a batch waits for others to join it before the batch is sent.
+	Window time.Duration
+	// MaxSize, if positive, is the largest number of requests in a batch. A batch is sent as soon as it is full.
+	MaxSize int
+	// Encode combines a batch of requests (in the order they arrived) into a single request to send downstream. The
+	// combined request's context should not be that of any individual request, as their callers may give up waiting
+	// independently.
+	Encode func(reqs []Request) (Request, error)
+	// Decode splits the response to the combined request into a response for each request in the batch, in the same
+	// order.
+	Decode func(reqs []Request, rsp Response) ([]Response, error)
+}
+
+type pendingBatch struct {
+	reqs    []Request
+	waiters []chan Response
+	timer   *time.Timer
+}
+
+// BatchFilter returns a Filter which collects requests with the same key that arrive within a short window, sends
+// them downstream as a single combined request, and returns to each caller its own part of the combined response.
+// This reduces the overhead of chatty access patterns, at the cost of up to config.Window of extra latency. The wire
+// format of batches is application-specific, so it is provided by config.Encode and config.Decode.
+//
+// If the combined request fails, or the batch can't be encoded or decoded, every request in the batch fails with the
+// same error; so does a panic in any of them, which is recovered (as batches are sent from their own goroutines) and
+// converted with PanicError. A caller whose request is cancelled stops waiting, but its request remains part of the
+// batch.
+func BatchFilter(config BatchConfig) Filter {
+	mtx := sync.Mutex{}
+	batches := map[string]*pendingBatch{}
+
+	// detach removes the batch from the map so no more requests join it; it must be called with mtx held, and returns
+	// false if the batch has already been detached
+	detach := func(k string, b *pendingBatch) bool {
+		if batches[k] != b {
+			return false
+		}
+		delete(batches, k)
+		b.timer.Stop()
+		return true
+	}
+	send := func(b *pendingBatch, svc Service) {
+		rsps, err := sendBatch(config, b.reqs, svc)
+		for i, w := range b.waiters {
+			if err != nil {
+				w <- Response{
+					Error: err}
+			} else {
+				w <- rsps[i]
+			}
+		}
+	}
+
+	return func(req Request, svc Service) Response {
+		k := config.Key(req)
+		if k == "" {
+			return svc(req)
+		}
+
+		w := make(chan Response, 1)
+		mtx.Lock()
+		b, ok := batches[k]
+		if !ok {
+			b = &pendingBatch{}
+			b.timer = time.AfterFunc(config.Window, func() {
+				mtx.Lock()
+				ok := detach(k, b)
+				mtx.Unlock()
+				if ok {
+					send(b, svc)
+				}
+			})
+			batches[k] = b
+		}
+		b.reqs = append(b.reqs, req)
+		b.waiters = append(b.waiters, w)
+		full := config.MaxSize > 0 && len(b.reqs) >= config.MaxSize && detach(k, b)
+		mtx.Unlock()
+		if full {
+			go send(b, svc)
+		}
+
+		select {
+		case rsp := <-w:
+			if rsp.Request == nil {
+				rsp.Request = &req
+			}
+			return rsp
+		case <-req.Done():
+			return Response{
+				Error: terrors.Wrap(req.Err(), nil)}
+		}
+	}
+}
+
+func sendBatch(config BatchConfig, reqs []Request, svc Service) (_ []Response, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = PanicError(v)
+		}
+	}()
+	batchReq, err := config.Encode(reqs)
+	if err != nil {
+		return nil, terrors.Wrap(err, nil)
+	}
+	rsp := svc(batchReq)
+	if rsp.Error != nil {
+		return nil, rsp.Error
+	}
+	rsps, err := config.Decode(reqs, rsp)
+	if err != nil {
+		return nil, terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+	}
+	if len(rsps) != len(reqs) {
+		return nil, terrors.BadResponse("batch_size_mismatch", "Batch response has the wrong number of responses",
+			map[string]string{
+				"requests":  strconv.Itoa(len(reqs)),
+				"responses": strconv.Itoa(len(rsps))})
+	}
+	return rsps, nil
+}
@@ -0,0 +1,149 @@
+package typhon
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBatchConfig batches GETs of /users/:id into a GET of /users?ids=..., whose response is a map of ID to name
+func testBatchConfig(window time.Duration, maxSize int) BatchConfig {
+	return BatchConfig{
+		Key: func(req Request) string {
+			if strings.HasPrefix(req.URL.Path, "/users/") {
+				return "users"
+			}
+			return ""
+		},
+		Window:  window,
+		MaxSize: maxSize,
+		Encode: func(reqs []Request) (Request, error) {
+			ids := make([]string, len(reqs))
+			for i, req := range reqs {
+				ids[i] = strings.TrimPrefix(req.URL.Path, "/users/")
+			}
+			return NewRequest(context.Background(), "GET", "/users?ids="+strings.Join(ids, ","), nil), nil
+		},
+		Decode: func(reqs []Request, rsp Response) ([]Response, error) {
+			names := map[string]string{}
+			if err := rsp.Decode(&names); err != nil {
+				return nil, err
+			}
+			rsps := make([]Response, len(reqs))
+			for i, req := range reqs {
+				rsps[i] = req.Response(names[strings.TrimPrefix(req.URL.Path, "/users/")])
+			}
+			return rsps, nil
+		}}
+}
+
+func TestBatchFilter(t *testing.T) {
+	t.Parallel()
+
+	mtx := sync.Mutex{}
+	var batches []string
+	svc := Service(func(req Request) Response {
+		if req.URL.Path != "/users" {
+			return req.Response("unbatched")
+		}
+		ids := strings.Split(req.URL.Query().Get("ids"), ",")
+		sort.Strings(ids)
+		mtx.Lock()
+		batches = append(batches, strings.Join(ids, ","))
+		mtx.Unlock()
+		names := map[string]string{}
+		for _, id := range ids {
+			names[id] = "user " + id
+		}
+		return req.Response(names)
+	}).Filter(BatchFilter(testBatchConfig(20*time.Millisecond, 3)))
+
+	ids := []string{"1", "2", "3", "4"}
+	names := make([]string, len(ids))
+	wg := sync.WaitGroup{}
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			rsp := svc(NewRequest(context.Background(), "GET", "/users/"+id, nil))
+			require.NoError(t, rsp.Error)
+			require.NoError(t, rsp.Decode(&names[i]))
+		}(i, id)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []string{"user 1", "user 2", "user 3", "user 4"}, names)
+	// The first batch fills up and is sent immediately; the remaining request is sent when the window closes
+	require.Len(t, batches, 2)
+	sort.Slice(batches, func(i, j int) bool { return len(batches[i]) > len(batches[j]) })
+	assert.Len(t, strings.Split(batches[0], ","), 3)
+	assert.Len(t, strings.Split(batches[1], ","), 1)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/other", nil))
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "unbatched", body)
+}
+
+func TestBatchFilterErrors(t *testing.T) {
+	t.Parallel()
+
+	calls := int32(0)
+	svc := Service(func(req Request) Response {
+		atomic.AddInt32(&calls, 1)
+		return Response{Error: terrors.InternalService("boom", "Downstream failed", nil)}
+	}).Filter(BatchFilter(testBatchConfig(10*time.Millisecond, 0)))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp := svc(NewRequest(context.Background(), "GET", "/users/1", nil))
+			assert.True(t, terrors.Is(rsp.Error, "internal_service.boom"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	config := testBatchConfig(time.Millisecond, 0)
+	config.Encode = func(reqs []Request) (Request, error) {
+		return Request{}, errors.New("can't encode")
+	}
+	svc = Service(BareClient).Filter(BatchFilter(config))
+	rsp := svc(NewRequest(context.Background(), "GET", "/users/1", nil))
+	require.Error(t, rsp.Error)
+	assert.Contains(t, rsp.Error.Error(), "can't encode")
+
+	// Panics fail the batch rather than crashing the process, whether the batch is sent by its timer or once full
+	for _, maxSize := range []int{0, 2} {
+		svc = Service(func(req Request) Response {
+			panic("boom")
+		}).Filter(BatchFilter(testBatchConfig(time.Millisecond, maxSize)))
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rsp := svc(NewRequest(context.Background(), "GET", "/users/1", nil))
+				assert.True(t, terrors.Is(rsp.Error, "internal_service.panic"))
+			}()
+		}
+		wg.Wait()
+	}
+
+	// Cancelled callers stop waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	svc = Service(BareClient).Filter(BatchFilter(testBatchConfig(time.Hour, 0)))
+	rsp = svc(NewRequest(ctx, "GET", "/users/1", nil))
+	require.Error(t, rsp.Error)
+}
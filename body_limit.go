@@ -0,0 +1,86 @@
+package typhon
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/monzo/terrors"
+)
+
+// MaxBodySizeFilter returns a Filter which limits request bodies to max bytes. Reading a body beyond the limit, or
+// reading one whose declared Content-Length exceeds it, fails with a 413 (Request Entity Too Large) error, which
+// Request.Decode and friends return as-is. Requests are otherwise handled normally, so a Service which doesn't read
+// the body isn't affected.
+//
+// Individual routes can set their own limit with WithRouteMaxBodySize, which replaces this one, so that upload
+// endpoints can accept large bodies while everything else stays tightly capped. For that to work, filters between
+// this one and the Router mustn't read the body.
+//
+// This differs from ContentLengthLimit, which vets only the declared Content-Length of requests which expect
+// 100-continue, before their body is sent: it can't cap chunked bodies, or those which understate their length. The
+// two can be combined, so that clients which wait for 100-continue are turned away before they send a large body.
+func MaxBodySizeFilter(max int64) Filter {
+	return func(req Request, svc Service) Response {
+		if req.Body != nil {
+			req.Body = &limitedBody{
+				ReadCloser:    req.Body,
+				contentLength: req.ContentLength,
+				limit:         max}
+		}
+		return svc(req)
+	}
+}
+
+// WithRouteMaxBodySize limits the bodies of requests dispatched to the route to max bytes, as MaxBodySizeFilter does,
+// replacing any limit which MaxBodySizeFilter has applied.
+func WithRouteMaxBodySize(max int64) RouteOption {
+	return func(e *routerEntry) {
+		e.maxBodySize = max
+	}
+}
+
+func routeBodySizeFilter(max int64) Filter {
+	return func(req Request, svc Service) Response {
+		if b, ok := req.Body.(*limitedBody); ok {
+			b.limit = max
+			return svc(req)
+		}
+		return MaxBodySizeFilter(max)(req, svc)
+	}
+}
+
+// limitedBody is a request body which fails once more than limit bytes are read from it.
+type limitedBody struct {
+	io.ReadCloser
+	contentLength int64
+	limit         int64
+	read          int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.contentLength > b.limit || b.read > b.limit {
+		return 0, b.tooLarge()
+	}
+	// Read (at most) one byte more than the limit allows, to detect bodies which exceed it
+	if remaining := b.limit - b.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n - int(b.read-b.limit), b.tooLarge()
+	}
+	return n, err
+}
+
+func (b *limitedBody) tooLarge() error {
+	params := map[string]string{
+		"max_bytes": strconv.FormatInt(b.limit, 10)}
+	// As for ContentLengthLimit, the declared length is reported if it is the reason for the rejection
+	if b.contentLength > b.limit {
+		params["content_length"] = strconv.FormatInt(b.contentLength, 10)
+	}
+	return terrors.New(ErrRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the limit of %d bytes", b.limit),
+		params)
+}
@@ -0,0 +1,66 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	t.Parallel()
+	echo := func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return Response{
+				Error: err}
+		}
+		return req.Response(string(b))
+	}
+	router := Router{}
+	router.POST("/small", echo)
+	router.POST("/upload", echo, WithRouteMaxBodySize(20))
+	svc := router.Serve().Filter(MaxBodySizeFilter(5)).Filter(ErrorFilter)
+
+	send := func(path, body string, contentLength int64) Response {
+		req := NewRequest(context.Background(), "POST", path, nil)
+		req.Body = ioutil.NopCloser(strings.NewReader(body))
+		req.ContentLength = contentLength
+		return svc(req)
+	}
+
+	rsp := send("/small", "12345", -1)
+	require.NoError(t, rsp.Error)
+	out := ""
+	require.NoError(t, rsp.Decode(&out))
+	assert.Equal(t, "12345", out)
+
+	rsp = send("/small", "123456", -1)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, ErrRequestEntityTooLarge))
+	assert.Equal(t, "5", rsp.Error.(*terrors.Error).Params["max_bytes"])
+
+	// The route's limit replaces the global one
+	rsp = send("/upload", strings.Repeat("x", 20), -1)
+	require.NoError(t, rsp.Error)
+	rsp = send("/upload", strings.Repeat("x", 21), -1)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rsp.StatusCode)
+	assert.Equal(t, "20", rsp.Error.(*terrors.Error).Params["max_bytes"])
+
+	// A declared Content-Length over the limit fails without reading the body
+	rsp = send("/upload", "x", 100)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rsp.StatusCode)
+	assert.Equal(t, "100", rsp.Error.(*terrors.Error).Params["content_length"])
+
+	// Routes can be limited without a global filter, too
+	router = Router{}
+	router.POST("/", echo, WithRouteMaxBodySize(3))
+	svc = router.Serve().Filter(ErrorFilter)
+	assert.NoError(t, send("/", "abc", 3).Error)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("/", "abcd", 4).StatusCode)
+}
@@ -20,8 +20,11 @@ type StreamerWriter interface {
 }
 
 type streamer struct {
-	pipeR *io.PipeReader
-	pipeW *io.PipeWriter
+	pipeR   *io.PipeReader
+	pipeW   *io.PipeWriter
+	mtx     sync.Mutex
+	closed  bool
+	onClose []io.Closer
 }
 
 // Streamer returns a reader/writer/closer that can be used to stream responses. A simple use of this is:
@@ -56,13 +59,60 @@ func (s *streamer) Write(p []byte) (int, error) {
 }
 
 func (s *streamer) Close() error {
+	defer s.markClosed()
 	return s.pipeW.Close()
 }
 
 func (s *streamer) CloseWithError(err error) error {
+	defer s.markClosed()
 	return s.pipeW.CloseWithError(err)
 }
 
+// closeAlso arranges for c to be closed once the streamer is closed, or immediately if it already has been.
+func (s *streamer) closeAlso(c io.Closer) {
+	s.mtx.Lock()
+	closed := s.closed
+	if !closed {
+		s.onClose = append(s.onClose, c)
+	}
+	s.mtx.Unlock()
+	if closed {
+		c.Close()
+	}
+}
+
+func (s *streamer) markClosed() {
+	s.mtx.Lock()
+	s.closed = true
+	onClose := s.onClose
+	s.onClose = nil
+	s.mtx.Unlock()
+	for _, c := range onClose {
+		c.Close()
+	}
+}
+
+// writerToBody returns a body which streams the output of w.WriteTo, so it can be sent without first being buffered.
+// WriteTo runs in its own goroutine as the body is read; if the body is closed early, its writes fail and it should
+// return. Errors returned by WriteTo are returned to the body's reader.
+func writerToBody(w io.WriterTo) io.ReadCloser {
+	pipeR, pipeW := io.Pipe()
+	go func() {
+		_, err := w.WriteTo(pipeW)
+		pipeW.CloseWithError(err)
+	}()
+	return pipeR
+}
+
+// bodyLength returns the number of bytes which v will produce if it reports this with a Len method (as
+// bytes.Buffer, bytes.Reader and strings.Reader do), or -1 if it is unknown.
+func bodyLength(v interface{}) int64 {
+	if l, ok := v.(interface{ Len() int }); ok {
+		return int64(l.Len())
+	}
+	return -1
+}
+
 // doneReader is a wrapper around a ReadCloser which provides notification when the stream has been fully consumed
 // (ie. when EOF is reached, when the reader is explicitly closed, or if the size of the underlying reader is known,
 // when it has been fully read [even if EOF is not reached.])
@@ -0,0 +1,95 @@
+package typhon
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A Bulkhead limits the number of requests which a Service handles concurrently, protecting it from overload. Create
+// one with NewBulkhead; most users will want BulkheadFilter instead.
+type Bulkhead struct {
+	// Accessed atomically, so must be first for alignment on 32-bit platforms
+	queued, rejected uint64
+	inFlight         int64
+	slots            chan struct{}
+	queueTimeout     time.Duration
+}
+
+// BulkheadStats is a snapshot of a Bulkhead's counters.
+type BulkheadStats struct {
+	// InFlight is the number of requests currently being handled.
+	InFlight int
+	// Queued is the total number of requests which have had to wait for a slot.
+	Queued uint64
+	// Rejected is the total number of requests which were rejected because no slot became available.
+	Rejected uint64
+}
+
+// NewBulkhead returns a Bulkhead which allows up to maxConcurrent requests to be handled at once. When all slots are
+// in use, requests wait up to queueTimeout (which may be zero) for one to become free, after which they are rejected
+// with a 503 (Service Unavailable) error.
+func NewBulkhead(maxConcurrent int, queueTimeout time.Duration) *Bulkhead {
+	return &Bulkhead{
+		slots:        make(chan struct{}, maxConcurrent),
+		queueTimeout: queueTimeout}
+}
+
+// BulkheadFilter returns a Filter which bounds the number of concurrent executions of the Service, as described by
+// NewBulkhead. Use a Bulkhead directly to inspect its counters.
+func BulkheadFilter(maxConcurrent int, queueTimeout time.Duration) Filter {
+	return NewBulkhead(maxConcurrent, queueTimeout).Filter
+}
+
+// Stats returns a snapshot of the bulkhead's counters.
+func (b *Bulkhead) Stats() BulkheadStats {
+	return BulkheadStats{
+		InFlight: int(atomic.LoadInt64(&b.inFlight)),
+		Queued:   atomic.LoadUint64(&b.queued),
+		Rejected: atomic.LoadUint64(&b.rejected)}
+}
+
+// Filter is a Filter which applies the bulkhead to requests. A slot is held until the Service returns (or panics);
+// streamed response bodies are not counted.
+func (b *Bulkhead) Filter(req Request, svc Service) Response {
+	select {
+	case b.slots <- struct{}{}:
+	default:
+		if !b.wait(req) {
+			atomic.AddUint64(&b.rejected, 1)
+			return Response{
+				Error: terrors.New(ErrServiceUnavailable, "Too many concurrent requests", map[string]string{
+					"max_concurrent": strconv.Itoa(cap(b.slots))})}
+		}
+	}
+	atomic.AddInt64(&b.inFlight, 1)
+	defer func() {
+		atomic.AddInt64(&b.inFlight, -1)
+		<-b.slots
+	}()
+	return svc(req)
+}
+
+// wait queues for a slot, returning whether one was acquired.
+func (b *Bulkhead) wait(req Request) bool {
+	if b.queueTimeout <= 0 {
+		return false
+	}
+	atomic.AddUint64(&b.queued, 1)
+	var done <-chan struct{}
+	if req.Context != nil {
+		done = req.Done()
+	}
+	t := time.NewTimer(b.queueTimeout)
+	defer t.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	case <-done:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkhead(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	b := NewBulkhead(2, 0)
+	svc := Service(func(req Request) Response {
+		started <- struct{}{}
+		<-release
+		return req.Response(nil)
+	}).Filter(b.Filter).Filter(ErrorFilter)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+			assert.NoError(t, rsp.Error)
+		}()
+	}
+	<-started
+	<-started
+	assert.Equal(t, 2, b.Stats().InFlight)
+
+	// With no queueing, further requests are rejected immediately
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, ErrServiceUnavailable))
+	assert.EqualValues(t, 1, b.Stats().Rejected)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, BulkheadStats{
+		InFlight: 0,
+		Queued:   0,
+		Rejected: 1}, b.Stats())
+}
+
+func TestBulkheadQueue(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	b := NewBulkhead(1, 5*time.Second)
+	svc := Service(func(req Request) Response {
+		started <- struct{}{}
+		<-release
+		return req.Response(nil)
+	}).Filter(b.Filter)
+
+	go svc(NewRequest(context.Background(), "GET", "/", nil))
+	<-started
+
+	// A queued request gets the slot once it's released
+	done := make(chan Response, 1)
+	go func() {
+		done <- svc(NewRequest(context.Background(), "GET", "/", nil))
+	}()
+	for b.Stats().Queued == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	require.NoError(t, (<-done).Error)
+
+	// Queueing stops when the request's context is cancelled
+	go svc(NewRequest(context.Background(), "GET", "/", nil))
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rsp := svc(NewRequest(ctx, "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.EqualValues(t, 1, b.Stats().Rejected)
+	close(release)
+}
+
+func TestBulkheadPanic(t *testing.T) {
+	t.Parallel()
+
+	b := NewBulkhead(1, 0)
+	svc := Service(func(req Request) Response {
+		panic("boom")
+	}).Filter(b.Filter)
+	assert.Panics(t, func() {
+		svc(NewRequest(context.Background(), "GET", "/", nil))
+	})
+	// The slot was released
+	assert.Equal(t, 0, b.Stats().InFlight)
+	assert.Len(t, b.slots, 0)
+}
@@ -0,0 +1,76 @@
+package typhon
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheControl describes the directives of a Cache-Control response header. Durations are rounded down to whole
+// seconds, and are omitted from the header unless they are positive.
+type CacheControl struct {
+	// Public marks the response as storable by shared caches, even where it would normally not be (eg. authenticated).
+	Public bool
+	// Private marks the response as storable only by the client's own cache.
+	Private bool
+	// NoCache requires caches to revalidate the response with the origin before each use.
+	NoCache bool
+	// NoStore forbids caches from storing any part of the response.
+	NoStore bool
+	// MaxAge is how long the response remains fresh.
+	MaxAge time.Duration
+	// SharedMaxAge overrides MaxAge for shared caches (s-maxage).
+	SharedMaxAge time.Duration
+	// StaleWhileRevalidate is how long a stale response may be served while it is revalidated in the background.
+	StaleWhileRevalidate time.Duration
+	// Immutable indicates that the response will not change while it is fresh, so needn't be revalidated.
+	Immutable bool
+}
+
+// String returns the value of the Cache-Control header described by c.
+func (c CacheControl) String() string {
+	directives := make([]string, 0, 8)
+	if c.Public {
+		directives = append(directives, "public")
+	}
+	if c.Private {
+		directives = append(directives, "private")
+	}
+	if c.NoCache {
+		directives = append(directives, "no-cache")
+	}
+	if c.NoStore {
+		directives = append(directives, "no-store")
+	}
+	seconds := func(name string, d time.Duration) {
+		if s := int64(d / time.Second); s > 0 {
+			directives = append(directives, name+"="+strconv.FormatInt(s, 10))
+		}
+	}
+	seconds("max-age", c.MaxAge)
+	seconds("s-maxage", c.SharedMaxAge)
+	seconds("stale-while-revalidate", c.StaleWhileRevalidate)
+	if c.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
+
+// SetCacheControl sets the response's Cache-Control header from the passed directives.
+func (r *Response) SetCacheControl(c CacheControl) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	r.Header.Set("Cache-Control", c.String())
+}
+
+// NoCache sets headers instructing all caches, including HTTP/1.0 caches which don't understand Cache-Control, not
+// to store the response. This is appropriate for responses containing sensitive data.
+func (r *Response) NoCache() {
+	r.SetCacheControl(CacheControl{
+		NoCache: true,
+		NoStore: true})
+	r.Header.Set("Pragma", "no-cache")
+	r.Header.Set("Expires", "0")
+}
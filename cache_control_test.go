@@ -0,0 +1,47 @@
+package typhon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheControl(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		cc       CacheControl
+		expected string
+	}{
+		{CacheControl{}, ""},
+		{CacheControl{NoStore: true}, "no-store"},
+		{CacheControl{Private: true, MaxAge: time.Minute}, "private, max-age=60"},
+		{CacheControl{
+			Public:               true,
+			MaxAge:               time.Hour,
+			SharedMaxAge:         10 * time.Minute,
+			StaleWhileRevalidate: 1500 * time.Millisecond,
+			Immutable:            true},
+			"public, max-age=3600, s-maxage=600, stale-while-revalidate=1, immutable"},
+		{CacheControl{NoCache: true, MaxAge: 500 * time.Millisecond}, "no-cache"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.cc.String())
+	}
+}
+
+func TestResponseSetCacheControl(t *testing.T) {
+	t.Parallel()
+
+	// Works on a Response with no underlying http.Response
+	rsp := Response{}
+	rsp.SetCacheControl(CacheControl{Public: true, MaxAge: time.Minute})
+	assert.Equal(t, "public, max-age=60", rsp.Header.Get("Cache-Control"))
+
+	rsp = NewResponse(Request{})
+	rsp.NoCache()
+	assert.Equal(t, "no-cache, no-store", rsp.Header.Get("Cache-Control"))
+	assert.Equal(t, "no-cache", rsp.Header.Get("Pragma"))
+	assert.Equal(t, "0", rsp.Header.Get("Expires"))
+}
@@ -0,0 +1,69 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/monzo/terrors"
+)
+
+// CallBudgetHeader carries the remaining call budget of a request's caller to the server (see CallBudgetFilter).
+const CallBudgetHeader = "X-Typhon-Call-Budget"
+
+type callBudgetContextKeyType struct{}
+
+var callBudgetContextKey = callBudgetContextKeyType{}
+
+type callBudget struct {
+	max   int64
+	calls int64 // accessed atomically
+}
+
+// WithCallBudget returns a copy of the passed context which allows at most maxCalls downstream requests to be sent
+// (through CallBudgetClientFilter) with it, or any context derived from it. The budget is shared by all of those
+// requests, including concurrent ones.
+func WithCallBudget(ctx context.Context, maxCalls int) context.Context {
+	return context.WithValue(ctx, callBudgetContextKey, &callBudget{
+		max: int64(maxCalls)})
+}
+
+// CallBudgetFilter returns a server Filter which gives each inbound request a budget of maxCalls downstream requests
+// (see WithCallBudget), so that runaway fan-out fails fast with a clear error rather than multiplying load. Outbound
+// requests are charged against the budget by CallBudgetClientFilter.
+//
+// CallBudgetClientFilter also sends what remains of the budget in the CallBudgetHeader of each outbound request, and
+// if an inbound request has a smaller budget than maxCalls in that header, it is used instead. The budget therefore
+// shrinks with each hop, so a loop between services which use these filters (eg. A→B→A) is also caught. The header
+// can only lower the budget, so it needn't be trusted.
+func CallBudgetFilter(maxCalls int) Filter {
+	return func(req Request, svc Service) Response {
+		budget := maxCalls
+		if v, err := strconv.Atoi(req.Header.Get(CallBudgetHeader)); err == nil && v >= 0 && v < budget {
+			budget = v
+		}
+		req.Context = WithCallBudget(req.Context, budget)
+		return svc(req)
+	}
+}
+
+// CallBudgetClientFilter is a client Filter which charges each outbound request against the call budget of its
+// context, if it has one, rejecting requests which would exceed it with an internal_service.call_budget_exceeded
+// error without sending them. The budget which then remains is sent in the CallBudgetHeader.
+func CallBudgetClientFilter(req Request, svc Service) Response {
+	if req.Context != nil {
+		if b, ok := req.Value(callBudgetContextKey).(*callBudget); ok {
+			n := atomic.AddInt64(&b.calls, 1)
+			if n > b.max {
+				return Response{
+					Error: terrors.InternalService("call_budget_exceeded",
+						fmt.Sprintf("Request exceeded its budget of %d downstream calls", b.max),
+						map[string]string{
+							"max_calls": strconv.FormatInt(b.max, 10)})}
+			}
+			req.Header.Set(CallBudgetHeader, strconv.FormatInt(b.max-n, 10))
+		}
+	}
+	return svc(req)
+}
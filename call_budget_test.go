@@ -0,0 +1,83 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallBudget(t *testing.T) {
+	t.Parallel()
+
+	downstream := Service(func(req Request) Response {
+		return req.Response("ok")
+	}).Filter(CallBudgetClientFilter)
+
+	// A service which makes three downstream calls is within its budget
+	svc := Service(func(req Request) Response {
+		for i := 0; i < 3; i++ {
+			rsp := downstream(NewRequest(req, "GET", "/", nil))
+			if rsp.Error != nil {
+				return rsp
+			}
+		}
+		return req.Response("done")
+	}).Filter(CallBudgetFilter(3)).Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+
+	svc = Service(func(req Request) Response {
+		for i := 0; i < 10; i++ {
+			rsp := downstream(NewRequest(req, "GET", "/", nil))
+			if rsp.Error != nil {
+				return rsp
+			}
+		}
+		return req.Response("done")
+	}).Filter(CallBudgetFilter(3)).Filter(ErrorFilter)
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, "internal_service.call_budget_exceeded"))
+	assert.Equal(t, "3", rsp.Error.(*terrors.Error).Params["max_calls"])
+
+	// Requests without a budget are unlimited
+	for i := 0; i < 10; i++ {
+		require.NoError(t, downstream(NewRequest(context.Background(), "GET", "/", nil)).Error)
+	}
+}
+
+func TestCallBudgetLoop(t *testing.T) {
+	t.Parallel()
+
+	// A service which calls itself, as in a loop between services, is stopped once the budget runs out
+	var svc Service
+	hops := 0
+	client := Service(func(req Request) Response {
+		// As if sent over the wire, so only the header is passed on
+		fresh := NewRequest(context.Background(), req.Method, req.URL.String(), nil)
+		fresh.Header = req.Header
+		return svc(fresh)
+	}).Filter(CallBudgetClientFilter)
+	svc = Service(func(req Request) Response {
+		hops++
+		return client(NewRequest(req, "GET", "/", nil))
+	}).Filter(CallBudgetFilter(5)).Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, "internal_service.call_budget_exceeded"))
+	assert.Equal(t, 6, hops)
+
+	// The header can't raise the budget
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set(CallBudgetHeader, "100")
+	hops = 0
+	svc(req)
+	assert.Equal(t, 6, hops)
+}
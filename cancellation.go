@@ -0,0 +1,212 @@
+package typhon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/monzo/terrors"
+)
+
+// RootRequestHeader carries the ID of the root of the tree of requests of which a request is part, so that services
+// can cancel the whole tree; see CancellationRegistry.
+const RootRequestHeader = "X-Typhon-Root"
+
+type cancellationTreeContextKeyType struct{}
+
+var cancellationTreeContextKey = cancellationTreeContextKeyType{}
+
+// A CancellationRegistry coordinates the cancellation of trees of requests: the downstream requests made while
+// handling an inbound (root) request, perhaps concurrently, and perhaps with contexts which aren't derived from the
+// root's. When the root is cancelled, finishes, exceeds its budget of downstream requests, or is cancelled explicitly
+// by ID, all of its outstanding downstream requests are cancelled too, and no more can be made.
+//
+// Use Filter on the server, and ClientFilter on the client used to make downstream requests. Create one with
+// NewCancellationRegistry.
+type CancellationRegistry struct {
+	// TrustRootRequestHeader reports whether the RootRequestHeader of an inbound request can be trusted, eg. because
+	// the request came from another of our services over an authenticated connection. Untrusted requests (all of them,
+	// if this is nil) have the header stripped and become the root of a new tree, so that external clients can't join
+	// their requests to others' trees and then cancel them.
+	//
+	// This MUST only be modified before use takes place; access is not synchronised.
+	TrustRootRequestHeader func(req Request) bool
+
+	maxRequests int
+	mtx         sync.Mutex
+	trees       map[string]*cancellationTree
+}
+
+type cancellationTree struct {
+	id       string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mtx      sync.Mutex
+	requests int
+}
+
+// NewCancellationRegistry returns a CancellationRegistry which allows each root request to make up to maxRequests
+// downstream requests, after which its whole tree is cancelled. If maxRequests is zero, trees are only cancelled along
+// with their root, or explicitly.
+func NewCancellationRegistry(maxRequests int) *CancellationRegistry {
+	return &CancellationRegistry{
+		maxRequests: maxRequests,
+		trees:       map[string]*cancellationTree{}}
+}
+
+// Filter is a server Filter which registers each inbound request as the root of a tree, identified by its
+// RootRequestHeader if it is trusted (so that a tree spanning several services can be cancelled as a unit; see
+// TrustRootRequestHeader) or a new random ID. The tree is cancelled once the response has been sent (ie. its body
+// closed), or as soon as the Service returns if the response has no body.
+func (r *CancellationRegistry) Filter(req Request, svc Service) Response {
+	id := ""
+	if r.TrustRootRequestHeader != nil && r.TrustRootRequestHeader(req) {
+		id = req.Header.Get(RootRequestHeader)
+	} else if req.Header.Get(RootRequestHeader) != "" {
+		req.Header = cloneHeader(req.Header)
+		req.Header.Del(RootRequestHeader)
+	}
+	if id == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return Response{
+				Error: terrors.Wrap(err, nil)}
+		}
+		id = hex.EncodeToString(b)
+	}
+	parent := req.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	t := &cancellationTree{
+		id:     id,
+		ctx:    ctx,
+		cancel: cancel}
+
+	r.mtx.Lock()
+	// If a tree with the same ID is already registered (ie. the request has arrived here more than once), the most
+	// recent is cancellable by ID; each is still cancelled along with its own root
+	r.trees[id] = t
+	r.mtx.Unlock()
+	finish := func() {
+		cancel()
+		r.mtx.Lock()
+		if r.trees[id] == t {
+			delete(r.trees, id)
+		}
+		r.mtx.Unlock()
+	}
+
+	// Unless the body takes over, the tree is finished on the way out, even if the Service panics
+	handedOff := false
+	defer func() {
+		if !handedOff {
+			finish()
+		}
+	}()
+
+	req.Context = context.WithValue(ctx, cancellationTreeContextKey, t)
+	rsp := svc(req)
+	if rsp.Response != nil && rsp.Body != nil {
+		// The body may be streamed from downstream requests, so they mustn't be cancelled until it has been sent
+		rsp.Body = &treeBody{
+			ReadCloser: rsp.Body,
+			finish:     finish}
+		handedOff = true
+	}
+	return rsp
+}
+
+// treeBody is the body of a root request's response, which finishes the request's tree when it is closed.
+type treeBody struct {
+	io.ReadCloser
+	finish func()
+}
+
+func (b *treeBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+// ClientFilter is a client Filter which makes each downstream request part of the tree of the root request whose
+// context (or a context derived from it) it carries: the request is cancelled along with the tree, even if its own
+// context is not. Requests whose tree has already been cancelled fail without being sent, as does a request which
+// exceeds its tree's budget, which also cancels the tree. Requests with no tree are sent unchanged.
+func (r *CancellationRegistry) ClientFilter(req Request, svc Service) Response {
+	var t *cancellationTree
+	if req.Context != nil {
+		t, _ = req.Value(cancellationTreeContextKey).(*cancellationTree)
+	}
+	if t == nil {
+		return svc(req)
+	}
+	if err := t.ctx.Err(); err != nil {
+		return Response{
+			Error: terrors.Wrap(err, map[string]string{
+				"root": t.id})}
+	}
+	t.mtx.Lock()
+	t.requests++
+	n := t.requests
+	t.mtx.Unlock()
+	if r.maxRequests > 0 && n > r.maxRequests {
+		t.cancel()
+		return Response{
+			Error: terrors.InternalService("request_tree_budget_exceeded",
+				fmt.Sprintf("Request tree exceeded its budget of %d downstream requests", r.maxRequests),
+				map[string]string{
+					"root":         t.id,
+					"max_requests": strconv.Itoa(r.maxRequests)})}
+	}
+
+	// Tie the request's context to the tree, in case it isn't already derived from the root's. The context is
+	// released once the response has been received (or its body closed, if it is streamed), so that the goroutine
+	// which ties them doesn't outlive the request.
+	ctx, cancel := context.WithCancel(req.Context)
+	go func() {
+		select {
+		case <-t.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	req = req.WithContext(ctx)
+	req.Header = cloneHeader(req.Header)
+	req.Header.Set(RootRequestHeader, t.id)
+	rsp := svc(req)
+	if rsp.Response != nil && rsp.Body != nil {
+		if _, ok := rsp.Body.(*bufCloser); !ok {
+			rsp.Body = &cancelOnClose{
+				ReadCloser: rsp.Body,
+				cancel:     cancel}
+			return rsp
+		}
+	}
+	cancel()
+	return rsp
+}
+
+// Cancel cancels the tree of requests with the passed root ID, returning whether it was found. This allows a tree to
+// be cancelled from outside, eg. by an administrative endpoint or on a signal from another service.
+func (r *CancellationRegistry) Cancel(rootID string) bool {
+	r.mtx.Lock()
+	t, ok := r.trees[rootID]
+	r.mtx.Unlock()
+	if ok {
+		t.cancel()
+	}
+	return ok
+}
+
+// Active returns the number of trees currently registered, suitable for exporting as a metric.
+func (r *CancellationRegistry) Active() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return len(r.trees)
+}
@@ -0,0 +1,149 @@
+package typhon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancellationRegistry(t *testing.T) {
+	t.Parallel()
+	r := NewCancellationRegistry(3)
+	r.TrustRootRequestHeader = func(req Request) bool {
+		return req.Header.Get("X-Internal") == "yes"
+	}
+
+	// The downstream blocks until its request is cancelled
+	downstream := Service(func(req Request) Response {
+		assert.NotEmpty(t, req.Header.Get(RootRequestHeader))
+		<-req.Done()
+		return Response{
+			Error: terrors.Wrap(req.Err(), nil)}
+	})
+	client := downstream.Filter(r.ClientFilter)
+
+	rootID := make(chan string, 1)
+	svc := Service(func(req Request) Response {
+		rootID <- req.Header.Get(RootRequestHeader)
+		// The downstream request is made with a detached context which carries the tree
+		detached := context.WithValue(context.Background(), cancellationTreeContextKey,
+			req.Value(cancellationTreeContextKey))
+		return client(NewRequest(detached, "GET", "/", nil))
+	}).Filter(r.Filter)
+
+	done := make(chan Response, 1)
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set(RootRequestHeader, "root-1")
+	req.Header.Set("X-Internal", "yes")
+	go func() {
+		done <- svc(req)
+	}()
+	assert.Equal(t, "root-1", <-rootID)
+	for deadline := time.Now().Add(time.Second); r.Active() != 1 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, 1, r.Active())
+
+	// Cancelling the tree by its ID cancels the detached downstream request
+	assert.True(t, r.Cancel("root-1"))
+	rsp := <-done
+	require.Error(t, rsp.Error)
+	assert.Equal(t, 0, r.Active())
+	assert.False(t, r.Cancel("root-1"))
+}
+
+func TestCancellationRegistryBudget(t *testing.T) {
+	t.Parallel()
+	r := NewCancellationRegistry(2)
+	var sent int
+	client := Service(func(req Request) Response {
+		sent++
+		return req.Response(nil)
+	}).Filter(r.ClientFilter)
+
+	var ctx context.Context
+	svc := Service(func(req Request) Response {
+		ctx = req.Context
+		var rsp Response
+		for i := 0; i < 4; i++ {
+			if rsp = client(NewRequest(req, "GET", "/", nil)); rsp.Error != nil {
+				return rsp
+			}
+		}
+		return rsp
+	}).Filter(r.Filter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, "internal_service.request_tree_budget_exceeded"))
+	assert.Equal(t, 2, sent)
+	assert.Error(t, ctx.Err())
+	assert.Equal(t, 0, r.Active())
+
+	// Requests made outside any tree are unaffected
+	rsp = client(NewRequest(context.Background(), "GET", "/", nil))
+	assert.NoError(t, rsp.Error)
+}
+
+func TestCancellationRegistryUntrustedRoot(t *testing.T) {
+	t.Parallel()
+	r := NewCancellationRegistry(0)
+	var rootID string
+	svc := Service(func(req Request) Response {
+		rootID = req.Header.Get(RootRequestHeader)
+		return req.Response(nil)
+	}).Filter(r.Filter)
+
+	// An untrusted client can't choose its tree
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set(RootRequestHeader, "root-1")
+	require.NoError(t, svc(req).Error)
+	assert.Empty(t, rootID)
+	assert.Equal(t, "root-1", req.Header.Get(RootRequestHeader))
+}
+
+func TestCancellationRegistryReleasesRequests(t *testing.T) {
+	t.Parallel()
+	r := NewCancellationRegistry(0)
+	var downstreamCtx context.Context
+	client := Service(func(req Request) Response {
+		downstreamCtx = req.Context
+		return req.Response(nil)
+	}).Filter(r.ClientFilter)
+
+	unblock := make(chan struct{})
+	done := make(chan Response, 1)
+	svc := Service(func(req Request) Response {
+		rsp := client(NewRequest(req, "GET", "/", nil))
+		// The downstream request is released as soon as it completes, not only once the tree is cancelled
+		assert.Error(t, downstreamCtx.Err())
+		<-unblock
+		return rsp
+	}).Filter(r.Filter)
+	go func() {
+		done <- svc(NewRequest(context.Background(), "GET", "/", nil))
+	}()
+	close(unblock)
+	assert.NoError(t, (<-done).Error)
+}
+
+func TestCancellationRegistryPanic(t *testing.T) {
+	t.Parallel()
+	r := NewCancellationRegistry(0)
+	var ctx context.Context
+	svc := Service(func(req Request) Response {
+		ctx = req.Context
+		panic("boom")
+	}).Filter(r.Filter)
+
+	assert.Panics(t, func() {
+		svc(NewRequest(context.Background(), "GET", "/", nil))
+	})
+	// The tree is still cancelled and unregistered
+	assert.Error(t, ctx.Err())
+	assert.Equal(t, 0, r.Active())
+}
@@ -0,0 +1,133 @@
+package typhon
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// ChecksumTrailer is the trailer in which ChecksumTrailerFilter expects the hex-encoded SHA-256 hash of the request
+// body.
+const ChecksumTrailer = "X-Checksum-SHA256"
+
+// ChecksumTrailerFilter verifies the integrity of request bodies which declare a ChecksumTrailer. The body is hashed
+// as it is read (including by BodyBytes and Decode), and once it has been fully read, the hash is compared to the
+// trailer. On a mismatch, the read returns an error and the request is rejected with a 400 (Bad Request) response.
+//
+// Bodies which are not fully read by the Service are not verified.
+func ChecksumTrailerFilter(req Request, svc Service) Response {
+	if _, ok := req.Trailer[http.CanonicalHeaderKey(ChecksumTrailer)]; !ok || req.Body == nil {
+		return svc(req)
+	}
+	body := &checksumReader{
+		ReadCloser: req.Body,
+		hash:       sha256.New(),
+		trailer:    req.Trailer}
+	req.Body = body
+	rsp := svc(req)
+	if body.err != nil {
+		if rsp.Response != nil && rsp.Body != nil {
+			rsp.Body.Close()
+		}
+		return Response{
+			Error: body.err}
+	}
+	return rsp
+}
+
+// checksumReader hashes the body as it is read, and compares it to the checksum trailer (which net/http populates
+// once the body has been consumed) on EOF.
+type checksumReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	trailer  http.Header
+	verified bool
+	err      error
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if !r.verified {
+			r.verified = true
+			expected := r.trailer.Get(ChecksumTrailer)
+			actual := hex.EncodeToString(r.hash.Sum(nil))
+			if !strings.EqualFold(expected, actual) {
+				r.err = terrors.BadRequest("checksum_mismatch", "Request body does not match its checksum", map[string]string{
+					"expected": expected,
+					"actual":   actual})
+			}
+		}
+		if r.err != nil {
+			return n, r.err
+		}
+	}
+	return n, err
+}
+
+// digestAlgorithms are the Digest (RFC 3230) algorithms understood by DigestFilter, keyed by their lowercase names.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New}
+
+// DigestFilter verifies the integrity of request bodies which carry a Digest header (RFC 3230; eg.
+// "SHA-256=<base64>") or a legacy Content-MD5 header. The body is buffered and hashed, and if it doesn't match, the
+// request is rejected with a 400 (Bad Request) response. The body remains readable by the Service.
+//
+// MD5, SHA-256 and SHA-512 digests are supported; others are ignored. Requests without either header are passed
+// through, so apply this filter to the routes which need it.
+func DigestFilter(req Request, svc Service) Response {
+	expected := map[string]string{} // algorithm -> base64 digest
+	for _, v := range req.Header.Values("Digest") {
+		for _, d := range strings.Split(v, ",") {
+			if i := strings.IndexByte(d, '='); i > 0 {
+				alg := strings.ToLower(strings.TrimSpace(d[:i]))
+				if _, ok := digestAlgorithms[alg]; ok {
+					expected[alg] = strings.TrimSpace(d[i+1:])
+				}
+			}
+		}
+	}
+	if v := req.Header.Get("Content-MD5"); v != "" {
+		expected["md5"] = strings.TrimSpace(v)
+	}
+	if len(expected) == 0 {
+		return svc(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = req.BodyBytes(false); err != nil {
+			return Response{
+				Error: terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)}
+		}
+	}
+	for alg, digest := range expected {
+		want, err := base64.StdEncoding.DecodeString(digest)
+		if err != nil {
+			return Response{
+				Error: terrors.BadRequest("invalid_digest", "Request digest is not valid base64", map[string]string{
+					"algorithm": alg})}
+		}
+		h := digestAlgorithms[alg]()
+		h.Write(body)
+		if !bytes.Equal(want, h.Sum(nil)) {
+			return Response{
+				Error: terrors.BadRequest("digest_mismatch", "Request body does not match its digest", map[string]string{
+					"algorithm": alg})}
+		}
+	}
+	return svc(req)
+}
@@ -0,0 +1,115 @@
+package typhon
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumTrailerFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(string(b))
+	})
+	svc = svc.Filter(ChecksumTrailerFilter).Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String() + "/"
+	client := Service(BareClient).Filter(ErrorFilter)
+
+	sum := sha256.Sum256([]byte("hello world"))
+	upload := func(checksum string) Response {
+		req := NewRequest(context.Background(), "POST", url, nil)
+		// An unknown-length body is sent chunked, which is required for trailers
+		req.Body = ioutil.NopCloser(strings.NewReader("hello world"))
+		req.ContentLength = -1
+		req.Trailer = http.Header{}
+		req.Trailer.Set(ChecksumTrailer, checksum)
+		return req.SendVia(client).Response()
+	}
+
+	rsp := upload(hex.EncodeToString(sum[:]))
+	require.NoError(t, rsp.Error)
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "hello world", body)
+
+	rsp = upload(strings.Repeat("0", 64))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, "bad_request.checksum_mismatch"))
+
+	// Requests without the trailer are passed through unverified
+	rsp = NewRequest(context.Background(), "POST", url, "unverified").SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+}
+
+func TestDigestFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(string(b))
+	})
+	svc = svc.Filter(DigestFilter).Filter(ErrorFilter)
+
+	body := []byte("hello world")
+	sha := sha256.Sum256(body)
+	md := md5.Sum(body)
+	newReq := func(headers map[string]string) Request {
+		req := NewRequest(context.Background(), "POST", "/", nil)
+		req.Write(body)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+	valid := []map[string]string{
+		{"Digest": "SHA-256=" + base64.StdEncoding.EncodeToString(sha[:])},
+		{"Digest": "unknown=abc, sha-256=" + base64.StdEncoding.EncodeToString(sha[:])},
+		{"Content-MD5": base64.StdEncoding.EncodeToString(md[:])},
+		{"Digest": "UNKNOWN=abc"},
+		{}}
+	for _, headers := range valid {
+		rsp := svc(newReq(headers))
+		require.NoError(t, rsp.Error, headers)
+		var echoed string
+		require.NoError(t, rsp.Decode(&echoed))
+		assert.Equal(t, "hello world", echoed)
+	}
+
+	invalid := map[string]map[string]string{
+		"bad_request.digest_mismatch": {"Digest": "SHA-256=" + base64.StdEncoding.EncodeToString(md[:])},
+		"bad_request.invalid_digest":  {"Content-MD5": "not base64!"}}
+	for code, headers := range invalid {
+		rsp := svc(newReq(headers))
+		require.Error(t, rsp.Error, headers)
+		assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+		assert.True(t, terrors.Is(rsp.Error, code), rsp.Error.Error())
+	}
+
+	// Both headers must match if present
+	rsp := svc(newReq(map[string]string{
+		"Content-MD5": base64.StdEncoding.EncodeToString(md[:]),
+		"Digest":      "SHA-256=" + base64.StdEncoding.EncodeToString(md[:])}))
+	assert.True(t, terrors.Is(rsp.Error, "bad_request.digest_mismatch"))
+}
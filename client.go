@@ -1,7 +1,12 @@
 package typhon
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net"
 	"net/http"
+	"syscall"
 	"time"
 
 	"github.com/monzo/terrors"
@@ -18,8 +23,48 @@ var (
 		DisableCompression:  false,
 		IdleConnTimeout:     10 * time.Minute,
 		MaxIdleConnsPerHost: 10}
+	// TransportErrorCode determines the terrors code of errors encountered while round-tripping requests in
+	// HttpService (ie. where no response was received from the remote). This makes it possible for a service acting
+	// as a gateway to return a meaningful status code (eg. 504 Gateway Timeout) upstream rather than a generic 500.
+	// Like Client, it MUST only be overridden before use.
+	TransportErrorCode func(err error) string = DefaultTransportErrorCode
 )
 
+// DefaultTransportErrorCode maps transport errors to terrors codes:
+//
+//  * Timeouts map to terrors.ErrTimeout (504)
+//  * Failure to connect to the remote (including DNS failures) maps to ErrServiceUnavailable (503)
+//  * The connection being closed or reset before a response is received maps to ErrBadGateway (502)
+//  * Everything else, including cancellation, maps to terrors.ErrInternalService (500)
+func DefaultTransportErrorCode(err error) string {
+	var netErr net.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return terrors.ErrInternalService
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return terrors.ErrTimeout
+	case errors.As(err, &dnsErr), errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrServiceUnavailable
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET):
+		return ErrBadGateway
+	}
+	return terrors.ErrInternalService
+}
+
+type roundTripperContextKeyType struct{}
+
+var roundTripperContextKey = roundTripperContextKeyType{}
+
+// WithRoundTripper returns a copy of the passed context which makes HttpService (and so BareClient) send requests with
+// that context via rt, instead of its own RoundTripper. This lets a single client send different requests through
+// different transports, eg. with different TLS configurations or proxies. Note that requests whose contexts derive
+// from the returned context are also affected.
+func WithRoundTripper(ctx context.Context, rt http.RoundTripper) context.Context {
+	return context.WithValue(ctx, roundTripperContextKey, rt)
+}
+
 // A ResponseFuture is a container for a Response which will materialise at some point.
 type ResponseFuture struct {
 	done <-chan struct{} // guards access to r
@@ -37,12 +82,16 @@ func (f *ResponseFuture) Response() Response {
 	return f.r
 }
 
-// HttpService returns a Service which sends requests via the given net/http RoundTripper.
-// Only use this if you need to do something custom at the transport level.
+// HttpService returns a Service which sends requests via the given net/http RoundTripper, unless a request's context
+// specifies another (see WithRoundTripper). Only use this if you need to do something custom at the transport level.
 func HttpService(rt http.RoundTripper) Service {
 	return func(req Request) Response {
 		ctx := req.unwrappedContext()
-		httpRsp, err := rt.RoundTrip(req.Request.WithContext(ctx))
+		transport := rt
+		if override, ok := ctx.Value(roundTripperContextKey).(http.RoundTripper); ok && override != nil {
+			transport = override
+		}
+		httpRsp, err := transport.RoundTrip(req.Request.WithContext(ctx))
 		// When the calling context is cancelled, close the response body
 		// This protects callers that forget to call Close(), or those which proxy responses upstream
 		//
@@ -62,8 +111,15 @@ func HttpService(rt http.RoundTripper) Service {
 		return Response{
 			Request:  &req,
 			Response: httpRsp,
-			Error:    terrors.Wrap(err, nil)}
+			Error:    wrapTransportError(err)}
+	}
+}
+
+func wrapTransportError(err error) error {
+	if err == nil {
+		return nil
 	}
+	return terrors.WrapWithCode(err, nil, TransportErrorCode(err))
 }
 
 // BareClient is the most basic way to send a request, using the default http RoundTripper
@@ -0,0 +1,132 @@
+package typhon
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultTransportErrorCode(t *testing.T) {
+	t.Parallel()
+
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	cases := map[error]string{
+		errors.New("boom"):                              terrors.ErrInternalService,
+		context.Canceled:                                terrors.ErrInternalService,
+		context.DeadlineExceeded:                        terrors.ErrTimeout,
+		io.EOF:                                          ErrBadGateway,
+		&net.DNSError{Err: "no such host"}:              ErrServiceUnavailable,
+		&url.Error{Op: "Get", Err: dialErr}:             ErrServiceUnavailable,
+		&url.Error{Op: "Get", Err: io.ErrUnexpectedEOF}: ErrBadGateway}
+	for err, expected := range cases {
+		assert.Equal(t, expected, DefaultTransportErrorCode(err), err.Error())
+	}
+}
+
+func TestHttpServiceTransportErrors(t *testing.T) {
+	t.Parallel()
+
+	client := Service(HttpService(&http.Transport{})).Filter(ErrorFilter)
+
+	// Nothing listening: 503
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+	rsp := NewRequest(context.Background(), "GET", "http://"+addr+"/", nil).SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, ErrServiceUnavailable))
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+
+	// Accepts connections but never responds: 504
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rsp = NewRequest(ctx, "GET", "http://"+l.Addr().String()+"/", nil).SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrTimeout))
+	assert.Equal(t, http.StatusGatewayTimeout, rsp.StatusCode)
+}
+
+// TestHttpServiceDeadlinePropagation verifies that a downstream request made on behalf of an incoming one inherits its
+// deadline, and is cancelled when it expires
+func TestHttpServiceDeadlinePropagation(t *testing.T) {
+	t.Parallel()
+
+	cancelled := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		select {
+		case <-req.Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+		return req.Response(nil)
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	client := Service(BareClient).Filter(ErrorFilter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	parent := NewRequest(ctx, "GET", "/", nil)
+	start := time.Now()
+	rsp := NewRequest(parent, "GET", "http://"+s.Listener().Addr().String()+"/", nil).SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrTimeout))
+	assert.True(t, time.Since(start) < time.Second)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		assert.Fail(t, "downstream request was not cancelled")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHttpServiceRoundTripperOverride(t *testing.T) {
+	t.Parallel()
+
+	transport := func(name string) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			rsp := NewResponse(Request{})
+			rsp.Header.Set("X-Transport", name)
+			return rsp.Response, nil
+		})
+	}
+	svc := HttpService(transport("default"))
+
+	rsp := svc(NewRequest(context.Background(), "GET", "http://example.com/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "default", rsp.Header.Get("X-Transport"))
+
+	ctx := WithRoundTripper(context.Background(), transport("mtls"))
+	rsp = svc(NewRequest(ctx, "GET", "http://example.com/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "mtls", rsp.Header.Get("X-Transport"))
+}
@@ -0,0 +1,55 @@
+package typhon
+
+import (
+	"context"
+	"strings"
+)
+
+// A Codec is a serialisation format which Response.Encode can use for structured response bodies.
+type Codec string
+
+const (
+	// CodecJSON serialises responses as JSON (using protojson for protobuf messages).
+	CodecJSON Codec = "json"
+	// CodecProtobuf serialises protobuf messages in the protobuf wire format. Other values are still sent as JSON.
+	CodecProtobuf Codec = "protobuf"
+)
+
+// CodecHeader is the request header which CodecOverrideFilter translates into a codec override.
+const CodecHeader = "X-Typhon-Codec"
+
+type codecContextKeyType struct{}
+
+var codecContextKey = codecContextKeyType{}
+
+// WithCodec returns a copy of the passed context which makes Response.Encode use the passed codec for responses to
+// requests with that context, regardless of their Accept header. This is mostly useful for debugging, eg. to get
+// human-readable output from an endpoint which would otherwise respond with protobuf.
+func WithCodec(ctx context.Context, c Codec) context.Context {
+	return context.WithValue(ctx, codecContextKey, c)
+}
+
+// CodecOverrideFilter overrides the codec of responses (see WithCodec) for requests with a CodecHeader naming a known
+// Codec, so tools can request a particular format. Requests without the header (or with an unknown codec) are
+// unaffected.
+func CodecOverrideFilter(req Request, svc Service) Response {
+	switch c := Codec(strings.ToLower(req.Header.Get(CodecHeader))); c {
+	case CodecJSON, CodecProtobuf:
+		req.Context = WithCodec(req.Context, c)
+	}
+	return svc(req)
+}
+
+// encodesProtobuf returns whether a response to the request should be encoded as protobuf (where possible): either
+// because a codec override says so, or because the client accepts it.
+func encodesProtobuf(req *Request) bool {
+	if req == nil {
+		return false
+	}
+	if req.Context != nil {
+		if c, ok := req.Context.Value(codecContextKey).(Codec); ok {
+			return c == CodecProtobuf
+		}
+	}
+	return strings.Contains(req.Header.Get("Accept"), "application/protobuf")
+}
@@ -0,0 +1,42 @@
+package typhon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/monzo/typhon/prototest"
+)
+
+func TestCodecOverride(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response(&prototest.Greeting{
+			Message: "Hello world!"})
+	}).Filter(CodecOverrideFilter)
+
+	cases := []struct {
+		accept, codec, contentType string
+	}{
+		{"", "", "application/json"},
+		{"application/protobuf", "", "application/protobuf"},
+		{"application/protobuf", "json", "application/json"},
+		{"application/protobuf", "JSON", "application/json"},
+		{"application/json", "protobuf", "application/protobuf"},
+		{"application/protobuf", "yaml", "application/protobuf"}}
+	for _, c := range cases {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		req.Header.Set("Accept", c.accept)
+		req.Header.Set(CodecHeader, c.codec)
+		rsp := svc(req)
+		assert.Equal(t, c.contentType, rsp.Header.Get("Content-Type"), "%+v", c)
+	}
+
+	// The override can also be set directly in the context
+	req := NewRequest(WithCodec(context.Background(), CodecJSON), "GET", "/", nil)
+	req.Header.Set("Accept", "application/protobuf")
+	rsp := req.Response(&prototest.Greeting{})
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+}
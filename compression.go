@@ -0,0 +1,351 @@
+package typhon
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// A CompressionWriter compresses the data written to it. Flush must write out everything which has been written so
+// far, so that streamed responses are delivered incrementally.
+type CompressionWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// contentCoding implements a content coding for CompressionFilter.
+type contentCoding struct {
+	newWriter func(w io.Writer) CompressionWriter
+	newReader func(r io.Reader) (io.Reader, error)
+}
+
+var (
+	// contentCodings are the content codings understood by CompressionFilter, keyed by name.
+	contentCodings = map[string]contentCoding{
+		"gzip": {
+			newWriter: func(w io.Writer) CompressionWriter { return gzip.NewWriter(w) },
+			newReader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		"deflate": {
+			newWriter: func(w io.Writer) CompressionWriter { return zlib.NewWriter(w) },
+			newReader: func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }}}
+
+	// contentCodingPreference is the order in which CompressionFilter prefers content codings, when they are
+	// registered and the client accepts them equally.
+	contentCodingPreference = []string{"br", "gzip", "deflate"}
+)
+
+// RegisterContentCoding makes a content coding available to CompressionFilter, or replaces a built-in one. gzip and
+// deflate are built in; Brotli isn't, as the standard library has no implementation of it, but it can be registered
+// as "br" using a third-party package, in which case it's preferred over the others. Codings other than br, gzip and
+// deflate are least preferred, in the order they are registered.
+//
+// newReader's reader is closed after use if it implements io.Closer. Like Client, this MUST only be called before use
+// takes place; access is not synchronised.
+func RegisterContentCoding(name string, newWriter func(w io.Writer) CompressionWriter,
+	newReader func(r io.Reader) (io.Reader, error)) {
+	name = strings.ToLower(name)
+	preferred := false
+	for _, p := range contentCodingPreference {
+		preferred = preferred || p == name
+	}
+	if !preferred {
+		contentCodingPreference = append(contentCodingPreference, name)
+	}
+	contentCodings[name] = contentCoding{
+		newWriter: newWriter,
+		newReader: newReader}
+}
+
+// DefaultCompressibleContentTypes is used by CompressionFilter when no allowlist is configured. Types which are
+// already compressed (images, video, archives, etc.) are deliberately absent.
+var DefaultCompressibleContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/protobuf",
+	"application/x-protobuf",
+	"image/svg+xml"}
+
+// CompressionOptions configures a CompressionFilter.
+type CompressionOptions struct {
+	// MinSize is the smallest response body, in bytes, which will be compressed. Bodies whose length is unknown (ie.
+	// which are streamed) are always eligible.
+	MinSize int64
+	// ContentTypes is an allowlist of media types whose responses may be compressed. A type may be a wildcard like
+	// "text/*". If empty, DefaultCompressibleContentTypes is used.
+	ContentTypes []string
+}
+
+// CompressionFilter returns a Filter which negotiates compression in both directions: request bodies are decompressed
+// according to their Content-Encoding, and response bodies are compressed according to the request's
+// Accept-Encoding. gzip and deflate are supported, and others (notably Brotli) can be added with
+// RegisterContentCoding; when the client accepts several equally, br is preferred, then gzip, then deflate.
+//
+// Requests using a content coding which isn't supported are rejected with 415 (Unsupported Media Type).
+func CompressionFilter(opts CompressionOptions) Filter {
+	contentTypes := opts.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultCompressibleContentTypes
+	}
+
+	return func(req Request, svc Service) Response {
+		if enc := req.Header.Get("Content-Encoding"); enc != "" && req.Body != nil {
+			body, err := decompressingReader(enc, req.Body)
+			if err != nil {
+				rsp := NewResponse(req)
+				if err == errUnsupportedEncoding {
+					rsp.Error = terrors.New(ErrUnsupportedMediaType, "Unsupported Content-Encoding", map[string]string{
+						"content_encoding": enc})
+				} else {
+					rsp.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+				}
+				return rsp
+			}
+			req.SetBodyReader(body, -1)
+			req.Header.Del("Content-Encoding")
+		}
+
+		rsp := svc(req)
+		if rsp.Error != nil || rsp.Response == nil || rsp.Body == nil {
+			return rsp
+		}
+		if !compressibleContentType(rsp.Header.Get("Content-Type"), contentTypes) {
+			return rsp
+		}
+		// The representation now depends on the Accept-Encoding header, whether or not we compress this time
+		addVary(rsp.Header, "Accept-Encoding")
+		// Compressing part of a body would make its Content-Range meaningless
+		if rsp.Header.Get("Content-Encoding") != "" ||
+			rsp.Header.Get("Content-Range") != "" ||
+			rsp.StatusCode == http.StatusPartialContent ||
+			rsp.StatusCode == http.StatusNoContent ||
+			rsp.StatusCode == http.StatusNotModified ||
+			(rsp.ContentLength >= 0 && rsp.ContentLength < opts.MinSize) {
+			return rsp
+		}
+		if enc := negotiateEncoding(req.Header.Get("Accept-Encoding")); enc != "" {
+			compressResponse(&rsp, enc)
+		}
+		return rsp
+	}
+}
+
+// negotiateEncoding picks the most preferable supported content coding from the passed Accept-Encoding header value,
+// or returns an empty string if none is acceptable.
+func negotiateEncoding(accept string) string {
+	if accept == "" {
+		return ""
+	}
+	qs := make(map[string]float64, len(contentCodingPreference))
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qs[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range contentCodingPreference {
+		if _, ok := contentCodings[enc]; !ok {
+			continue
+		}
+		q, ok := qs[enc]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+func compressibleContentType(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, a := range allowed {
+		if a == mediaType || (strings.HasSuffix(a, "/*") && strings.HasPrefix(mediaType, a[:len(a)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds a field name to the Vary header, unless it (or "*") is already present.
+func addVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+func newCompressor(enc string, w io.Writer) CompressionWriter {
+	c, ok := contentCodings[enc]
+	if !ok {
+		panic("unsupported content coding " + enc)
+	}
+	return c.newWriter(w)
+}
+
+// compressResponse replaces the response body with its compressed equivalent. Buffered bodies are compressed up-front
+// so their length remains known; anything else is compressed as it is streamed.
+func compressResponse(rsp *Response, enc string) {
+	rsp.Header.Set("Content-Encoding", enc)
+	rsp.Header.Del("Content-Length")
+
+	if buf, ok := rsp.Body.(*bufCloser); ok {
+		out := &bufCloser{}
+		w := newCompressor(enc, out)
+		w.Write(buf.Bytes()) // writes to a bytes.Buffer can't fail
+		w.Close()
+		rsp.Body = out
+		rsp.ContentLength = int64(out.Len())
+		if rsp.ContentLength >= chunkThreshold {
+			rsp.ContentLength = -1
+		}
+		return
+	}
+
+	body := rsp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		w := newCompressor(enc, pw)
+		buf := *httpChunkBufPool.Get().(*[]byte)
+		defer httpChunkBufPool.Put(&buf)
+		var err error
+		for err == nil {
+			var n int
+			n, err = body.Read(buf)
+			if n > 0 {
+				if _, werr := w.Write(buf[:n]); werr != nil {
+					err = werr
+					break
+				}
+				// Flush so that streamed responses are delivered incrementally rather than when the compressor's
+				// internal buffers fill
+				if ferr := w.Flush(); ferr != nil {
+					err = ferr
+				}
+			}
+		}
+		if err == io.EOF {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	rsp.Body = pr
+	rsp.ContentLength = -1
+}
+
+// A FlushStreamerWriter is a StreamerWriter which may buffer data written to it until it is flushed.
+type FlushStreamerWriter interface {
+	StreamerWriter
+	Flush() error
+}
+
+// GzipStreamer makes the response's body a stream which is gzip-compressed as it is written to the returned writer,
+// and sets the Content-Encoding accordingly; the body is sent with chunked transfer encoding. Compressed data is held
+// by the compressor until Flush is called (or its buffer fills), so the writer controls when the client receives data:
+// flushing more often reduces latency at the expense of compression ratio. The writer must be closed once the body is
+// complete, which writes the final compressed block.
+//
+// The client must accept gzip, so check the request's Accept-Encoding before using this.
+func (r *Response) GzipStreamer() FlushStreamerWriter {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	s := Streamer().(*streamer)
+	r.Body = s
+	r.ContentLength = -1
+	r.Header.Set("Content-Encoding", "gzip")
+	r.Header.Del("Content-Length")
+	return &gzipStreamer{
+		streamer: s,
+		w:        gzip.NewWriter(s.pipeW)}
+}
+
+type gzipStreamer struct {
+	*streamer
+	w *gzip.Writer
+}
+
+func (s *gzipStreamer) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *gzipStreamer) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *gzipStreamer) Close() error {
+	if err := s.w.Close(); err != nil {
+		return s.streamer.CloseWithError(err)
+	}
+	return s.streamer.Close()
+}
+
+// multiCloser is an io.ReadCloser which closes several underlying resources
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (c multiCloser) Close() error {
+	var err error
+	for _, cl := range c.closers {
+		if cerr := cl.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// errUnsupportedEncoding is returned by decompressingReader for content codings it doesn't understand
+var errUnsupportedEncoding = errors.New("unsupported content coding")
+
+// decompressingReader returns a reader which decompresses the passed body according to the passed Content-Encoding.
+// Closing it closes the underlying body.
+func decompressingReader(enc string, body io.ReadCloser) (io.ReadCloser, error) {
+	enc = strings.ToLower(strings.TrimSpace(enc))
+	switch enc {
+	case "", "identity":
+		return body, nil
+	case "x-gzip":
+		enc = "gzip"
+	}
+	c, ok := contentCodings[enc]
+	if !ok {
+		return nil, errUnsupportedEncoding
+	}
+	r, err := c.newReader(body)
+	if err != nil {
+		return nil, err
+	}
+	if rc, ok := r.(io.Closer); ok {
+		return multiCloser{r, []io.Closer{rc, body}}, nil
+	}
+	return multiCloser{r, []io.Closer{body}}, nil
+}
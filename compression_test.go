@@ -0,0 +1,287 @@
+package typhon
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"":                       "",
+		"identity":               "",
+		"gzip":                   "gzip",
+		"deflate":                "deflate",
+		"deflate, gzip":          "gzip",
+		"gzip;q=0.5, deflate":    "deflate",
+		"gzip;q=0, *":            "deflate",
+		"*":                      "gzip",
+		"br":                     "",
+		"GZIP;q=0.1, identity":   "gzip",
+		"gzip;q=0, deflate;q=0 ": ""}
+	for accept, expected := range cases {
+		assert.Equal(t, expected, negotiateEncoding(accept), accept)
+	}
+}
+
+func TestCompressionFilter_Response(t *testing.T) {
+	t.Parallel()
+
+	body := strings.Repeat("compress me ", 100)
+	svc := Service(func(req Request) Response {
+		switch req.URL.Path {
+		case "/image":
+			rsp := req.Response(nil)
+			rsp.Header.Set("Content-Type", "image/png")
+			rsp.Write([]byte(body))
+			return rsp
+		case "/small":
+			return req.Response("tiny")
+		}
+		return req.Response(body)
+	})
+	svc = svc.Filter(CompressionFilter(CompressionOptions{
+		MinSize: 100}))
+
+	// gzip
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "gzip", rsp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rsp.Header.Get("Vary"))
+	raw := rsp.Body.(*bufCloser).Bytes()
+	assert.EqualValues(t, len(raw), rsp.ContentLength)
+	gr, err := gzip.NewReader(bytes.NewReader(raw))
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(gr)
+	require.NoError(t, err)
+	assert.Equal(t, `"`+body+`"`+"\n", string(b))
+	// BodyBytes decompresses transparently
+	b, err = rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, `"`+body+`"`+"\n", string(b))
+
+	// deflate
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "deflate")
+	rsp = svc(req)
+	assert.Equal(t, "deflate", rsp.Header.Get("Content-Encoding"))
+	zr, err := zlib.NewReader(rsp.Body)
+	require.NoError(t, err)
+	b, err = ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, `"`+body+`"`+"\n", string(b))
+
+	// Not accepted
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	rsp = svc(req)
+	assert.Empty(t, rsp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rsp.Header.Get("Vary"))
+
+	// Below the size threshold
+	req = NewRequest(context.Background(), "GET", "/small", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp = svc(req)
+	assert.Empty(t, rsp.Header.Get("Content-Encoding"))
+
+	// Content type not in the allowlist
+	req = NewRequest(context.Background(), "GET", "/image", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp = svc(req)
+	assert.Empty(t, rsp.Header.Get("Content-Encoding"))
+	assert.Empty(t, rsp.Header.Get("Vary"))
+
+	// Vary isn't duplicated when the filter is stacked, or the handler already varies on Accept-Encoding
+	stacked := Service(func(req Request) Response {
+		rsp := req.Response(body)
+		rsp.Header.Set("Vary", "Origin, accept-encoding")
+		return rsp
+	}).Filter(CompressionFilter(CompressionOptions{})).Filter(CompressionFilter(CompressionOptions{}))
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp = stacked(req)
+	assert.Equal(t, []string{"Origin, accept-encoding"}, rsp.Header.Values("Vary"))
+	rsp = svc.Filter(CompressionFilter(CompressionOptions{}))(req)
+	assert.Equal(t, []string{"Accept-Encoding"}, rsp.Header.Values("Vary"))
+}
+
+func TestRegisterContentCoding(t *testing.T) {
+	// Not parallel, as it modifies the registered codings
+	defer func() {
+		delete(contentCodings, "br")
+	}()
+	// A stand-in for Brotli
+	RegisterContentCoding("br",
+		func(w io.Writer) CompressionWriter { return gzip.NewWriter(w) },
+		func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) })
+
+	assert.Equal(t, "br", negotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, "br", negotiateEncoding("*"))
+	assert.Equal(t, "gzip", negotiateEncoding("gzip, br;q=0.5"))
+
+	svc := Service(func(req Request) Response {
+		v := map[string]string{}
+		if err := req.Decode(&v); err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(v)
+	})
+	svc = svc.Filter(CompressionFilter(CompressionOptions{})).Filter(ErrorFilter)
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	gw.Write([]byte(`{"a":"b"}`))
+	gw.Close()
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Encoding", "br")
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	req.Body = ioutil.NopCloser(buf)
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "br", rsp.Header.Get("Content-Encoding"))
+	v := map[string]string{}
+	require.NoError(t, rsp.Decode(&v))
+	assert.Equal(t, map[string]string{"a": "b"}, v)
+}
+
+func TestCompressionFilter_StreamingResponse(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			for i := 0; i < 10; i++ {
+				s.Write([]byte("chunk"))
+			}
+		}()
+		rsp := req.Response(s)
+		rsp.Header.Set("Content-Type", "text/plain")
+		return rsp
+	})
+	svc = svc.Filter(CompressionFilter(CompressionOptions{}))
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rsp := svc(req)
+	assert.Equal(t, "gzip", rsp.Header.Get("Content-Encoding"))
+	assert.EqualValues(t, -1, rsp.ContentLength)
+	gr, err := gzip.NewReader(rsp.Body)
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(gr)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("chunk", 10), string(b))
+}
+
+func TestCompressionFilter_Request(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		v := map[string]string{}
+		if err := req.Decode(&v); err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(v)
+	})
+	svc = svc.Filter(CompressionFilter(CompressionOptions{})).Filter(ErrorFilter)
+
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	gw.Write([]byte(`{"a":"b"}`))
+	gw.Close()
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Body = ioutil.NopCloser(buf)
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	v := map[string]string{}
+	require.NoError(t, rsp.Decode(&v))
+	assert.Equal(t, map[string]string{"a": "b"}, v)
+
+	// Unsupported encodings are rejected
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Encoding", "br")
+	req.Body = ioutil.NopCloser(strings.NewReader("whatever"))
+	rsp = svc(req)
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, ErrUnsupportedMediaType))
+
+	// Corrupt bodies are rejected
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Body = ioutil.NopCloser(strings.NewReader("not gzip"))
+	rsp = svc(req)
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
+
+func TestResponseGzipStreamer(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.Header.Set("Content-Type", "text/plain")
+		w := rsp.GzipStreamer()
+		go func() {
+			defer w.Close()
+			w.Write([]byte("first"))
+			w.Flush()
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+			}
+			w.Write([]byte(" second"))
+		}()
+		return rsp
+	})
+	// CompressionFilter must leave the already-compressed body alone
+	svc = svc.Filter(CompressionFilter(CompressionOptions{}))
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	req, _ := http.NewRequest("GET", "http://"+s.Listener().Addr().String()+"/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	httpRsp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer httpRsp.Body.Close()
+	assert.Equal(t, "gzip", httpRsp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"chunked"}, httpRsp.TransferEncoding)
+
+	gr, err := gzip.NewReader(httpRsp.Body)
+	require.NoError(t, err)
+	first := make([]byte, 5)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(gr, first)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+		assert.Equal(t, "first", string(first))
+	case <-time.After(time.Second):
+		require.FailNow(t, "flushed data was not received")
+	}
+	close(received)
+
+	rest, err := ioutil.ReadAll(gr)
+	require.NoError(t, err)
+	assert.Equal(t, " second", string(rest))
+}
@@ -0,0 +1,163 @@
+package typhon
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// SetIfModifiedSince makes the request conditional on the resource having been modified after the passed time (usually
+// the Last-Modified time of a cached representation). If it hasn't, the server may respond with 304 (Not Modified);
+// see Response.NotModified.
+func (r *Request) SetIfModifiedSince(t time.Time) {
+	r.Header.Set("If-Modified-Since", t.UTC().Format(http.TimeFormat))
+}
+
+// SetIfNoneMatch makes the request conditional on the resource's current entity tag not matching any of those passed
+// (usually the ETag of a cached representation). If one matches, the server may respond with 304 (Not Modified); see
+// Response.NotModified. Tags must be quoted, as they appear in the ETag header.
+func (r *Request) SetIfNoneMatch(etags ...string) {
+	r.Header.Set("If-None-Match", strings.Join(etags, ", "))
+}
+
+// NotModified returns whether the response is a 304 (Not Modified) reply to a conditional request, meaning the
+// client's cached representation is still valid and may be reused. The response has no body; its headers (eg.
+// Cache-Control, Expires and ETag) should be used to update the cached representation's metadata.
+func (r Response) NotModified() bool {
+	return r.Error == nil && r.Response != nil && r.StatusCode == http.StatusNotModified
+}
+
+// ETagsMatchWeak reports whether two entity tags match under the weak comparison function of RFC 7232 §2.3.2: their
+// opaque tags are equal, whether or not either is weak (W/"..."). Weak comparison is used for If-None-Match, as a
+// semantically equivalent representation is as good as an identical one for a cache.
+func ETagsMatchWeak(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// ETagsMatchStrong reports whether two entity tags match under the strong comparison function of RFC 7232 §2.3.2:
+// neither is weak, and their opaque tags are equal. Strong comparison is used for If-Match, which guards writes.
+func ETagsMatchStrong(a, b string) bool {
+	return !strings.HasPrefix(a, "W/") && a == b
+}
+
+// CheckETag evaluates the request's If-Match and If-None-Match preconditions against etag, the current entity tag of
+// the target resource (or "" if it doesn't exist), in the order RFC 7232 §6 requires. If-Match uses strong
+// comparison and If-None-Match weak comparison (see ETagsMatchStrong and ETagsMatchWeak).
+//
+// ok is true if the request should be processed. Otherwise rsp is the response to send instead: a 304 (Not Modified)
+// for a GET or HEAD request whose If-None-Match matches, or a 412 (Precondition Failed) error.
+func (r Request) CheckETag(etag string) (rsp Response, ok bool) {
+	if h := r.Header.Get("If-Match"); h != "" && !etagListMatches(h, etag, ETagsMatchStrong) {
+		return Response{
+			Request: &r,
+			Error:   terrors.PreconditionFailed("if_match", "If-Match precondition failed", nil)}, false
+	}
+	if h := r.Header.Get("If-None-Match"); h != "" && etagListMatches(h, etag, ETagsMatchWeak) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			rsp := NewResponseWithCode(r, http.StatusNotModified)
+			if etag != "" {
+				rsp.Header.Set("ETag", etag)
+			}
+			return rsp, false
+		}
+		return Response{
+			Request: &r,
+			Error:   terrors.PreconditionFailed("if_none_match", "If-None-Match precondition failed", nil)}, false
+	}
+	return Response{}, true
+}
+
+// CheckLastModified evaluates the request's If-Modified-Since precondition against modTime, the time the target
+// resource was last modified. Times are compared to the second, as HTTP-dates are no more precise. As RFC 7232 §3.3
+// requires, the precondition only applies to GET and HEAD requests without an If-None-Match header (which takes
+// precedence; see CheckETag), and a missing or malformed date is ignored, as is a zero modTime.
+//
+// ok is true if the request should be processed. Otherwise rsp is a 304 (Not Modified) response to send instead, with
+// a Last-Modified header.
+func (r Request) CheckLastModified(modTime time.Time) (rsp Response, ok bool) {
+	if modTime.IsZero() || (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+		r.Header.Get("If-None-Match") != "" {
+		return Response{}, true
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modTime.Truncate(time.Second).After(since) {
+		return Response{}, true
+	}
+	rsp = NewResponseWithCode(r, http.StatusNotModified)
+	rsp.Header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	return rsp, false
+}
+
+// LastModifiedFilter returns a Filter for resources whose last modification time is cheap to find (by calling
+// lastModified) but expensive to render. Requests whose If-Modified-Since shows the client's copy to be current are
+// answered with a 304 (Not Modified) response without calling the Service; see CheckLastModified. Other successful
+// responses get a Last-Modified header, unless the Service sets its own. If lastModified fails, its error is returned.
+func LastModifiedFilter(lastModified func(req Request) (time.Time, error)) Filter {
+	return func(req Request, svc Service) Response {
+		modTime, err := lastModified(req)
+		if err != nil {
+			return Response{
+				Request: &req,
+				Error:   terrors.Wrap(err, nil)}
+		}
+		if rsp, ok := req.CheckLastModified(modTime); !ok {
+			return rsp
+		}
+		rsp := svc(req)
+		if rsp.Error == nil && rsp.Response != nil && !modTime.IsZero() && rsp.Header.Get("Last-Modified") == "" {
+			rsp.Header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+		}
+		return rsp
+	}
+}
+
+// etagListMatches reports whether any of the entity tags in a precondition header match etag using the passed
+// comparison function. "*" matches any current entity tag.
+func etagListMatches(h, etag string, match func(a, b string) bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(h) == "*" {
+		return true
+	}
+	for _, tag := range parseETagList(h) {
+		if match(tag, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseETagList splits a comma-separated list of entity tags. Opaque tags may themselves contain commas, so the list is
+// split on the quotes; malformed entries are skipped.
+func parseETagList(h string) []string {
+	var tags []string
+	for {
+		h = strings.TrimLeft(h, " \t,")
+		if h == "" {
+			return tags
+		}
+		start := 0
+		if strings.HasPrefix(h, "W/") {
+			start = 2
+		}
+		if len(h) <= start || h[start] != '"' {
+			// Skip to the next entry
+			i := strings.IndexByte(h, ',')
+			if i < 0 {
+				return tags
+			}
+			h = h[i:]
+			continue
+		}
+		end := strings.IndexByte(h[start+1:], '"')
+		if end < 0 {
+			return tags
+		}
+		end += start + 2
+		tags = append(tags, h[:end])
+		h = h[end:]
+	}
+}
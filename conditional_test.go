@@ -0,0 +1,166 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalGet(t *testing.T) {
+	t.Parallel()
+
+	modified := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	svc := Service(func(req Request) Response {
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			rsp := req.Response(nil)
+			rsp.StatusCode = http.StatusNotModified
+			return rsp
+		}
+		rsp := req.Response("body")
+		rsp.Header.Set("ETag", `"v1"`)
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String() + "/"
+	client := Service(BareClient).Filter(ErrorFilter)
+
+	rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(client).Response()
+	require.NoError(t, rsp.Err())
+	assert.False(t, rsp.NotModified())
+	etag := rsp.Header.Get("ETag")
+
+	req := NewRequest(context.Background(), "GET", url, nil)
+	req.SetIfNoneMatch(etag)
+	rsp = req.SendVia(client).Response()
+	require.NoError(t, rsp.Err())
+	assert.True(t, rsp.NotModified())
+
+	req = NewRequest(context.Background(), "GET", url, nil)
+	req.SetIfModifiedSince(modified.In(time.FixedZone("CET", 3600)))
+	req.SetIfNoneMatch(`"v1"`, `W/"v0"`)
+	assert.Equal(t, "Sat, 02 Jan 2021 03:04:05 GMT", req.Header.Get("If-Modified-Since"))
+	assert.Equal(t, `"v1", W/"v0"`, req.Header.Get("If-None-Match"))
+
+	assert.False(t, Response{}.NotModified())
+}
+
+func TestETagComparison(t *testing.T) {
+	t.Parallel()
+	// The examples from RFC 7232 §2.3.2
+	cases := []struct {
+		a, b         string
+		strong, weak bool
+	}{
+		{`W/"1"`, `W/"1"`, false, true},
+		{`W/"1"`, `W/"2"`, false, false},
+		{`W/"1"`, `"1"`, false, true},
+		{`"1"`, `"1"`, true, true}}
+	for _, c := range cases {
+		assert.Equal(t, c.strong, ETagsMatchStrong(c.a, c.b), "%s %s", c.a, c.b)
+		assert.Equal(t, c.weak, ETagsMatchWeak(c.a, c.b), "%s %s", c.a, c.b)
+	}
+
+	assert.Equal(t, []string{`"a"`, `W/"b,c"`, `"d"`}, parseETagList(` "a",W/"b,c" , bogus, "d"`))
+}
+
+func TestRequestCheckETag(t *testing.T) {
+	t.Parallel()
+	check := func(method, header, value, etag string) (int, bool) {
+		req := NewRequest(context.Background(), method, "/", nil)
+		req.Header.Set(header, value)
+		rsp, ok := req.CheckETag(etag)
+		if ok {
+			return 0, true
+		}
+		if rsp.Error != nil {
+			return ErrorStatusCode(rsp.Error), false
+		}
+		return rsp.StatusCode, false
+	}
+
+	// If-None-Match uses weak comparison, so a CDN's weak tag still revalidates a strong one
+	status, ok := check("GET", "If-None-Match", `W/"v1"`, `"v1"`)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusNotModified, status)
+	status, _ = check("HEAD", "If-None-Match", `"v0", "v1"`, `W/"v1"`)
+	assert.Equal(t, http.StatusNotModified, status)
+	_, ok = check("GET", "If-None-Match", `W/"v0"`, `"v1"`)
+	assert.True(t, ok)
+	status, _ = check("PUT", "If-None-Match", `*`, `"v1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	_, ok = check("PUT", "If-None-Match", `*`, "")
+	assert.True(t, ok)
+
+	// If-Match uses strong comparison, so weak tags never match
+	_, ok = check("PUT", "If-Match", `"v1"`, `"v1"`)
+	assert.True(t, ok)
+	status, ok = check("PUT", "If-Match", `W/"v1"`, `"v1"`)
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	_, ok = check("PUT", "If-Match", `"v1"`, `W/"v1"`)
+	assert.False(t, ok)
+	_, ok = check("PUT", "If-Match", `*`, `W/"v1"`)
+	assert.True(t, ok)
+	_, ok = check("PUT", "If-Match", `*`, "")
+	assert.False(t, ok)
+
+	// Requests without preconditions always proceed
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	_, ok = req.CheckETag(`"v1"`)
+	assert.True(t, ok)
+}
+
+func TestLastModifiedFilter(t *testing.T) {
+	t.Parallel()
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 500000000, time.UTC)
+	calls := 0
+	svc := Service(func(req Request) Response {
+		calls++
+		return req.Response("expensive")
+	}).Filter(LastModifiedFilter(func(req Request) (time.Time, error) {
+		return modTime, nil
+	}))
+	send := func(method string, header map[string]string) Response {
+		req := NewRequest(context.Background(), method, "/", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return svc(req)
+	}
+
+	rsp := send("GET", nil)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "Tue, 01 Jun 2021 12:00:00 GMT", rsp.Header.Get("Last-Modified"))
+	assert.Equal(t, 1, calls)
+
+	// A current copy (even one dated by the truncated Last-Modified) isn't re-rendered
+	for _, since := range []string{"Tue, 01 Jun 2021 12:00:00 GMT", "Wednesday, 02-Jun-21 00:00:00 GMT"} {
+		rsp = send("GET", map[string]string{"If-Modified-Since": since})
+		assert.True(t, rsp.NotModified(), since)
+		assert.Equal(t, "Tue, 01 Jun 2021 12:00:00 GMT", rsp.Header.Get("Last-Modified"))
+	}
+	assert.Equal(t, 1, calls)
+
+	// Stale copies, malformed dates, other methods and requests with If-None-Match proceed
+	for _, c := range []struct {
+		method string
+		header map[string]string
+	}{
+		{"GET", map[string]string{"If-Modified-Since": "Tue, 01 Jun 2021 11:59:59 GMT"}},
+		{"GET", map[string]string{"If-Modified-Since": "yesterday"}},
+		{"POST", map[string]string{"If-Modified-Since": "Tue, 01 Jun 2021 12:00:00 GMT"}},
+		{"GET", map[string]string{
+			"If-Modified-Since": "Tue, 01 Jun 2021 12:00:00 GMT",
+			"If-None-Match":     `"v1"`}}} {
+		rsp = send(c.method, c.header)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode, "%+v", c)
+	}
+	assert.Equal(t, 5, calls)
+}
@@ -0,0 +1,200 @@
+package typhon
+
+import (
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+const (
+	defaultConsistentHashVirtualNodes        = 100
+	defaultConsistentHashHealthCheckInterval = 10 * time.Second
+)
+
+// ConsistentHashConfig configures a ConsistentHashTransport.
+type ConsistentHashConfig struct {
+	// Key returns the key which determines the backend a request is sent to; requests with the same key go to the same
+	// backend. If nil, the request's path is used.
+	Key func(req *http.Request) string
+	// Backends are the addresses (host:port) of the backends, which replace the host of each request's URL.
+	Backends []string
+	// VirtualNodes is the number of points each backend has on the hash ring. More points spread keys more evenly
+	// between backends, at the cost of memory. If zero, 100 is used.
+	VirtualNodes int
+	// Transport sends the requests once their backend has been chosen. If nil, the default RoundTripper is used.
+	Transport http.RoundTripper
+	// HealthCheck, if non-nil, is called for each backend every HealthCheckInterval (10 seconds if zero), and should
+	// return whether the backend is healthy. Requests are routed around unhealthy backends.
+	HealthCheck         func(backend string) bool
+	HealthCheckInterval time.Duration
+}
+
+// A ConsistentHashTransport is a RoundTripper which sends each request to one of a set of backends chosen by consistent
+// hashing of a key from the request, so that requests with the same key reach the same backend: eg. to get cache
+// affinity with a sharded cache, or to reach the owner of some state. Each backend has many virtual nodes on a hash
+// ring, and a key belongs to the first backend clockwise of its hash. Adding or removing a backend therefore only moves
+// the keys it gains or loses, and keys of a backend which is down move to the next healthy one until it recovers.
+//
+// Create one with NewConsistentHashTransport, and use it with HttpService (or WithRoundTripper). Call Close to stop
+// its health checks once it is no longer needed.
+type ConsistentHashTransport struct {
+	config    ConsistentHashConfig
+	transport http.RoundTripper
+	mtx       sync.RWMutex
+	ring      []consistentHashNode // sorted by hash
+	down      map[string]bool
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type consistentHashNode struct {
+	hash    uint32
+	backend string
+}
+
+// NewConsistentHashTransport returns a ConsistentHashTransport with the passed configuration. If config.HealthCheck
+// is set, health checks start immediately.
+func NewConsistentHashTransport(config ConsistentHashConfig) *ConsistentHashTransport {
+	if config.Key == nil {
+		config.Key = func(req *http.Request) string {
+			return req.URL.Path
+		}
+	}
+	if config.VirtualNodes <= 0 {
+		config.VirtualNodes = defaultConsistentHashVirtualNodes
+	}
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = defaultConsistentHashHealthCheckInterval
+	}
+	t := &ConsistentHashTransport{
+		config:    config,
+		transport: config.Transport,
+		down:      map[string]bool{},
+		stop:      make(chan struct{})}
+	if t.transport == nil {
+		t.transport = RoundTripper
+	}
+	t.SetBackends(config.Backends)
+	if config.HealthCheck != nil {
+		go t.checkHealth()
+	}
+	return t
+}
+
+// SetBackends replaces the set of backends. Keys only move to or from backends which are added or removed. The health
+// of backends which remain is preserved.
+func (t *ConsistentHashTransport) SetBackends(backends []string) {
+	ring := make([]consistentHashNode, 0, len(backends)*t.config.VirtualNodes)
+	present := make(map[string]bool, len(backends))
+	for _, b := range backends {
+		if present[b] {
+			continue
+		}
+		present[b] = true
+		for i := 0; i < t.config.VirtualNodes; i++ {
+			ring = append(ring, consistentHashNode{
+				hash:    crc32.ChecksumIEEE([]byte(b + "#" + strconv.Itoa(i))),
+				backend: b})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash == ring[j].hash {
+			return ring[i].backend < ring[j].backend
+		}
+		return ring[i].hash < ring[j].hash
+	})
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.ring = ring
+	for b := range t.down {
+		if !present[b] {
+			delete(t.down, b)
+		}
+	}
+}
+
+// SetHealthy marks a backend as healthy or not, as health checks do. While a backend is unhealthy, its keys are sent
+// to other backends.
+func (t *ConsistentHashTransport) SetHealthy(backend string, healthy bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if healthy {
+		delete(t.down, backend)
+	} else {
+		t.down[backend] = true
+	}
+}
+
+// Backend returns the backend to which requests with the passed key are currently sent. It returns false if there are
+// no healthy backends.
+func (t *ConsistentHashTransport) Backend(key string) (string, bool) {
+	h := crc32.ChecksumIEEE([]byte(key))
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	i := sort.Search(len(t.ring), func(i int) bool {
+		return t.ring[i].hash >= h
+	})
+	for n := 0; n < len(t.ring); n++ {
+		node := t.ring[(i+n)%len(t.ring)]
+		if !t.down[node.backend] {
+			return node.backend, true
+		}
+	}
+	return "", false
+}
+
+// RoundTrip sends the request to the backend for its key. If there are no healthy backends, it fails with a 503
+// (Service Unavailable) error.
+func (t *ConsistentHashTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.config.Key(req)
+	backend, ok := t.Backend(key)
+	if !ok {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, terrors.New(ErrServiceUnavailable, "No healthy backends", map[string]string{
+			"key": key})
+	}
+	out := req.Clone(req.Context())
+	out.URL.Host = backend
+	return t.transport.RoundTrip(out)
+}
+
+// Close stops the transport's health checks.
+func (t *ConsistentHashTransport) Close() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+	})
+}
+
+func (t *ConsistentHashTransport) checkHealth() {
+	ticker := time.NewTicker(t.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+
+		t.mtx.RLock()
+		backends := make([]string, 0, len(t.ring)/t.config.VirtualNodes)
+		seen := map[string]bool{}
+		for _, node := range t.ring {
+			if !seen[node.backend] {
+				seen[node.backend] = true
+				backends = append(backends, node.backend)
+			}
+		}
+		t.mtx.RUnlock()
+		for _, b := range backends {
+			t.SetHealthy(b, t.config.HealthCheck(b))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsistentHashTransport(t *testing.T) {
+	t.Parallel()
+	backends := []string{"a:80", "b:80", "c:80"}
+	rt := NewConsistentHashTransport(ConsistentHashConfig{
+		Backends: backends,
+		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			rsp := newHTTPResponse(Request{}, http.StatusOK)
+			rsp.Header.Set("X-Backend", req.URL.Host)
+			return rsp, nil
+		})})
+	defer rt.Close()
+
+	assignments := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("/key/%d", i)
+		b, ok := rt.Backend(key)
+		require.True(t, ok)
+		assignments[key] = b
+		counts[b]++
+	}
+	for _, b := range backends {
+		assert.True(t, counts[b] > 150, b)
+	}
+
+	// Requests go to the backend for their key
+	rsp := HttpService(rt)(NewRequest(context.Background(), "GET", "http://cache/key/7", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, assignments["/key/7"], rsp.Header.Get("X-Backend"))
+
+	// An unhealthy backend's keys move elsewhere, and only its keys
+	rt.SetHealthy("b:80", false)
+	for key, b := range assignments {
+		now, ok := rt.Backend(key)
+		require.True(t, ok)
+		if b == "b:80" {
+			assert.NotEqual(t, "b:80", now)
+		} else {
+			assert.Equal(t, b, now)
+		}
+	}
+	rt.SetHealthy("b:80", true)
+
+	// Adding a backend only moves keys to it
+	rt.SetBackends(append(backends, "d:80"))
+	moved := 0
+	for key, b := range assignments {
+		now, _ := rt.Backend(key)
+		if now != b {
+			assert.Equal(t, "d:80", now)
+			moved++
+		}
+	}
+	assert.True(t, moved > 0)
+	assert.True(t, moved < 500)
+
+	// With no healthy backends, requests fail
+	for _, b := range append(backends, "d:80") {
+		rt.SetHealthy(b, false)
+	}
+	rsp = HttpService(rt)(NewRequest(context.Background(), "GET", "http://cache/key/7", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, ErrServiceUnavailable))
+}
+
+func TestConsistentHashTransportHealthCheck(t *testing.T) {
+	t.Parallel()
+	var bHealthy int32
+	rt := NewConsistentHashTransport(ConsistentHashConfig{
+		Backends: []string{"a:80", "b:80"},
+		HealthCheck: func(backend string) bool {
+			return backend != "b:80" || atomic.LoadInt32(&bHealthy) == 1
+		},
+		HealthCheckInterval: time.Millisecond})
+	defer rt.Close()
+
+	keyOn := func(backend string) string {
+		for i := 0; ; i++ {
+			key := fmt.Sprintf("%d", i)
+			if b, _ := rt.Backend(key); b == backend {
+				return key
+			}
+		}
+	}
+	key := keyOn("a:80")
+	rt.SetHealthy("a:80", false)
+	b, _ := rt.Backend(key)
+	assert.Equal(t, "b:80", b)
+
+	// Health checks restore a and take b down
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if b, _ := rt.Backend(key); b == "a:80" {
+			break
+		}
+	}
+	b, _ = rt.Backend(key)
+	assert.Equal(t, "a:80", b)
+	_, ok := rt.Backend(keyOn("a:80"))
+	assert.True(t, ok)
+}
@@ -0,0 +1,59 @@
+package typhon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SetAttachment sets the response's Content-Disposition header to ask the client to save the body as a file (a
+// download) with the passed name, rather than displaying it. Names which aren't plain ASCII are encoded as RFC 5987
+// requires, with an ASCII approximation for older clients; see SetInline.
+func (r *Response) SetAttachment(filename string) {
+	r.setContentDisposition("attachment", filename)
+}
+
+// SetInline sets the response's Content-Disposition header to ask the client to display the body, and to use the
+// passed name if the user saves it. The name is sent in both the legacy filename parameter (with any non-ASCII
+// characters replaced, for older clients) and, if it isn't plain ASCII, the RFC 5987 filename* parameter, which
+// modern browsers prefer. If filename is empty, no name is sent.
+func (r *Response) SetInline(filename string) {
+	r.setContentDisposition("inline", filename)
+}
+
+func (r *Response) setContentDisposition(disposition, filename string) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	if filename == "" {
+		r.Header.Set("Content-Disposition", disposition)
+		return
+	}
+
+	fallback := strings.Map(func(c rune) rune {
+		if c < 0x20 || c >= 0x7f {
+			return '_'
+		}
+		return c
+	}, filename)
+	v := disposition + `; filename="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(fallback) + `"`
+	if fallback != filename {
+		v += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	r.Header.Set("Content-Disposition", v)
+}
+
+// encodeRFC5987 percent-encodes the UTF-8 bytes of s which aren't attr-chars, for an RFC 5987 ext-value.
+func encodeRFC5987(s string) string {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', strings.IndexByte("!#$&+-.^_`|~", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
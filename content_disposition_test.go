@@ -0,0 +1,40 @@
+package typhon
+
+import (
+	"context"
+	"mime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseSetAttachment(t *testing.T) {
+	t.Parallel()
+	rsp := NewResponse(NewRequest(context.Background(), "GET", "/", nil))
+
+	cases := []struct {
+		filename, header string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"`},
+		{`say "hi".txt`, `attachment; filename="say \"hi\".txt"`},
+		{"résumé 2021.pdf", `attachment; filename="r_sum_ 2021.pdf"; filename*=UTF-8''r%C3%A9sum%C3%A9%202021.pdf`},
+		{"日本.txt", `attachment; filename="__.txt"; filename*=UTF-8''%E6%97%A5%E6%9C%AC.txt`},
+		{"", "attachment"}}
+	for _, c := range cases {
+		rsp.SetAttachment(c.filename)
+		h := rsp.Header.Get("Content-Disposition")
+		assert.Equal(t, c.header, h, c.filename)
+
+		// Go's own parser prefers filename*, as browsers do
+		disposition, params, err := mime.ParseMediaType(h)
+		require.NoError(t, err, c.filename)
+		assert.Equal(t, "attachment", disposition)
+		if c.filename != "" {
+			assert.Equal(t, c.filename, params["filename"])
+		}
+	}
+
+	rsp.SetInline("photo.jpg")
+	assert.Equal(t, `inline; filename="photo.jpg"`, rsp.Header.Get("Content-Disposition"))
+}
@@ -0,0 +1,63 @@
+package typhon
+
+import (
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// ContentTypeOptions configures a ContentTypeFilter.
+type ContentTypeOptions struct {
+	// Allowed is an allowlist of media types which request bodies may have. Parameters (such as charset) are ignored
+	// when matching. If empty, only application/json is allowed.
+	Allowed []string
+	// AllowMissing permits requests which have a body but no Content-Type; they are passed to the Service, where Decode
+	// will treat them as JSON. By default they are rejected.
+	AllowMissing bool
+}
+
+// ContentTypeFilter returns a Filter which rejects requests whose body has a Content-Type not in the allowlist with a
+// 415 (Unsupported Media Type) error, before the Service attempts to decode it. The error's "supported" param lists
+// the allowed types. Requests with a charset other than UTF-8 are also rejected, as Decode can't handle them.
+//
+// Requests without a body are always passed through.
+func ContentTypeFilter(opts ContentTypeOptions) Filter {
+	allowed := opts.Allowed
+	if len(allowed) == 0 {
+		allowed = []string{"application/json"}
+	}
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, t := range allowed {
+		allowedSet[strings.ToLower(t)] = true
+	}
+	supported := strings.Join(allowed, ", ")
+
+	return func(req Request, svc Service) Response {
+		if req.ContentLength == 0 {
+			return svc(req)
+		}
+		v := req.Header.Get("Content-Type")
+		if v == "" {
+			if opts.AllowMissing {
+				return svc(req)
+			}
+			return Response{
+				Error: terrors.New(ErrUnsupportedMediaType, "Request body has no Content-Type", map[string]string{
+					"supported": supported})}
+		}
+		mediaType, charset := parseContentType(v)
+		switch {
+		case !allowedSet[mediaType]:
+			return Response{
+				Error: terrors.New(ErrUnsupportedMediaType, "Unsupported Content-Type", map[string]string{
+					"content_type": v,
+					"supported":    supported})}
+		case charset != "":
+			return Response{
+				Error: terrors.New(ErrUnsupportedMediaType, "Unsupported charset", map[string]string{
+					"charset":   charset,
+					"supported": supported})}
+		}
+		return svc(req)
+	}
+}
@@ -0,0 +1,61 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentTypeFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	})
+	strict := svc.Filter(ContentTypeFilter(ContentTypeOptions{})).Filter(ErrorFilter)
+	lenient := svc.Filter(ContentTypeFilter(ContentTypeOptions{
+		Allowed:      []string{"application/json", "application/x-protobuf"},
+		AllowMissing: true})).Filter(ErrorFilter)
+
+	newReq := func(contentType string) Request {
+		req := NewRequest(context.Background(), "POST", "/", nil)
+		req.Write([]byte(`{}`))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		return req
+	}
+
+	rsp := strict(newReq("application/json; charset=utf-8"))
+	require.NoError(t, rsp.Error)
+
+	rsp = strict(newReq("text/plain"))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, ErrUnsupportedMediaType))
+	assert.Equal(t, "application/json", rsp.Error.(*terrors.Error).Params["supported"])
+
+	rsp = strict(newReq("application/json; charset=latin1"))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rsp.StatusCode)
+
+	rsp = strict(newReq(""))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rsp.StatusCode)
+
+	// Requests without a body aren't checked
+	rsp = strict(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+
+	rsp = lenient(newReq(""))
+	require.NoError(t, rsp.Error)
+	rsp = lenient(newReq("application/x-protobuf"))
+	require.NoError(t, rsp.Error)
+	rsp = lenient(newReq("application/xml"))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, "application/json, application/x-protobuf", rsp.Error.(*terrors.Error).Params["supported"])
+}
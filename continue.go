@@ -0,0 +1,46 @@
+package typhon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// ExpectContinueFilter returns a Filter which lets a server vet requests carrying "Expect: 100-continue" before the
+// client transmits their body. The passed function is called with such requests before the Service; if it returns an
+// error, the request is rejected with that error and the body is never requested from the client. Otherwise, the
+// interim 100 (Continue) response is sent as soon as the Service starts to read the body.
+//
+// Requests without the expectation are passed straight through. Note that the HTTP/2 server consumes the Expect
+// header itself, so this only has an effect for HTTP/1.1 connections. Expectations other than 100-continue are
+// rejected with 417 (Expectation Failed) by net/http before they reach Typhon.
+func ExpectContinueFilter(check func(req Request) error) Filter {
+	return func(req Request, svc Service) Response {
+		if strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+			if err := check(req); err != nil {
+				rsp := NewResponse(req)
+				rsp.Error = err
+				return rsp
+			}
+		}
+		return svc(req)
+	}
+}
+
+// ContentLengthLimit returns a check for use with ExpectContinueFilter which rejects requests whose declared
+// Content-Length exceeds max bytes with 413 (Request Entity Too Large). It doesn't limit the body which is actually
+// read; use MaxBodySizeFilter for that.
+func ContentLengthLimit(max int64) func(req Request) error {
+	return func(req Request) error {
+		if req.ContentLength > max {
+			return terrors.New(ErrRequestEntityTooLarge,
+				fmt.Sprintf("Request body of %d bytes exceeds the limit of %d bytes", req.ContentLength, max),
+				map[string]string{
+					"content_length": strconv.FormatInt(req.ContentLength, 10),
+					"max_bytes":      strconv.FormatInt(max, 10)})
+		}
+		return nil
+	}
+}
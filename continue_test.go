@@ -0,0 +1,74 @@
+package typhon
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpectContinueFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(len(b))
+	})
+	svc = svc.Filter(ExpectContinueFilter(ContentLengthLimit(10))).Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	send := func(contentLength int) (*bufio.Reader, net.Conn) {
+		conn, err := net.Dial("tcp", s.Listener().Addr().String())
+		require.NoError(t, err)
+		fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n",
+			contentLength)
+		return bufio.NewReader(conn), conn
+	}
+
+	// An acceptable body is requested with 100 Continue
+	r, conn := send(5)
+	defer conn.Close()
+	rsp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusContinue, rsp.StatusCode)
+	conn.Write([]byte("hello"))
+	rsp, err = http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	// An oversized body is rejected before it is sent
+	r, conn = send(11)
+	defer conn.Close()
+	rsp, err = http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rsp.StatusCode)
+}
+
+func TestExpectContinueFilter_NoExpectation(t *testing.T) {
+	t.Parallel()
+
+	called := false
+	svc := Service(func(req Request) Response {
+		called = true
+		return req.Response(nil)
+	})
+	svc = svc.Filter(ExpectContinueFilter(func(Request) error {
+		assert.Fail(t, "check should not be called without an expectation")
+		return nil
+	}))
+
+	req := NewRequest(context.Background(), "POST", "/", "body")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.True(t, called)
+}
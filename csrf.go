@@ -0,0 +1,177 @@
+package typhon
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+const (
+	// CSRFHeader is the request header in which clients may submit their CSRF token, and the response header in which
+	// CSRFFilter exposes it.
+	CSRFHeader = "X-CSRF-Token"
+	// CSRFFormField is the form field in which browsers may submit their CSRF token.
+	CSRFFormField = "csrf_token"
+	// CSRFCookie is the default name of the cookie which holds the CSRF token.
+	CSRFCookie = "csrf_token"
+)
+
+// CSRFConfig configures CSRFFilter.
+type CSRFConfig struct {
+	// Key signs tokens, so that they can't be forged by an attacker able to set cookies (eg. from a sibling
+	// subdomain). It must be kept secret, and shared by all instances of the service.
+	Key []byte
+	// CookieName is the name of the cookie which holds the token. If empty, CSRFCookie is used.
+	CookieName string
+	// Secure marks the cookie as only to be sent over HTTPS.
+	Secure bool
+	// ExemptPaths are path prefixes which aren't protected, such as API endpoints authenticated with bearer tokens
+	// (which browsers don't send automatically, so aren't vulnerable to CSRF). They match whole path segments, so
+	// "/api" exempts "/api" and "/api/things", but not "/apix".
+	ExemptPaths []string
+}
+
+type csrfContextKeyType struct{}
+
+var csrfContextKey = csrfContextKeyType{}
+
+// CSRFToken returns the CSRF token which CSRFFilter has associated with the request whose context is passed, for
+// embedding in a form (in a CSRFFormField field) or passing to scripts. It returns "" if there isn't one.
+func CSRFToken(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	token, _ := ctx.Value(csrfContextKey).(string)
+	return token
+}
+
+// CSRFFilter returns a server Filter which protects browser-facing endpoints against cross-site request forgery, using
+// signed double-submit cookies.
+//
+// Requests with safe methods (GET, HEAD, OPTIONS and TRACE) are issued a token, in a cookie, unless they already have
+// a valid one. The token is also made available to the Service through CSRFToken and exposed in the CSRFHeader of the
+// response. Requests with other methods must submit the token from their cookie in the CSRFHeader or, for
+// URL-encoded forms, the CSRFFormField; those which don't are rejected with a 403 (Forbidden) error.
+//
+// It panics if config has no Key, as tokens would then be trivially forgeable.
+func CSRFFilter(config CSRFConfig) Filter {
+	if len(config.Key) == 0 {
+		panic("typhon: CSRFFilter requires a Key")
+	}
+	if config.CookieName == "" {
+		config.CookieName = CSRFCookie
+	}
+	return func(req Request, svc Service) Response {
+		for _, p := range config.ExemptPaths {
+			if csrfExemptPath(req.URL.Path, p) {
+				return svc(req)
+			}
+		}
+
+		token := ""
+		if c, err := req.Cookie(config.CookieName); err == nil && validCSRFToken(config.Key, c.Value) {
+			token = c.Value
+		}
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			issued := false
+			if token == "" {
+				var err error
+				if token, err = newCSRFToken(config.Key); err != nil {
+					return Response{
+						Error: terrors.Wrap(err, nil)}
+				}
+				issued = true
+			}
+			req.Context = context.WithValue(req.Context, csrfContextKey, token)
+			rsp := svc(req)
+			if issued {
+				rsp.AddHeader("Set-Cookie", (&http.Cookie{
+					Name:     config.CookieName,
+					Value:    token,
+					Path:     "/",
+					Secure:   config.Secure,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode}).String())
+			}
+			rsp.SetHeader(CSRFHeader, token)
+			return rsp
+		}
+
+		if token == "" {
+			return Response{
+				Error: terrors.Forbidden("csrf", "Missing or invalid CSRF cookie", nil)}
+		}
+		submitted, err := submittedCSRFToken(req)
+		if err != nil {
+			return Response{
+				Error: terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)}
+		}
+		if !hmac.Equal([]byte(submitted), []byte(token)) {
+			return Response{
+				Error: terrors.Forbidden("csrf", "Missing or incorrect CSRF token", nil)}
+		}
+		req.Context = context.WithValue(req.Context, csrfContextKey, token)
+		return svc(req)
+	}
+}
+
+// csrfExemptPath returns whether path is prefix, or falls beneath it.
+func csrfExemptPath(path, prefix string) bool {
+	if path == prefix {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+// submittedCSRFToken returns the token submitted with a request, in its header or URL-encoded form body. The body
+// remains readable.
+func submittedCSRFToken(req Request) (string, error) {
+	if token := req.Header.Get(CSRFHeader); token != "" {
+		return token, nil
+	}
+	mediaType, _ := parseContentType(req.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" || req.Body == nil {
+		return "", nil
+	}
+	b, err := req.BodyBytes(false)
+	if err != nil {
+		return "", err
+	}
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return "", err
+	}
+	return form.Get(CSRFFormField), nil
+}
+
+// newCSRFToken generates a random token, signed with key.
+func newCSRFToken(key []byte) (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+	return encoded + "." + csrfSignature(key, encoded), nil
+}
+
+func validCSRFToken(key []byte, token string) bool {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 {
+		return false
+	}
+	return hmac.Equal([]byte(token[i+1:]), []byte(csrfSignature(key, token[:i])))
+}
+
+func csrfSignature(key []byte, nonce string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,91 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRFFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		return req.Response(CSRFToken(req))
+	}).Filter(CSRFFilter(CSRFConfig{
+		Key:         []byte("secret"),
+		ExemptPaths: []string{"/api"}})).Filter(ErrorFilter)
+	ctx := context.Background()
+
+	// A safe request is issued a token
+	rsp := svc(NewRequest(ctx, "GET", "/form", nil))
+	require.NoError(t, rsp.Error)
+	cookies := rsp.Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, CSRFCookie, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+	token := ""
+	require.NoError(t, rsp.Decode(&token))
+	assert.Equal(t, cookie.Value, token)
+	assert.Equal(t, token, rsp.Header.Get(CSRFHeader))
+
+	// A request which already has a valid token keeps it
+	req := NewRequest(ctx, "GET", "/form", nil)
+	req.AddCookie(cookie)
+	rsp = svc(req)
+	assert.Empty(t, rsp.Cookies())
+	assert.Equal(t, token, rsp.Header.Get(CSRFHeader))
+
+	post := func(cookie *http.Cookie, header, form string) Response {
+		req := NewRequest(ctx, "POST", "/form", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if header != "" {
+			req.Header.Set(CSRFHeader, header)
+		}
+		if form != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Body = ioutil.NopCloser(strings.NewReader(url.Values{
+				CSRFFormField: {form},
+				"name":        {"value"}}.Encode()))
+		}
+		return svc(req)
+	}
+	assert.NoError(t, post(cookie, token, "").Error)
+	assert.NoError(t, post(cookie, "", token).Error)
+	assert.Equal(t, http.StatusForbidden, post(cookie, "", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, post(cookie, "wrong", "").StatusCode)
+	assert.Equal(t, http.StatusForbidden, post(nil, token, "").StatusCode)
+
+	// A token which wasn't signed with the key is rejected, even if it is submitted consistently
+	forged := &http.Cookie{
+		Name:  CSRFCookie,
+		Value: "abc.def"}
+	assert.Equal(t, http.StatusForbidden, post(forged, "abc.def", "").StatusCode)
+
+	// Exempt paths aren't checked
+	rsp = svc(NewRequest(ctx, "POST", "/api/things", nil))
+	assert.NoError(t, rsp.Error)
+	rsp = svc(NewRequest(ctx, "POST", "/api", nil))
+	assert.NoError(t, rsp.Error)
+	// Exempt paths only match whole path segments
+	rsp = svc(NewRequest(ctx, "POST", "/apix", nil))
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+}
+
+func TestCSRFFilterRequiresKey(t *testing.T) {
+	t.Parallel()
+	assert.Panics(t, func() {
+		CSRFFilter(CSRFConfig{})
+	})
+	assert.Panics(t, func() {
+		CSRFFilter(CSRFConfig{
+			Key: []byte{}})
+	})
+}
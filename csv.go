@@ -0,0 +1,85 @@
+package typhon
+
+import (
+	"encoding/csv"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// csvFlushInterval is the longest that rows written by EncodeCSV are buffered before being sent to the client.
+const csvFlushInterval = 100 * time.Millisecond
+
+// EncodeCSV makes the response's body a CSV document which is streamed to the client as it is produced, so large
+// exports needn't be held in memory. The Content-Type is set to text/csv and, if filename is non-empty, a
+// Content-Disposition header asks the client to save the body as an attachment with that name (see SetAttachment).
+//
+// header, if non-nil, is written as the first row. rows is then called (in a separate goroutine) with a function
+// which writes one row; it should return once all rows have been written, or with the first error from write (which
+// occurs if the client goes away). Rows are buffered briefly and sent in batches, at least every csvFlushInterval. If
+// rows returns an error or panics, the body is terminated abruptly so the client can tell the export is incomplete.
+func (r *Response) EncodeCSV(filename string, header []string, rows func(write func(row []string) error) error) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	r.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	if filename != "" {
+		r.SetAttachment(filename)
+	}
+
+	// Closing the stream (as the server does once it has finished with the body) makes writes fail, so the producer
+	// stops if the client disconnects
+	s := Streamer().(*streamer)
+	r.Body = s
+	r.ContentLength = -1
+	go func() {
+		var err error
+		defer func() {
+			if v := recover(); v != nil {
+				err = PanicError(v)
+			}
+			s.CloseWithError(err)
+		}()
+
+		// Rows are flushed periodically, so that slowly-produced rows aren't held back until more arrive
+		var mtx sync.Mutex
+		w := csv.NewWriter(s)
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			ticker := time.NewTicker(csvFlushInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+					mtx.Lock()
+					w.Flush()
+					mtx.Unlock()
+				}
+			}
+		}()
+		write := func(row []string) error {
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err := w.Write(row); err != nil {
+				return err
+			}
+			return w.Error()
+		}
+
+		if header != nil {
+			err = write(header)
+		}
+		if err == nil {
+			err = rows(write)
+		}
+		if err == nil {
+			mtx.Lock()
+			w.Flush()
+			err = w.Error()
+			mtx.Unlock()
+		}
+	}()
+}
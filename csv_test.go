@@ -0,0 +1,101 @@
+package typhon
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseEncodeCSV(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.EncodeCSV("export ü.csv", []string{"id", "name"}, func(write func(row []string) error) error {
+			for i := 1; i <= 3; i++ {
+				if err := write([]string{strconv.Itoa(i), "name, " + strconv.Itoa(i)}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	rsp := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+"/", nil).
+		SendVia(Service(BareClient).Filter(ErrorFilter)).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "text/csv; charset=utf-8", rsp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="export _.csv"; filename*=UTF-8''export%20%C3%BC.csv`,
+		rsp.Header.Get("Content-Disposition"))
+	assert.Equal(t, []string{"chunked"}, rsp.TransferEncoding)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "id,name\n1,\"name, 1\"\n2,\"name, 2\"\n3,\"name, 3\"\n", string(b))
+}
+
+func TestResponseEncodeCSVErrors(t *testing.T) {
+	t.Parallel()
+
+	// Errors from rows terminate the body
+	rsp := NewResponse(Request{})
+	rsp.EncodeCSV("", nil, func(write func(row []string) error) error {
+		write([]string{"a"})
+		return errors.New("database went away")
+	})
+	assert.Empty(t, rsp.Header.Get("Content-Disposition"))
+	_, err := ioutil.ReadAll(rsp.Body)
+	assert.EqualError(t, err, "database went away")
+
+	// Closing the body stops the producer
+	stopped := make(chan error)
+	rsp = NewResponse(Request{})
+	rsp.EncodeCSV("", nil, func(write func(row []string) error) error {
+		for {
+			if err := write([]string{"row"}); err != nil {
+				stopped <- err
+				return err
+			}
+		}
+	})
+	rsp.Body.Close()
+	assert.Equal(t, io.ErrClosedPipe, <-stopped)
+
+	// Panics terminate the body too
+	rsp = NewResponse(Request{})
+	rsp.EncodeCSV("", nil, func(write func(row []string) error) error {
+		write([]string{"a"})
+		panic("boom")
+	})
+	_, err = ioutil.ReadAll(rsp.Body)
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, "internal_service.panic"))
+}
+
+func TestResponseEncodeCSVFlushesPeriodically(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	defer close(done)
+	rsp := NewResponse(Request{})
+	rsp.EncodeCSV("", nil, func(write func(row []string) error) error {
+		write([]string{"first"})
+		<-done
+		return nil
+	})
+	// The row is sent even though no more follow it
+	b := make([]byte, 6)
+	_, err := io.ReadFull(rsp.Body, b)
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", string(b))
+}
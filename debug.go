@@ -0,0 +1,118 @@
+package typhon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/monzo/slog"
+)
+
+// DebugLogConfig configures a DebugLogFilter.
+type DebugLogConfig struct {
+	// Enabled is consulted for every request, so verbose logging can be switched on and off at runtime (eg. from a
+	// flag or an atomic). When it returns false the request is passed straight through with no overhead beyond the
+	// call. If nil, logging is always enabled.
+	Enabled func() bool
+	// MaxBodyBytes is the maximum number of bytes of each request and response body to include in the log. Bodies are
+	// not logged if it is zero.
+	MaxBodyBytes int
+	// RedactHeaders lists headers whose values are replaced in the log. If nil, RedactedHeaders are redacted; pass an
+	// empty (non-nil) slice to log every header verbatim.
+	RedactHeaders []string
+	// Logger receives the log events. If nil, the default slog logger is used.
+	Logger slog.Logger
+}
+
+// DebugLogFilter returns a Filter which logs each request and its response at debug severity: the method, URL,
+// headers and (up to config.MaxBodyBytes of) the body. It is intended to be applied to a client, for debugging
+// interactions with downstream services.
+//
+// Only the logged prefix of each body is read in advance; the remainder is left to stream, so the bodies are still
+// readable in full by the Service and the caller.
+func DebugLogFilter(config DebugLogConfig) Filter {
+	redact := config.RedactHeaders
+	if redact == nil {
+		redact = RedactedHeaders
+	}
+	redactSet := headerSet(redact)
+	log := func(ev slog.Event) {
+		if config.Logger != nil {
+			config.Logger.Log(ev)
+		} else {
+			slog.Log(ev)
+		}
+	}
+
+	return func(req Request, svc Service) Response {
+		if config.Enabled != nil && !config.Enabled() {
+			return svc(req)
+		}
+
+		var reqBody []byte
+		if config.MaxBodyBytes > 0 && req.Body != nil {
+			reqBody, req.Body = peekBody(req.Body, config.MaxBodyBytes)
+		}
+		log(slog.Eventf(slog.DebugSeverity, req, "Sending %s %s", req.Method, req.URL, map[string]string{
+			"headers": formatHeaders(req.Header, redactSet),
+			"body":    string(reqBody)}))
+
+		rsp := svc(req)
+		if rsp.Response == nil {
+			log(slog.Eventf(slog.DebugSeverity, req, "%s %s failed: %v", req.Method, req.URL, rsp.Error))
+			return rsp
+		}
+		var rspBody []byte
+		if config.MaxBodyBytes > 0 && rsp.Body != nil {
+			rspBody, rsp.Body = peekBody(rsp.Body, config.MaxBodyBytes)
+		}
+		log(slog.Eventf(slog.DebugSeverity, req, "%s %s responded %d", req.Method, req.URL, rsp.StatusCode,
+			map[string]string{
+				"headers": formatHeaders(rsp.Header, redactSet),
+				"body":    string(rspBody),
+				"error":   fmt.Sprint(rsp.Error)}))
+		return rsp
+	}
+}
+
+// peekBody reads up to n bytes from body, returning them along with a ReadCloser which yields the whole body (the
+// peeked bytes followed by the unread remainder).
+func peekBody(body io.ReadCloser, n int) ([]byte, io.ReadCloser) {
+	b := make([]byte, n)
+	read, err := io.ReadFull(body, b)
+	b = b[:read]
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// The whole body was read, so it can be buffered outright
+		body.Close()
+		buf := &bufCloser{}
+		buf.Write(b)
+		return b, buf
+	}
+	return b, struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(bytes.NewReader(b), body),
+		Closer: body}
+}
+
+// formatHeaders renders headers as a deterministic string, replacing the values of those in redact.
+func formatHeaders(h http.Header, redact map[string]bool) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := strings.Join(h[k], ", ")
+		if redact[http.CanonicalHeaderKey(k)] {
+			v = redactedHeaderValue
+		}
+		parts = append(parts, k+": "+v)
+	}
+	return strings.Join(parts, "\n")
+}
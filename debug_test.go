@@ -0,0 +1,94 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/monzo/slog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type captureLogger struct {
+	sync.Mutex
+	events []slog.Event
+}
+
+func (l *captureLogger) Log(evs ...slog.Event) {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, evs...)
+}
+
+func (l *captureLogger) Flush() error {
+	return nil
+}
+
+func TestDebugLogFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return Response{Error: err}
+		}
+		rsp := NewResponse(req)
+		rsp.Write([]byte(strings.Repeat("x", 100)))
+		rsp.Write(b)
+		rsp.Header.Set("Set-Cookie", "session=secret")
+		return rsp
+	})
+	logger := &captureLogger{}
+	enabled := true
+	svc = svc.Filter(DebugLogFilter(DebugLogConfig{
+		Enabled:      func() bool { return enabled },
+		MaxBodyBytes: 8,
+		Logger:       logger}))
+
+	req := NewRequest(context.Background(), "POST", "http://example.com/foo", map[string]string{"a": "b"})
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Foo", "bar")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+
+	// Both bodies remain readable in full
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", 100)+`{"a":"b"}`+"\n", string(b))
+
+	require.Len(t, logger.events, 2)
+	assert.Equal(t, "Sending POST http://example.com/foo", logger.events[0].Message)
+	assert.Equal(t, `{"a":"b"`, logger.events[0].Metadata["body"])
+	assert.Contains(t, logger.events[0].Metadata["headers"], "Authorization: [REDACTED]")
+	assert.Contains(t, logger.events[0].Metadata["headers"], "X-Foo: bar")
+	assert.NotContains(t, logger.events[0].Metadata["headers"], "token")
+	assert.Equal(t, "POST http://example.com/foo responded 200", logger.events[1].Message)
+	assert.Equal(t, "xxxxxxxx", logger.events[1].Metadata["body"])
+	assert.Contains(t, logger.events[1].Metadata["headers"], "Set-Cookie: [REDACTED]")
+
+	// Nothing is logged when disabled
+	enabled = false
+	rsp = svc(NewRequest(context.Background(), "GET", "http://example.com/foo", nil))
+	require.NoError(t, rsp.Error)
+	assert.Len(t, logger.events, 2)
+}
+
+func TestPeekBody(t *testing.T) {
+	t.Parallel()
+
+	peeked, body := peekBody(ioutil.NopCloser(strings.NewReader("hello world")), 5)
+	assert.Equal(t, "hello", string(peeked))
+	b, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+
+	peeked, body = peekBody(ioutil.NopCloser(strings.NewReader("hi")), 5)
+	assert.Equal(t, "hi", string(peeked))
+	assert.IsType(t, &bufCloser{}, body)
+	b, err = ioutil.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, "hi", string(b))
+}
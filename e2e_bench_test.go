@@ -42,3 +42,21 @@ func BenchmarkRequestResponse(b *testing.B) {
 		req.SendVia(sockSvc).Response()
 	}
 }
+
+func BenchmarkServiceCall(b *testing.B) {
+	b.ReportAllocs()
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.Header.Set("a", "b")
+		rsp.Header.Set("b", "b")
+		rsp.Header.Set("c", "b")
+		return rsp
+	})
+
+	ctx := context.Background()
+	req := NewRequest(ctx, "GET", "http://localhost/foo", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		svc.Call(req)
+	}
+}
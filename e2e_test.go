@@ -792,3 +792,33 @@ func TestE2EDraining(t *testing.T) {
 		<-serverClosed
 	})
 }
+
+func TestE2ETrailers(t *testing.T) {
+	flavours(t, func(t *testing.T, flav e2eFlavour) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		svc := Service(func(req Request) Response {
+			s := Streamer()
+			rsp := req.Response(s)
+			rsp.DeclareTrailer("X-Checksum")
+			go func() {
+				defer s.Close()
+				s.Write([]byte("body"))
+				rsp.SetTrailer("X-Checksum", "abc123")
+			}()
+			return rsp
+		})
+		svc = svc.Filter(ErrorFilter)
+		s := flav.Serve(svc)
+		defer s.Stop(context.Background())
+
+		req := NewRequest(ctx, "GET", flav.URL(s), nil)
+		rsp := req.Send().Response()
+		require.NoError(t, rsp.Error)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		assert.Equal(t, "body", string(b))
+		assert.Equal(t, "abc123", rsp.Trailer.Get("X-Checksum"))
+	})
+}
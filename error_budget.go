@@ -0,0 +1,210 @@
+package typhon
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+const errorBudgetBuckets = 10
+
+// ErrorBudgetConfig configures an ErrorBudget.
+type ErrorBudgetConfig struct {
+	// SLO is the target success rate, between 0 and 1 (eg. 0.999).
+	SLO float64
+	// Window is the period over which the success rate is measured. If zero, one minute is used.
+	Window time.Duration
+	// Key groups requests into separately-budgeted routes. Requests dispatched by a Router aren't yet routed when a
+	// Filter wrapping the whole Router sees them, so to budget by route, use the Router to find the pattern:
+	//
+	//  Key: func(req typhon.Request) string { return router.Pattern(req) }
+	//
+	// If nil, all requests share a single budget.
+	Key func(req Request) string
+	// IsFailure decides whether a response counts against the budget. If nil, responses with a 5xx status (or an error
+	// which maps to one) are failures.
+	IsFailure func(rsp Response) bool
+	// Shed enables load shedding: once the failure rate in the window exceeds that permitted by the SLO, a fraction
+	// of requests (proportional to the excess) is rejected with a 503 (Service Unavailable) error without being
+	// handled. Otherwise, the budget is only tracked.
+	Shed bool
+	// MinRequests is the number of requests which must have been seen in the window before any are shed, so that a
+	// handful of early failures doesn't trigger shedding.
+	MinRequests int
+}
+
+// An ErrorBudget tracks the rate of failed requests against a service level objective (SLO) over a rolling window,
+// and can shed load when the budget of permitted failures is exhausted: an adaptive form of circuit breaker. Create
+// one with NewErrorBudget.
+type ErrorBudget struct {
+	config ErrorBudgetConfig
+	mtx    sync.Mutex
+	routes map[string]*errorBudgetWindow
+	now    func() time.Time
+	random func() float64
+}
+
+// ErrorBudgetStats is a snapshot of the error budget of a route, suitable for exporting as metrics.
+type ErrorBudgetStats struct {
+	// Requests and Failures are the numbers of requests handled, and of those which failed, in the window.
+	Requests, Failures uint64
+	// Shed is the number of requests rejected by the budget in the window.
+	Shed uint64
+	// SuccessRate is the fraction of requests in the window which succeeded (1 if there have been none).
+	SuccessRate float64
+	// BudgetRemaining is the fraction of the window's permitted failures not yet used. It is negative once the budget
+	// is overspent.
+	BudgetRemaining float64
+	// ShedRate is the fraction of requests currently being rejected.
+	ShedRate float64
+}
+
+type errorBudgetBucket struct {
+	start                    time.Time
+	requests, failures, shed uint64
+}
+
+type errorBudgetWindow struct {
+	buckets [errorBudgetBuckets]errorBudgetBucket
+}
+
+// NewErrorBudget returns an ErrorBudget with the passed configuration.
+func NewErrorBudget(config ErrorBudgetConfig) *ErrorBudget {
+	if config.Window <= 0 {
+		config.Window = time.Minute
+	}
+	return &ErrorBudget{
+		config: config,
+		routes: map[string]*errorBudgetWindow{},
+		now:    time.Now,
+		random: rand.Float64}
+}
+
+// ErrorBudgetFilter returns a Filter which tracks (and, if configured, enforces) an error budget, as described by
+// ErrorBudget. Use an ErrorBudget directly to inspect the budgets.
+func ErrorBudgetFilter(config ErrorBudgetConfig) Filter {
+	return NewErrorBudget(config).Filter
+}
+
+// Filter is a Filter which records the outcome of each request against its route's budget, and sheds requests if
+// the budget is exhausted and shedding is enabled.
+func (b *ErrorBudget) Filter(req Request, svc Service) Response {
+	key := ""
+	if b.config.Key != nil {
+		key = b.config.Key(req)
+	}
+
+	b.mtx.Lock()
+	w := b.window(key)
+	bucket := w.current(b.now(), b.config.Window)
+	if b.config.Shed {
+		if rate := b.shedRate(w.totals(b.now(), b.config.Window)); rate > 0 && b.random() < rate {
+			bucket.shed++
+			b.mtx.Unlock()
+			return Response{
+				Error: terrors.New(ErrServiceUnavailable, "Error budget exhausted", map[string]string{
+					"route": key,
+					"slo":   strconv.FormatFloat(b.config.SLO, 'f', -1, 64)})}
+		}
+	}
+	b.mtx.Unlock()
+
+	rsp := svc(req)
+	failed := b.isFailure(rsp)
+	b.mtx.Lock()
+	bucket = w.current(b.now(), b.config.Window)
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+	b.mtx.Unlock()
+	return rsp
+}
+
+// Stats returns a snapshot of the budget of each route which has been seen.
+func (b *ErrorBudget) Stats() map[string]ErrorBudgetStats {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	stats := make(map[string]ErrorBudgetStats, len(b.routes))
+	for key, w := range b.routes {
+		t := w.totals(b.now(), b.config.Window)
+		s := ErrorBudgetStats{
+			Requests:        t.requests,
+			Failures:        t.failures,
+			Shed:            t.shed,
+			SuccessRate:     1,
+			BudgetRemaining: 1,
+			ShedRate:        b.shedRate(t)}
+		if t.requests > 0 {
+			s.SuccessRate = 1 - float64(t.failures)/float64(t.requests)
+			if allowed := (1 - b.config.SLO) * float64(t.requests); allowed > 0 {
+				s.BudgetRemaining = 1 - float64(t.failures)/allowed
+			} else if t.failures > 0 {
+				s.BudgetRemaining = -1
+			}
+		}
+		stats[key] = s
+	}
+	return stats
+}
+
+func (b *ErrorBudget) window(key string) *errorBudgetWindow {
+	w, ok := b.routes[key]
+	if !ok {
+		w = &errorBudgetWindow{}
+		b.routes[key] = w
+	}
+	return w
+}
+
+// shedRate returns the fraction of requests to shed, given the totals for the window: the amount by which the failure
+// rate exceeds that permitted by the SLO, scaled so that a route which always fails sheds everything.
+func (b *ErrorBudget) shedRate(t errorBudgetBucket) float64 {
+	if !b.config.Shed || t.requests == 0 || t.requests < uint64(b.config.MinRequests) {
+		return 0
+	}
+	allowed := 1 - b.config.SLO
+	excess := float64(t.failures)/float64(t.requests) - allowed
+	if excess <= 0 || allowed >= 1 {
+		return 0
+	}
+	return excess / (1 - allowed)
+}
+
+func (b *ErrorBudget) isFailure(rsp Response) bool {
+	if b.config.IsFailure != nil {
+		return b.config.IsFailure(rsp)
+	}
+	if rsp.Error != nil {
+		return ErrorStatusCode(rsp.Error) >= 500
+	}
+	return rsp.Response != nil && rsp.StatusCode >= 500
+}
+
+// current returns the bucket for the present moment, resetting it if it has expired.
+func (w *errorBudgetWindow) current(now time.Time, window time.Duration) *errorBudgetBucket {
+	width := window / errorBudgetBuckets
+	start := now.Truncate(width)
+	bucket := &w.buckets[(start.UnixNano()/int64(width))%errorBudgetBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = errorBudgetBucket{
+			start: start}
+	}
+	return bucket
+}
+
+// totals sums the buckets which fall within the window.
+func (w *errorBudgetWindow) totals(now time.Time, window time.Duration) errorBudgetBucket {
+	t := errorBudgetBucket{}
+	for _, bucket := range w.buckets {
+		if now.Sub(bucket.start) < window {
+			t.requests += bucket.requests
+			t.failures += bucket.failures
+			t.shed += bucket.shed
+		}
+	}
+	return t
+}
@@ -0,0 +1,79 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorBudget(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	fail := false
+	b := NewErrorBudget(ErrorBudgetConfig{
+		SLO:         0.9,
+		Window:      10 * time.Second,
+		Key:         func(req Request) string { return req.URL.Path },
+		Shed:        true,
+		MinRequests: 10})
+	b.now = func() time.Time { return now }
+	random := 1.0 // never shed
+	b.random = func() float64 { return random }
+	svc := Service(func(req Request) Response {
+		if fail {
+			return Response{Error: terrors.InternalService("", "boom", nil)}
+		}
+		if req.URL.Path == "/missing" {
+			return Response{Error: terrors.NotFound("", "missing", nil)}
+		}
+		return req.Response(nil)
+	}).Filter(b.Filter).Filter(ErrorFilter)
+	send := func(path string) Response {
+		return svc(NewRequest(context.Background(), "GET", path, nil))
+	}
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, send("/ok").Error)
+		// 4xx errors don't count against the budget
+		require.Error(t, send("/missing").Error)
+	}
+	stats := b.Stats()
+	assert.Equal(t, ErrorBudgetStats{Requests: 10, SuccessRate: 1, BudgetRemaining: 1}, stats["/ok"])
+	assert.Equal(t, uint64(0), stats["/missing"].Failures)
+
+	// Failures within the budget don't cause shedding
+	fail = true
+	require.True(t, terrors.Is(send("/ok").Error, terrors.ErrInternalService))
+	stats = b.Stats()
+	assert.Equal(t, uint64(1), stats["/ok"].Failures)
+	assert.InDelta(t, 1-1/1.1, stats["/ok"].BudgetRemaining, 0.0001)
+	assert.Zero(t, stats["/ok"].ShedRate)
+
+	// Once the budget is exhausted, a fraction of requests are shed
+	for i := 0; i < 10; i++ {
+		send("/ok")
+	}
+	stats = b.Stats()
+	assert.True(t, stats["/ok"].BudgetRemaining < 0)
+	assert.InDelta(t, (11.0/21-0.1)/0.9, stats["/ok"].ShedRate, 0.0001)
+	random = 0.3
+	rsp := send("/ok")
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, ErrServiceUnavailable))
+	assert.Equal(t, uint64(1), b.Stats()["/ok"].Shed)
+	// Other routes are unaffected
+	require.Error(t, send("/missing").Error)
+	assert.False(t, terrors.Is(send("/missing").Error, ErrServiceUnavailable))
+
+	// Once the window has passed, the budget is restored
+	fail = false
+	now = now.Add(11 * time.Second)
+	require.NoError(t, send("/ok").Error)
+	assert.Equal(t, ErrorBudgetStats{Requests: 1, SuccessRate: 1, BudgetRemaining: 1}, b.Stats()["/ok"])
+}
@@ -13,19 +13,46 @@ import (
 	terrorsproto "github.com/monzo/terrors/proto"
 )
 
+// Error codes for conditions which have a well-defined HTTP status, but no corresponding code in terrors.
+const (
+	ErrRequestHeaderFieldsTooLarge = "request_header_fields_too_large"
+	ErrServiceUnavailable          = "service_unavailable"
+	ErrRequestEntityTooLarge       = "request_entity_too_large"
+	ErrUnsupportedMediaType        = "unsupported_media_type"
+	ErrBadGateway                  = "bad_gateway"
+	ErrRangeNotSatisfiable         = "range_not_satisfiable"
+	ErrLoopDetected                = "loop_detected"
+	// ErrClientClosedRequest indicates that the client went away before the request was handled. It has the
+	// non-standard status 499, as used by nginx.
+	ErrClientClosedRequest = "client_closed_request"
+)
+
 var (
 	mapTerr2Status = map[string]int{
-		terrors.ErrBadRequest:         http.StatusBadRequest,          // 400
-		terrors.ErrBadResponse:        http.StatusNotAcceptable,       // 406
-		terrors.ErrForbidden:          http.StatusForbidden,           // 403
-		terrors.ErrInternalService:    http.StatusInternalServerError, // 500
-		terrors.ErrNotFound:           http.StatusNotFound,            // 404
-		terrors.ErrPreconditionFailed: http.StatusPreconditionFailed,  // 412
-		terrors.ErrTimeout:            http.StatusGatewayTimeout,      // 504
-		terrors.ErrUnauthorized:       http.StatusUnauthorized,        // 401
-		terrors.ErrRateLimited:        http.StatusTooManyRequests,     // 429
+		terrors.ErrBadRequest:          http.StatusBadRequest,                   // 400
+		terrors.ErrBadResponse:         http.StatusNotAcceptable,                // 406
+		terrors.ErrForbidden:           http.StatusForbidden,                    // 403
+		terrors.ErrInternalService:     http.StatusInternalServerError,          // 500
+		terrors.ErrNotFound:            http.StatusNotFound,                     // 404
+		terrors.ErrPreconditionFailed:  http.StatusPreconditionFailed,           // 412
+		terrors.ErrTimeout:             http.StatusGatewayTimeout,               // 504
+		terrors.ErrUnauthorized:        http.StatusUnauthorized,                 // 401
+		terrors.ErrRateLimited:         http.StatusTooManyRequests,              // 429
+		ErrRequestEntityTooLarge:       http.StatusRequestEntityTooLarge,        // 413
+		ErrUnsupportedMediaType:        http.StatusUnsupportedMediaType,         // 415
+		ErrRangeNotSatisfiable:         http.StatusRequestedRangeNotSatisfiable, // 416
+		ErrRequestHeaderFieldsTooLarge: http.StatusRequestHeaderFieldsTooLarge,  // 431
+		ErrClientClosedRequest:         499,                                     // 499 (non-standard)
+		ErrBadGateway:                  http.StatusBadGateway,                   // 502
+		ErrServiceUnavailable:          http.StatusServiceUnavailable,           // 503
+		ErrLoopDetected:                http.StatusLoopDetected,                 // 508
 	}
 	mapStatus2Terr map[int]string
+
+	// IncludeErrorStacks controls whether ErrorFilter includes stack traces when it serialises errors into response
+	// bodies. Stacks are useful in development, but reveal details of a service's internals, so they are excluded by
+	// default. It can be overridden globally but MUST only be done before use takes place; access is not synchronised.
+	IncludeErrorStacks = false
 )
 
 func init() {
@@ -46,6 +73,15 @@ func ErrorStatusCode(err error) int {
 	return http.StatusInternalServerError
 }
 
+// asTerror wraps err as a terror if necessary. A nil error, which callers shouldn't pass, becomes an internal error
+// rather than causing a panic.
+func asTerror(err error) *terrors.Error {
+	if err == nil {
+		return terrors.InternalService("nil_error", "Error is nil", nil)
+	}
+	return terrors.Wrap(err, nil).(*terrors.Error)
+}
+
 // terr2StatusCode converts HTTP status codes to a roughly equivalent terrors' code
 func status2TerrCode(code int) string {
 	if c, ok := mapStatus2Terr[code]; ok {
@@ -91,36 +127,18 @@ func ErrorFilter(req Request, svc Service) Response {
 		// We could also be here if something weird happened e.g. an error was set and a 200 response was returned by the server.
 		if rsp.StatusCode == http.StatusOK {
 			// We got an error, but there is no error in the underlying response; marshal
-			if rsp.Body != nil {
-				rsp.Body.Close()
-			}
-			rsp.Body = &bufCloser{}
-			terr := terrors.Wrap(rsp.Error, nil).(*terrors.Error)
-			rsp.Encode(terrors.Marshal(terr))
-			// We now set the status to the ACTUAL status code based on the Terror.
-			rsp.StatusCode = ErrorStatusCode(terr)
-			rsp.Header.Set("Terror", "1")
+			rsp.EncodeError(rsp.Error)
 		}
 	} else if rsp.StatusCode >= 400 && rsp.StatusCode <= 599 {
 		// There is an error in the underlying response; unmarshal
 		b, _ := rsp.BodyBytes(false)
 		switch rsp.Header.Get("Terror") {
 		case "1":
-			var err error
-			tp := &terrorsproto.Error{}
-
-			switch rsp.Header.Get("Content-Type") {
-			case "application/octet-stream", "application/x-protobuf", "application/protobuf":
-				err = legacyproto.Unmarshal(b, tp)
-			default:
-				err = json.Unmarshal(b, tp)
-			}
-
-			if err != nil {
+			if terr, err := unmarshalTerror(rsp.Header, b); err != nil {
 				slog.Warn(rsp.Request, "Failed to unmarshal terror: %v", err)
 				rsp.Error = errors.New(string(b))
 			} else {
-				rsp.Error = terrors.Unmarshal(tp)
+				rsp.Error = terr
 			}
 		default:
 			rsp.Error = errors.New(string(b))
@@ -139,3 +157,19 @@ func ErrorFilter(req Request, svc Service) Response {
 
 	return rsp
 }
+
+// unmarshalTerror decodes a terror serialised into a response body by ErrorFilter.
+func unmarshalTerror(h http.Header, b []byte) (*terrors.Error, error) {
+	var err error
+	tp := &terrorsproto.Error{}
+	switch mediaType, _ := parseContentType(h.Get("Content-Type")); mediaType {
+	case "application/octet-stream", "application/x-protobuf", "application/protobuf":
+		err = legacyproto.Unmarshal(b, tp)
+	default:
+		err = json.Unmarshal(b, tp)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return terrors.Unmarshal(tp), nil
+}
@@ -0,0 +1,37 @@
+package typhon
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestErrorFilterStacks verifies that stacks are only serialised into error bodies when IncludeErrorStacks is set. It
+// mutates global state so must not run in parallel.
+func TestErrorFilterStacks(t *testing.T) {
+	defer func(v bool) {
+		IncludeErrorStacks = v
+	}(IncludeErrorStacks)
+
+	svc := Service(func(req Request) Response {
+		return Response{
+			Error: terrors.InternalService("boom", "Boom", nil)}
+	})
+	svc = svc.Filter(ErrorFilter)
+
+	for _, include := range []bool{false, true} {
+		IncludeErrorStacks = include
+		rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		body := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(b, &body))
+		assert.Equal(t, "internal_service.boom", body["code"])
+		_, hasStack := body["stack"]
+		assert.Equal(t, include, hasStack)
+	}
+}
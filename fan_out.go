@@ -0,0 +1,95 @@
+package typhon
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// A FanOutResult is the outcome of one of the Services called by FanOut.
+type FanOutResult struct {
+	// Response is the Service's response. If the Service didn't respond in time, it is a timeout error.
+	Response Response
+	// Complete is true if the Service responded before the deadline.
+	Complete bool
+}
+
+// FanOut returns a Service which calls each of svcs concurrently with a copy of the request, waits up to timeout for
+// them to respond, and passes the results (in the same order as svcs) to merge, which assembles the response. Rather
+// than failing entirely when a downstream is slow, an aggregation endpoint can then respond with the results which
+// did arrive in time, and degrade gracefully.
+//
+// Each Service receives its own copy of the request's headers and body, and a context which is cancelled once the
+// deadline passes (or the request's own context is cancelled). Responses which arrive after the deadline are
+// discarded.
+func FanOut(timeout time.Duration, merge func(req Request, results []FanOutResult) Response, svcs ...Service) Service {
+	return func(req Request) Response {
+		var body []byte
+		if req.Body != nil {
+			b, err := req.BodyBytes(true)
+			if err != nil {
+				return Response{
+					Error: terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)}
+			}
+			body = b
+		}
+
+		ctx := req.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		type indexedResponse struct {
+			i   int
+			rsp Response
+		}
+		done := make(chan indexedResponse, len(svcs)) // buffered, so late Services don't block
+		for i, svc := range svcs {
+			subReq := req.WithContext(ctx)
+			subReq.Header = cloneHeader(req.Header)
+			if body != nil {
+				subReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+			go func(i int, svc Service, subReq Request) {
+				done <- indexedResponse{i, svc(subReq)}
+			}(i, svc, subReq)
+		}
+
+		results := make([]FanOutResult, len(svcs))
+		pending := len(svcs)
+	wait:
+		for pending > 0 {
+			select {
+			case r := <-done:
+				results[r.i] = FanOutResult{
+					Response: r.rsp,
+					Complete: true}
+				pending--
+			case <-ctx.Done():
+				break wait
+			}
+		}
+		if pending > 0 {
+			for i := range results {
+				if !results[i].Complete {
+					results[i].Response = Response{
+						Error: terrors.Timeout("fan_out", "Service did not respond before the deadline", nil)}
+				}
+			}
+			go func(n int) {
+				// Close the bodies of late responses, which would otherwise leak
+				for ; n > 0; n-- {
+					if r := <-done; r.rsp.Response != nil && r.rsp.Body != nil {
+						r.rsp.Body.Close()
+					}
+				}
+			}(pending)
+		}
+		return merge(req, results)
+	}
+}
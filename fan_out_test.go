@@ -0,0 +1,68 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanOut(t *testing.T) {
+	t.Parallel()
+
+	echo := func(name string, delay time.Duration) Service {
+		return func(req Request) Response {
+			select {
+			case <-time.After(delay):
+			case <-req.Done():
+				return Response{Error: terrors.Wrap(req.Err(), nil)}
+			}
+			b, _ := req.BodyBytes(true)
+			req.Header.Set("X-Mutated", name) // each Service has its own headers
+			return req.Response(name + ":" + string(b))
+		}
+	}
+	released := make(chan struct{})
+	slow := Service(func(req Request) Response {
+		<-req.Done()
+		close(released)
+		return req.Response("too late")
+	})
+	merge := func(req Request, results []FanOutResult) Response {
+		parts := make([]string, len(results))
+		for i, r := range results {
+			if !r.Complete {
+				assert.True(t, terrors.Is(r.Response.Error, terrors.ErrTimeout))
+				parts[i] = "incomplete"
+				continue
+			}
+			var s string
+			require.NoError(t, r.Response.Decode(&s))
+			parts[i] = s
+		}
+		return req.Response(strings.Join(parts, ","))
+	}
+	svc := FanOut(50*time.Millisecond, merge, echo("a", 0), slow, echo("c", time.Millisecond))
+
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.Write([]byte("body"))
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "a:body,incomplete,c:body", body)
+	assert.Empty(t, req.Header.Get("X-Mutated"))
+
+	// The slow Service's context is cancelled once the deadline passes
+	select {
+	case <-released:
+	case <-time.After(time.Second):
+		t.Fatal("slow Service was not cancelled")
+	}
+}
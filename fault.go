@@ -0,0 +1,60 @@
+package typhon
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// FaultInjectionConfig configures a FaultInjectionFilter.
+type FaultInjectionConfig struct {
+	// Match selects the requests into which faults may be injected (eg. by route or header). If nil, all requests are
+	// eligible.
+	Match func(req Request) bool
+	// DelayProbability is the probability, between 0 and 1, that an eligible request is delayed by Delay before it is
+	// handled.
+	DelayProbability float64
+	Delay            time.Duration
+	// ErrorProbability is the probability, between 0 and 1, that an eligible request fails with Error instead of being
+	// handled.
+	ErrorProbability float64
+	// Error is returned for requests chosen to fail. If nil, an internal_service.injected_fault error is used.
+	Error error
+}
+
+// FaultInjectionFilter returns a Filter which injects latency and errors into requests, for testing the resilience of
+// callers. Faults are injected at random according to the configured probabilities; when both are zero, requests are
+// passed straight through, so the filter is safe to leave in place (disabled) in production.
+//
+// A delay and an error may both be injected into the same request, in which case the delay happens first. Delays are
+// cut short if the request is cancelled.
+func FaultInjectionFilter(config FaultInjectionConfig) Filter {
+	return func(req Request, svc Service) Response {
+		if config.DelayProbability <= 0 && config.ErrorProbability <= 0 {
+			return svc(req)
+		}
+		if config.Match != nil && !config.Match(req) {
+			return svc(req)
+		}
+
+		if config.DelayProbability > 0 && rand.Float64() < config.DelayProbability {
+			t := time.NewTimer(config.Delay)
+			select {
+			case <-t.C:
+			case <-req.Done():
+				t.Stop()
+			}
+		}
+		if config.ErrorProbability > 0 && rand.Float64() < config.ErrorProbability {
+			err := config.Error
+			if err == nil {
+				err = terrors.InternalService("injected_fault", "Injected fault", nil)
+			}
+			rsp := NewResponse(req)
+			rsp.Error = err
+			return rsp
+		}
+		return svc(req)
+	}
+}
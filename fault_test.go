@@ -0,0 +1,66 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaultInjectionFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	})
+
+	// Disabled: no faults
+	disabled := svc.Filter(FaultInjectionFilter(FaultInjectionConfig{
+		Delay: time.Hour,
+		Error: terrors.Forbidden("nope", "Nope", nil)})).Filter(ErrorFilter)
+	rsp := disabled(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+
+	// Always error, but only for matching requests
+	failing := svc.Filter(FaultInjectionFilter(FaultInjectionConfig{
+		Match: func(req Request) bool {
+			return req.Header.Get("X-Chaos") != ""
+		},
+		ErrorProbability: 1,
+		Error:            terrors.RateLimited("chaos", "Chaos", nil)})).Filter(ErrorFilter)
+	rsp = failing(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("X-Chaos", "1")
+	rsp = failing(req)
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode)
+
+	// Default error
+	failing = svc.Filter(FaultInjectionFilter(FaultInjectionConfig{
+		ErrorProbability: 1})).Filter(ErrorFilter)
+	rsp = failing(NewRequest(context.Background(), "GET", "/", nil))
+	assert.True(t, terrors.Is(rsp.Error, "internal_service.injected_fault"))
+
+	// Always delay
+	delayed := svc.Filter(FaultInjectionFilter(FaultInjectionConfig{
+		DelayProbability: 1,
+		Delay:            20 * time.Millisecond}))
+	before := time.Now()
+	rsp = delayed(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.True(t, time.Since(before) >= 20*time.Millisecond)
+
+	// Delays are cut short by cancellation
+	delayed = svc.Filter(FaultInjectionFilter(FaultInjectionConfig{
+		DelayProbability: 1,
+		Delay:            time.Hour}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rsp = delayed(NewRequest(ctx, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+}
@@ -0,0 +1,160 @@
+package typhon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/monzo/terrors"
+)
+
+// FileServerOptions configures a FileServer.
+type FileServerOptions struct {
+	// FS is the file system to serve files from, eg. an embed.FS. If nil, the operating system's file system is used.
+	FS fs.FS
+	// Param is the name of the router path parameter which holds the path of the file to serve, eg. "path" for the
+	// route "/static/*path". If empty, or the request was not dispatched by a Router, the whole URL path is used.
+	Param string
+	// Index is the name of the file served for requests for a directory. If empty, "index.html" is used. Directory
+	// listings are never served.
+	Index string
+	// ContentETags enables strong ETags computed from a hash of each file's contents, so clients can revalidate cached
+	// files with If-None-Match requests. Hashes are computed once per file and cached, so this must only be used with
+	// file systems whose contents never change, such as an embed.FS.
+	ContentETags bool
+	// CacheControl, if set, is sent with every file served. For immutable content such as an embed.FS, a long MaxAge
+	// is appropriate (ideally with ContentETags, so clients can revalidate once it has passed).
+	CacheControl *CacheControl
+}
+
+// FileServer returns a Service which serves files from the root directory, or (if opts.FS is set) from the root
+// directory within that file system; pass "." to serve the whole file system. The Content-Type is detected from the
+// file's extension or contents, and Range and conditional (If-Modified-Since) requests are supported.
+//
+// To bake assets into the binary, serve them from an embed.FS:
+//
+//	//go:embed ui
+//	var ui embed.FS
+//	...
+//	router.GET("/ui/*path", typhon.FileServer("ui", typhon.FileServerOptions{
+//	    FS:           ui,
+//	    Param:        "path",
+//	    ContentETags: true,
+//	    CacheControl: &typhon.CacheControl{Public: true, MaxAge: 24 * time.Hour}}))
+//
+// Request paths can't escape the root directory, but note that symbolic links within the operating system's file
+// system are followed. Files are buffered in memory as they are served, so this is best suited to small assets.
+func FileServer(root string, opts FileServerOptions) Service {
+	var fsys fs.FS
+	if opts.FS == nil {
+		fsys = os.DirFS(root)
+	} else if sub, err := fs.Sub(opts.FS, root); err != nil {
+		panic(err)
+	} else {
+		fsys = sub
+	}
+	index := opts.Index
+	if index == "" {
+		index = "index.html"
+	}
+	etags := sync.Map{} // file name -> ETag
+
+	return func(req Request) Response {
+		p := req.URL.Path
+		if opts.Param != "" {
+			if router := RouterForRequest(req); router != nil {
+				p = router.Params(req)[opts.Param]
+			}
+		}
+		// Cleaning a rooted path removes any ".." components, so the path can't escape the root
+		name := strings.TrimPrefix(path.Clean("/"+p), "/")
+		if name == "" {
+			name = "."
+		}
+
+		f, stat, err := openFile(fsys, name)
+		if err == nil && stat.IsDir() {
+			f.Close()
+			// Relative links in an index file only work if the directory is requested with a trailing slash
+			if !strings.HasSuffix(req.URL.Path, "/") {
+				rsp := NewResponse(req)
+				rsp.Header.Set("Location", req.URL.Path+"/")
+				rsp.StatusCode = http.StatusMovedPermanently
+				return rsp
+			}
+			name = path.Join(name, index)
+			f, stat, err = openFile(fsys, name)
+			if err == nil && stat.IsDir() {
+				f.Close()
+				err = fs.ErrNotExist
+			}
+		}
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return Response{
+				Error: terrors.NotFound("file", "File not found", nil)}
+		case errors.Is(err, fs.ErrPermission):
+			return Response{
+				Error: terrors.Forbidden("file", "File access forbidden", nil)}
+		case err != nil:
+			return Response{
+				Error: terrors.Wrap(err, nil)}
+		}
+		defer f.Close()
+
+		content, ok := f.(io.ReadSeeker)
+		if !ok {
+			b, err := ioutil.ReadAll(f)
+			if err != nil {
+				return Response{
+					Error: terrors.Wrap(err, nil)}
+			}
+			content = bytes.NewReader(b)
+		}
+		rsp := NewResponse(req)
+		if opts.ContentETags {
+			etag, ok := etags.Load(name)
+			if !ok {
+				h := sha256.New()
+				if _, err := io.Copy(h, content); err != nil {
+					return Response{
+						Error: terrors.Wrap(err, nil)}
+				}
+				if _, err := content.Seek(0, io.SeekStart); err != nil {
+					return Response{
+						Error: terrors.Wrap(err, nil)}
+				}
+				etag, _ = etags.LoadOrStore(name, `"`+hex.EncodeToString(h.Sum(nil)[:16])+`"`)
+			}
+			// ServeContent handles If-None-Match when the ETag header is set
+			rsp.Header.Set("ETag", etag.(string))
+		}
+		if opts.CacheControl != nil {
+			rsp.SetCacheControl(*opts.CacheControl)
+		}
+		http.ServeContent(rsp.Writer(), &req.Request, stat.Name(), stat.ModTime(), content)
+		return rsp
+	}
+}
+
+func openFile(fsys fs.FS, name string) (fs.File, fs.FileInfo, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, stat, nil
+}
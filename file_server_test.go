@@ -0,0 +1,127 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileServer(t *testing.T) {
+	t.Parallel()
+
+	modified := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"public/hello.txt":       {Data: []byte("hello world"), ModTime: modified},
+		"public/docs/index.html": {Data: []byte("<html>docs</html>"), ModTime: modified},
+		"public/empty/.keep":     {},
+		"secret.txt":             {Data: []byte("secret")}}
+	router := Router{}
+	router.GET("/static/*path", FileServer("public", FileServerOptions{
+		FS:    fsys,
+		Param: "path"}))
+	svc := router.Serve().Filter(ErrorFilter)
+	get := func(path string, headers map[string]string) Response {
+		req := NewRequest(context.Background(), "GET", path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return svc(req)
+	}
+
+	rsp := get("/static/hello.txt", nil)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "text/plain; charset=utf-8", rsp.Header.Get("Content-Type"))
+	assert.Equal(t, modified.Format(http.TimeFormat), rsp.Header.Get("Last-Modified"))
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "hello world", string(b))
+
+	// Range requests
+	rsp = get("/static/hello.txt", map[string]string{"Range": "bytes=6-"})
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	b, _ = rsp.BodyBytes(true)
+	assert.Equal(t, "world", string(b))
+
+	// Conditional requests
+	rsp = get("/static/hello.txt", map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)})
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusNotModified, rsp.StatusCode)
+
+	// Directory index
+	rsp = get("/static/docs/", nil)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "text/html; charset=utf-8", rsp.Header.Get("Content-Type"))
+	rsp = get("/static/docs", nil)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusMovedPermanently, rsp.StatusCode)
+	assert.Equal(t, "/static/docs/", rsp.Header.Get("Location"))
+	rsp = get("/static/empty/", nil)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound))
+
+	// Missing files and path traversal
+	rsp = get("/static/missing.txt", nil)
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	rsp = get("/static/../secret.txt", nil)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound))
+	rsp = get("/static/%2e%2e/secret.txt", nil)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound))
+}
+
+func TestFileServerDirectory(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>hi</html>"), 0644))
+	svc := FileServer(dir, FileServerOptions{}).Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "<html>hi</html>", string(b))
+
+	rsp = svc(NewRequest(context.Background(), "GET", "/../../etc/passwd", nil))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound))
+}
+
+func TestFileServerContentETags(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"app.js":  {Data: []byte("console.log('hi')")},
+		"app.css": {Data: []byte("body {}")}}
+	svc := FileServer(".", FileServerOptions{
+		FS:           fsys,
+		ContentETags: true,
+		CacheControl: &CacheControl{
+			Public: true,
+			MaxAge: 24 * time.Hour}}).Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/app.js", nil))
+	require.NoError(t, rsp.Error)
+	etag := rsp.Header.Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, "public, max-age=86400", rsp.Header.Get("Cache-Control"))
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "console.log('hi')", string(b))
+
+	// The ETag is stable, and differs between files
+	rsp = svc(NewRequest(context.Background(), "GET", "/app.js", nil))
+	assert.Equal(t, etag, rsp.Header.Get("ETag"))
+	rsp = svc(NewRequest(context.Background(), "GET", "/app.css", nil))
+	assert.NotEqual(t, etag, rsp.Header.Get("ETag"))
+
+	req := NewRequest(context.Background(), "GET", "/app.js", nil)
+	req.SetIfNoneMatch(etag)
+	rsp = svc(req)
+	require.NoError(t, rsp.Error)
+	assert.True(t, rsp.NotModified())
+}
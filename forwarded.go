@@ -0,0 +1,204 @@
+package typhon
+
+import (
+	"net"
+	"strings"
+)
+
+// A ForwardedElement is one element of a Forwarded header (RFC 7239), describing one hop of a request's path through
+// proxies. Parameters which weren't given are empty.
+type ForwardedElement struct {
+	// For identifies the client which made the request to the proxy: usually its IP address (with an optional port,
+	// and IPv6 addresses in brackets), or "unknown" or an obfuscated identifier.
+	For string
+	// By identifies the proxy's interface on which the request was received.
+	By string
+	// Host is the Host of the request received by the proxy.
+	Host string
+	// Proto is the scheme ("http" or "https") of the request received by the proxy.
+	Proto string
+}
+
+// String formats the element as it appears in a Forwarded header, quoting values where necessary.
+func (e ForwardedElement) String() string {
+	var parts []string
+	for _, p := range [...]struct{ k, v string }{{"for", e.For}, {"by", e.By}, {"host", e.Host}, {"proto", e.Proto}} {
+		if p.v != "" {
+			parts = append(parts, p.k+"="+quoteForwardedValue(p.v))
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// Forwarded returns the elements of the request's Forwarded headers, in order: the first describes the hop from the
+// original client. Parameters other than for, by, host and proto are ignored, as are malformed elements.
+func (r Request) Forwarded() []ForwardedElement {
+	var elems []ForwardedElement
+	for _, h := range r.Header.Values("Forwarded") {
+		elems = append(elems, parseForwarded(h)...)
+	}
+	return elems
+}
+
+// AddForwarded appends an element to the request's Forwarded header. If the header was split across several lines,
+// they are combined into one.
+func (r *Request) AddForwarded(e ForwardedElement) {
+	values := append(r.Header.Values("Forwarded"), e.String())
+	r.Header.Set("Forwarded", strings.Join(values, ", "))
+}
+
+// ForwardedElement returns the element which describes the hop by which the request reached this server: the address
+// of its client, the Host it was addressed to and its scheme. by (which may be empty) identifies this server.
+func (r Request) ForwardedElement(by string) ForwardedElement {
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	e := ForwardedElement{
+		For:   r.RemoteAddr,
+		By:    by,
+		Host:  r.Host,
+		Proto: proto}
+	if e.For == "" {
+		e.For = "unknown"
+	}
+	return e
+}
+
+// ForwardedFilter returns a server Filter which appends the element describing the hop by which each request arrived
+// (see Request.ForwardedElement) to its Forwarded header, for proxies (eg. ProxyService) to pass downstream. by (which
+// may be empty) identifies this proxy.
+func ForwardedFilter(by string) Filter {
+	return func(req Request, svc Service) Response {
+		req.Header = cloneHeader(req.Header)
+		req.AddForwarded(req.ForwardedElement(by))
+		return svc(req)
+	}
+}
+
+// ForwardedClientIP returns a function which resolves the IP address of a request's original client (eg. for
+// NewIPConcurrencyLimit) from its Forwarded header, for a service behind trustedProxies proxies which each append to
+// it. Elements added by untrusted parties (ie. the client itself) are ignored, so the client's address can't be
+// spoofed, provided that the number of proxies is right. Without enough elements, the furthest known address is used.
+// The function returns "" if the client's address is unknown or obfuscated.
+func ForwardedClientIP(trustedProxies int) func(req Request) string {
+	return func(req Request) string {
+		var chain []string
+		for _, e := range req.Forwarded() {
+			chain = append(chain, e.For)
+		}
+		chain = append(chain, req.RemoteAddr)
+		i := len(chain) - 1 - trustedProxies
+		if i < 0 {
+			i = 0
+		}
+		return forwardedNodeIP(chain[i])
+	}
+}
+
+// forwardedNodeIP returns the IP address of a node identifier, without any port or brackets, or "" if it isn't an IP
+// address.
+func forwardedNodeIP(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	if net.ParseIP(node) == nil {
+		return ""
+	}
+	return node
+}
+
+// parseForwarded parses the elements of a Forwarded header.
+func parseForwarded(h string) []ForwardedElement {
+	var elems []ForwardedElement
+elements:
+	for _, elem := range splitQuoted(h, ',') {
+		e := ForwardedElement{}
+		for _, pair := range splitQuoted(elem, ';') {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			i := strings.IndexByte(pair, '=')
+			if i <= 0 {
+				continue elements
+			}
+			v, ok := unquoteForwardedValue(pair[i+1:])
+			if !ok {
+				continue elements
+			}
+			switch strings.ToLower(pair[:i]) {
+			case "for":
+				e.For = v
+			case "by":
+				e.By = v
+			case "host":
+				e.Host = v
+			case "proto":
+				e.Proto = strings.ToLower(v)
+			}
+		}
+		if e != (ForwardedElement{}) {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+// splitQuoted splits s around each instance of sep which isn't within a quoted-string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquoteForwardedValue returns the value of a token or quoted-string. ok is false if a quoted-string is malformed.
+func unquoteForwardedValue(v string) (string, bool) {
+	if !strings.HasPrefix(v, `"`) {
+		return v, true
+	}
+	if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 1; i < len(v)-1; i++ {
+		if v[i] == '\\' && i+1 < len(v)-1 {
+			i++
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String(), true
+}
+
+// quoteForwardedValue returns v as a token if possible, or as a quoted-string otherwise.
+func quoteForwardedValue(v string) string {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
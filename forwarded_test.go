@@ -0,0 +1,85 @@
+package typhon
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwardedParsing(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	// The examples from RFC 7239 §4, with an invalid element
+	req.Header.Add("Forwarded", `for="_gazonk"`)
+	req.Header.Add("Forwarded", `For="[2001:db8:cafe::17]:4711", for=192.0.2.60;proto=HTTP;by=203.0.113.43`)
+	req.Header.Add("Forwarded", `for=192.0.2.43, bogus, for=198.51.100.17;host="a;b,c"`)
+	assert.Equal(t, []ForwardedElement{
+		{For: "_gazonk"},
+		{For: "[2001:db8:cafe::17]:4711"},
+		{For: "192.0.2.60", Proto: "http", By: "203.0.113.43"},
+		{For: "192.0.2.43"},
+		{For: "198.51.100.17", Host: "a;b,c"}}, req.Forwarded())
+}
+
+func TestForwardedGeneration(t *testing.T) {
+	t.Parallel()
+	e := ForwardedElement{
+		For:   "[2001:db8:cafe::17]:4711",
+		By:    "proxy-1",
+		Host:  "example.com",
+		Proto: "https"}
+	assert.Equal(t, `for="[2001:db8:cafe::17]:4711";by=proxy-1;host=example.com;proto=https`, e.String())
+	assert.Equal(t, `for="a\"b"`, ForwardedElement{For: `a"b`}.String())
+
+	// Each proxy appends its hop, which the next can parse
+	var downstream Request
+	svc := Service(func(req Request) Response {
+		downstream = req
+		return req.Response(nil)
+	}).Filter(ForwardedFilter("proxy-1"))
+
+	req := NewRequest(context.Background(), "GET", "http://example.com/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("Forwarded", "for=198.51.100.1")
+	req.TLS = &tls.ConnectionState{}
+	svc(req)
+	assert.Equal(t, "for=198.51.100.1", req.Header.Get("Forwarded"))
+	assert.Equal(t, []ForwardedElement{
+		{For: "198.51.100.1"},
+		{For: "192.0.2.1:1234", By: "proxy-1", Host: "example.com", Proto: "https"}}, downstream.Forwarded())
+
+	// Elements from every line of the header are kept
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Add("Forwarded", "for=198.51.100.1")
+	req.Header.Add("Forwarded", "for=198.51.100.2")
+	req.AddForwarded(ForwardedElement{For: "198.51.100.3"})
+	assert.Equal(t, []string{"for=198.51.100.1, for=198.51.100.2, for=198.51.100.3"}, req.Header.Values("Forwarded"))
+}
+
+func TestForwardedClientIP(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.RemoteAddr = "10.0.0.2:5555" // the nearest proxy
+	req.Header.Set("Forwarded", `for=203.0.113.9, for="[2001:db8::1]:4711", for=10.0.0.1`)
+
+	assert.Equal(t, "10.0.0.2", ForwardedClientIP(0)(req))
+	assert.Equal(t, "10.0.0.1", ForwardedClientIP(1)(req))
+	assert.Equal(t, "2001:db8::1", ForwardedClientIP(2)(req))
+	assert.Equal(t, "203.0.113.9", ForwardedClientIP(3)(req))
+	assert.Equal(t, "203.0.113.9", ForwardedClientIP(10)(req))
+
+	req.Header.Set("Forwarded", "for=unknown")
+	assert.Equal(t, "", ForwardedClientIP(1)(req))
+
+	// It can be used for per-IP limits
+	l := NewIPConcurrencyLimit(1, ForwardedClientIP(1))
+	req.Header.Set("Forwarded", "for=203.0.113.9")
+	l.Filter(req, func(req Request) Response {
+		assert.Equal(t, map[string]int{"203.0.113.9": 1}, l.Stats().InFlight)
+		return req.Response(nil)
+	})
+	require.Empty(t, l.Stats().InFlight)
+}
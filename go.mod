@@ -10,6 +10,7 @@ require (
 	github.com/monzo/terrors v0.0.0-20201123122426-526801726c25
 	github.com/stretchr/testify v1.2.2
 	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+	golang.org/x/text v0.3.0
 	google.golang.org/protobuf v1.25.0
 )
 
@@ -19,6 +20,5 @@ require (
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616 // indirect
-	golang.org/x/text v0.3.0 // indirect
 	golang.org/x/tools v0.0.0-20200130002326-2f3ba24bd6e7 // indirect
 )
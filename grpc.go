@@ -0,0 +1,54 @@
+package typhon
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/monzo/terrors"
+)
+
+// grpcCodes maps gRPC status codes to roughly equivalent terrors codes. Codes which aren't listed map to
+// terrors.ErrInternalService.
+// See: https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+var grpcCodes = map[int]string{
+	3:  terrors.ErrBadRequest,         // INVALID_ARGUMENT
+	4:  terrors.ErrTimeout,            // DEADLINE_EXCEEDED
+	5:  terrors.ErrNotFound,           // NOT_FOUND
+	6:  terrors.ErrBadRequest,         // ALREADY_EXISTS
+	7:  terrors.ErrForbidden,          // PERMISSION_DENIED
+	8:  terrors.ErrRateLimited,        // RESOURCE_EXHAUSTED
+	9:  terrors.ErrPreconditionFailed, // FAILED_PRECONDITION
+	11: terrors.ErrBadRequest,         // OUT_OF_RANGE
+	14: ErrServiceUnavailable,         // UNAVAILABLE
+	16: terrors.ErrUnauthorized}       // UNAUTHENTICATED
+
+// GRPCError returns an error representing the gRPC status of the response, read from its grpc-status and
+// grpc-message trailers (or headers, for gRPC "trailers-only" responses). The body is read if necessary; see Trailers.
+// It returns nil if the status is OK (0) or there is none. The error's code is the closest terrors equivalent to the
+// gRPC status code, which is included in its "grpc_status" param.
+func (r *Response) GRPCError() error {
+	if r.Response == nil {
+		return nil
+	}
+	status, message := r.Header.Get("Grpc-Status"), r.Header.Get("Grpc-Message")
+	if status == "" {
+		trailers := r.Trailers()
+		status, message = trailers.Get("Grpc-Status"), trailers.Get("Grpc-Message")
+	}
+	if status == "" || status == "0" {
+		return nil
+	}
+
+	code, ok := terrors.ErrInternalService, false
+	if n, err := strconv.Atoi(status); err == nil {
+		if code, ok = grpcCodes[n]; !ok {
+			code = terrors.ErrInternalService
+		}
+	}
+	// grpc-message is percent-encoded
+	if m, err := url.PathUnescape(message); err == nil {
+		message = m
+	}
+	return terrors.New(code, message, map[string]string{
+		"grpc_status": status})
+}
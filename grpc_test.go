@@ -0,0 +1,70 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseTrailers(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.DeclareTrailer("Grpc-Status", "Grpc-Message")
+		rsp.Body = ioutil.NopCloser(strings.NewReader("body"))
+		rsp.ContentLength = -1
+		rsp.SetTrailer("Grpc-Status", req.URL.Query().Get("status"))
+		rsp.SetTrailer("Grpc-Message", "not%20found")
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "http://" + s.Listener().Addr().String() + "/"
+	client := Service(BareClient)
+
+	rsp := NewRequest(context.Background(), "GET", url+"?status=5", nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	// Trailers aren't available until the body has been read...
+	assert.Empty(t, rsp.Trailer.Get("Grpc-Status"))
+	// ...which Trailers does, while leaving the body readable
+	assert.Equal(t, "5", rsp.Trailers().Get("Grpc-Status"))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(b))
+
+	err = rsp.GRPCError()
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, terrors.ErrNotFound))
+	assert.Equal(t, "not found", err.(*terrors.Error).Message)
+	assert.Equal(t, "5", err.(*terrors.Error).Params["grpc_status"])
+
+	rsp = NewRequest(context.Background(), "GET", url+"?status=0", nil).SendVia(client).Response()
+	assert.NoError(t, rsp.GRPCError())
+
+	rsp = NewRequest(context.Background(), "GET", url+"?status=13", nil).SendVia(client).Response()
+	assert.True(t, terrors.Is(rsp.GRPCError(), terrors.ErrInternalService))
+}
+
+func TestResponseGRPCErrorTrailersOnly(t *testing.T) {
+	t.Parallel()
+
+	rsp := NewResponse(Request{})
+	rsp.Header.Set("Grpc-Status", "14")
+	rsp.Body = ioutil.NopCloser(strings.NewReader(""))
+	err := rsp.GRPCError()
+	assert.True(t, terrors.Is(err, ErrServiceUnavailable))
+	assert.Equal(t, http.StatusServiceUnavailable, ErrorStatusCode(err))
+
+	rsp = NewResponse(Request{})
+	assert.NoError(t, rsp.GRPCError())
+	rsp = Response{}
+	assert.Nil(t, rsp.Trailers())
+}
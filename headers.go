@@ -0,0 +1,122 @@
+package typhon
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+const redactedHeaderValue = "[REDACTED]"
+
+// RedactedHeaders lists the headers whose values are replaced with a placeholder whenever Typhon logs headers (eg. in
+// DebugLogFilter), so that credentials don't end up in logs. Services can add their own sensitive headers, such as
+// X-Api-Key. Like Client, it MUST only be modified before use takes place; access is not synchronised.
+var RedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// RedactHeaders returns a copy of h which is safe to log: the values of RedactedHeaders, and of any extra headers
+// named, are replaced with a placeholder.
+func RedactHeaders(h http.Header, extra ...string) http.Header {
+	redact := headerSet(RedactedHeaders)
+	for k := range headerSet(extra) {
+		redact[k] = true
+	}
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		if redact[http.CanonicalHeaderKey(k)] {
+			out[k] = []string{redactedHeaderValue}
+		} else {
+			out[k] = append([]string(nil), v...)
+		}
+	}
+	return out
+}
+
+// headerSet returns the set of the canonical forms of the passed header names.
+func headerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	return set
+}
+
+// MaxHeadersFilter returns a Filter which rejects requests carrying more than max individual header values with a
+// 431 (Request Header Fields Too Large) error. Repeated headers count once per value.
+//
+// This complements WithMaxHeaderBytes, which bounds the total size of the headers but not how many there are.
+func MaxHeadersFilter(max int) Filter {
+	return func(req Request, svc Service) Response {
+		n := 0
+		for _, vs := range req.Header {
+			n += len(vs)
+		}
+		if n > max {
+			rsp := NewResponse(req)
+			rsp.Error = terrors.New(ErrRequestHeaderFieldsTooLarge,
+				fmt.Sprintf("Request has %d headers; at most %d are permitted", n, max),
+				map[string]string{
+					"headers":     strconv.Itoa(n),
+					"max_headers": strconv.Itoa(max)})
+			return rsp
+		}
+		return svc(req)
+	}
+}
+
+// RequireHeadersFilter returns a Filter which rejects requests where any of the named headers is absent or empty with
+// a 400 (Bad Request) error naming the missing headers.
+func RequireHeadersFilter(names ...string) Filter {
+	validators := make(map[string]func(string) bool, len(names))
+	for _, name := range names {
+		validators[name] = nil
+	}
+	return ValidateHeadersFilter(validators)
+}
+
+// ValidateHeadersFilter returns a Filter which requires each header named in the passed map to be present and
+// non-empty. If the header's predicate is non-nil, its value must also satisfy it. Requests failing these checks are
+// rejected with a 400 (Bad Request) error naming the offending headers, without calling the Service.
+func ValidateHeadersFilter(validators map[string]func(value string) bool) Filter {
+	canonical := make(map[string]func(string) bool, len(validators))
+	names := make([]string, 0, len(validators))
+	for name, pred := range validators {
+		name = http.CanonicalHeaderKey(name)
+		canonical[name] = pred
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(req Request, svc Service) Response {
+		var missing, invalid []string
+		for _, name := range names {
+			v := req.Header.Get(name)
+			switch {
+			case v == "":
+				missing = append(missing, name)
+			case canonical[name] != nil && !canonical[name](v):
+				invalid = append(invalid, name)
+			}
+		}
+		if len(missing) == 0 && len(invalid) == 0 {
+			return svc(req)
+		}
+
+		rsp := NewResponse(req)
+		if len(missing) > 0 {
+			rsp.Error = terrors.BadRequest("missing_header",
+				fmt.Sprintf("Missing required header(s): %s", strings.Join(missing, ", ")),
+				map[string]string{
+					"headers": strings.Join(missing, ",")})
+		} else {
+			rsp.Error = terrors.BadRequest("invalid_header",
+				fmt.Sprintf("Invalid value for header(s): %s", strings.Join(invalid, ", ")),
+				map[string]string{
+					"headers": strings.Join(invalid, ",")})
+		}
+		return rsp
+	}
+}
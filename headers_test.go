@@ -0,0 +1,124 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxHeadersFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	})
+	svc = svc.Filter(MaxHeadersFilter(3)).Filter(ErrorFilter)
+
+	// Within the limit (repeated values count individually)
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Add("a", "1")
+	req.Header.Add("a", "2")
+	req.Header.Add("b", "1")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	// Over the limit
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	for i := 0; i < 4; i++ {
+		req.Header.Add("a", fmt.Sprintf("%d", i))
+	}
+	rsp = svc(req)
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, ErrRequestHeaderFieldsTooLarge))
+	assert.Equal(t, "4", terrors.Wrap(rsp.Error, nil).(*terrors.Error).Params["headers"])
+}
+
+func TestServerMaxHeaderBytes(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	})
+	s, err := Listen(svc, "localhost:0", WithMaxHeaderBytes(1024))
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	assert.Equal(t, 1024, s.srv.MaxHeaderBytes)
+}
+
+func TestRequireHeadersFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	})
+	svc = svc.Filter(RequireHeadersFilter("x-tenant-id", "X-Request-Id")).Filter(ErrorFilter)
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("X-Tenant-Id", "tenant")
+	req.Header.Set("X-Request-Id", "abc")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("X-Tenant-Id", "")
+	rsp = svc(req)
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	terr := terrors.Wrap(rsp.Error, nil).(*terrors.Error)
+	assert.Equal(t, "bad_request.missing_header", terr.Code)
+	assert.Equal(t, "X-Request-Id,X-Tenant-Id", terr.Params["headers"])
+}
+
+func TestValidateHeadersFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	})
+	svc = svc.Filter(ValidateHeadersFilter(map[string]func(string) bool{
+		"x-tenant-id": func(v string) bool {
+			return strings.HasPrefix(v, "tenant_")
+		}})).Filter(ErrorFilter)
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("X-Tenant-Id", "tenant_123")
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("X-Tenant-Id", "123")
+	rsp = svc(req)
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	terr := terrors.Wrap(rsp.Error, nil).(*terrors.Error)
+	assert.Equal(t, "bad_request.invalid_header", terr.Code)
+	assert.Equal(t, "X-Tenant-Id", terr.Params["headers"])
+}
+
+func TestRedactHeaders(t *testing.T) {
+	t.Parallel()
+	h := http.Header{}
+	h.Set("Authorization", "Bearer secret")
+	h.Add("Cookie", "a=1")
+	h.Add("Cookie", "b=2")
+	h.Set("X-Api-Key", "key")
+	h.Set("X-Request-Id", "123")
+
+	redacted := RedactHeaders(h, "x-api-key")
+	assert.Equal(t, []string{"[REDACTED]"}, redacted["Authorization"])
+	assert.Equal(t, []string{"[REDACTED]"}, redacted["Cookie"])
+	assert.Equal(t, "[REDACTED]", redacted.Get("X-Api-Key"))
+	assert.Equal(t, "123", redacted.Get("X-Request-Id"))
+	// The original is untouched
+	assert.Equal(t, "Bearer secret", h.Get("Authorization"))
+
+	assert.Equal(t, "key", RedactHeaders(h).Get("X-Api-Key"))
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/textproto"
 	"os"
+	"strconv"
 	"sync"
 	"syscall"
 
@@ -81,6 +82,12 @@ func copyErrSeverity(err error) slog.Severity {
 // HttpHandler transforms the given Service into a standard library HTTP handler. It is one of the main "bridges"
 // between Typhon and net/http.
 func HttpHandler(svc Service) http.Handler {
+	return httpHandler(svc, false)
+}
+
+// httpHandler implements HttpHandler. In pass-through mode, all response bodies are streamed to the client as they are
+// read; see WithPassThrough.
+func httpHandler(svc Service, passThrough bool) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, httpReq *http.Request) {
 		if httpReq.Body != nil {
 			defer httpReq.Body.Close()
@@ -92,6 +99,9 @@ func HttpHandler(svc Service) http.Handler {
 		if h, ok := rw.(http.Hijacker); ok {
 			req.hijacker = h
 		}
+		if p, ok := rw.(http.Pusher); ok {
+			req.pusher = p
+		}
 		rsp := svc(req)
 
 		// If the connection was hijacked, we should not attempt to write anything out
@@ -103,12 +113,16 @@ func HttpHandler(svc Service) http.Handler {
 		for k, v := range rsp.Header {
 			rwHeader[k] = v
 		}
+		// Flushing means net/http can't compute the Content-Length itself, so preserve it explicitly
+		if passThrough && rsp.ContentLength > 0 && rwHeader.Get("Content-Length") == "" {
+			rwHeader.Set("Content-Length", strconv.FormatInt(rsp.ContentLength, 10))
+		}
 		rw.WriteHeader(rsp.StatusCode)
 		if rsp.Body != nil {
 			defer rsp.Body.Close()
 			buf := *httpChunkBufPool.Get().(*[]byte)
 			defer httpChunkBufPool.Put(&buf)
-			if isStreamingRsp(rsp) {
+			if passThrough || isStreamingRsp(rsp) {
 				// Streaming responses use copyChunked(), which takes care of flushing transparently
 				if _, err := copyChunked(rw, rsp.Body, buf); err != nil {
 					slog.Log(slog.Eventf(copyErrSeverity(err), req, "Couldn't send streaming response body: %v", err))
@@ -124,5 +138,13 @@ func HttpHandler(svc Service) http.Handler {
 				}
 			}
 		}
+
+		// Trailers were announced in the Trailer header; now the body has been sent their values are final. Setting
+		// them in the header map after the body is how net/http expects to be told about them.
+		for k, v := range rsp.Trailer {
+			if len(v) > 0 {
+				rwHeader[k] = v
+			}
+		}
 	})
 }
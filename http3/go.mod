@@ -0,0 +1,30 @@
+module github.com/monzo/typhon/http3
+
+go 1.22
+
+require (
+	github.com/monzo/slog v0.0.0-20180411100359-4277a1759ecc
+	github.com/monzo/typhon v0.0.0-00010101000000-000000000000
+	github.com/quic-go/quic-go v0.48.2
+	github.com/stretchr/testify v1.9.0
+)
+
+require (
+	github.com/cihub/seelog v0.0.0-20151216151435-d2c6e5aa9fbf // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/deckarep/golang-set v1.7.1 // indirect
+	github.com/golang/protobuf v1.5.0 // indirect
+	github.com/monzo/terrors v0.0.0-20201123122426-526801726c25 // indirect
+	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/quic-go/qpack v0.5.1 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/monzo/typhon => ../
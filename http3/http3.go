@@ -0,0 +1,91 @@
+// Package http3 serves Typhon Services over HTTP/3 (QUIC), using quic-go. It is a separate module so that only
+// services which opt in depend on quic-go.
+//
+// An HTTP/3 server runs alongside the usual HTTP/1.1 and HTTP/2 one (typically on the same port number, as QUIC uses
+// UDP), and clients discover it through the Alt-Svc header which AltSvcFilter adds to the responses of the latter:
+//
+//	h3, err := http3.Listen(svc, ":443", tlsConfig)
+//	…
+//	srv, err := typhon.Listen(svc.Filter(h3.AltSvcFilter), ":443", typhon.WithTLS(tlsConfig))
+package http3
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/typhon"
+	qhttp3 "github.com/quic-go/quic-go/http3"
+)
+
+// A Server serves a Service over HTTP/3.
+type Server struct {
+	srv  *qhttp3.Server
+	conn net.PacketConn
+	done chan struct{}
+}
+
+// Listen starts serving svc over HTTP/3 on the passed UDP address. HTTP/3 always uses TLS, so tlsConfig must provide a
+// certificate; it is cloned, and configured for HTTP/3.
+func Listen(svc typhon.Service, addr string, tlsConfig *tls.Config) (*Server, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return Serve(svc, conn, tlsConfig), nil
+}
+
+// Serve starts serving svc over HTTP/3 on the passed packet connection, as Listen does.
+func Serve(svc typhon.Service, conn net.PacketConn, tlsConfig *tls.Config) *Server {
+	s := &Server{
+		srv: &qhttp3.Server{
+			Handler:   typhon.HttpHandler(svc),
+			TLSConfig: qhttp3.ConfigureTLSConfig(tlsConfig.Clone())},
+		conn: conn,
+		done: make(chan struct{})}
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		// Alt-Svc advertises the port, which isn't known in advance if the address didn't specify one
+		s.srv.Port = addr.Port
+	}
+	go func() {
+		defer close(s.done)
+		if err := s.srv.Serve(conn); err != nil && err != http.ErrServerClosed {
+			slog.Error(nil, "HTTP/3 server terminated: %v", err)
+		}
+	}()
+	return s
+}
+
+// Addr returns the address on which the server is listening.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Done returns a channel which is closed once the server has stopped.
+func (s *Server) Done() <-chan struct{} {
+	return s.done
+}
+
+// Stop stops the server, closing its connections immediately, and waits until it has stopped or ctx is done.
+func (s *Server) Stop(ctx context.Context) {
+	s.srv.Close()
+	s.conn.Close()
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+	}
+}
+
+// AltSvcFilter is a Filter for the Services of other (HTTP/1.1 and HTTP/2) servers, which advertises this server to
+// their clients with an Alt-Svc header, so that clients which support HTTP/3 switch to it for later requests.
+func (s *Server) AltSvcFilter(req typhon.Request, svc typhon.Service) typhon.Response {
+	rsp := svc(req)
+	if rsp.Response != nil && rsp.Header.Get("Alt-Svc") == "" {
+		if err := s.srv.SetQUICHeaders(rsp.Header); err != nil {
+			slog.Warn(req, "Couldn't set Alt-Svc header for HTTP/3: %v", err)
+		}
+	}
+	return rsp
+}
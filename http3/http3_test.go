@@ -0,0 +1,79 @@
+package http3
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/monzo/typhon"
+	qhttp3 "github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfSigned returns a certificate for localhost, and a pool which trusts it
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf}, pool
+}
+
+func TestServer(t *testing.T) {
+	cert, pool := selfSigned(t)
+	svc := typhon.Service(func(req typhon.Request) typhon.Response {
+		return req.Response(req.Proto)
+	})
+	h3, err := Listen(svc, "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	defer h3.Stop(context.Background())
+
+	rt := &qhttp3.RoundTripper{
+		TLSClientConfig: &tls.Config{RootCAs: pool}}
+	defer rt.Close()
+	client := typhon.HttpService(rt)
+	url := fmt.Sprintf("https://%s/", h3.Addr())
+	rsp := client(typhon.NewRequest(context.Background(), "GET", url, nil))
+	require.NoError(t, rsp.Error)
+	var proto string
+	require.NoError(t, rsp.Decode(&proto))
+	assert.Equal(t, "HTTP/3.0", proto)
+
+	// Responses from other servers advertise the HTTP/3 server
+	rsp = svc.Filter(h3.AltSvcFilter)(typhon.NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	port := h3.Addr().(*net.UDPAddr).Port
+	assert.Contains(t, rsp.Header.Get("Alt-Svc"), fmt.Sprintf(`h3=":%d"`, port))
+
+	h3.Stop(context.Background())
+	select {
+	case <-h3.Done():
+	default:
+		assert.Fail(t, "server didn't stop")
+	}
+}
@@ -0,0 +1,88 @@
+package typhon
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// An HTTPVersion is a policy for which version of HTTP a client transport speaks; see HTTPVersionTransport.
+type HTTPVersion int
+
+const (
+	// HTTPVersionAuto negotiates HTTP/2 over TLS where the server supports it, and uses HTTP/1.1 otherwise.
+	HTTPVersionAuto HTTPVersion = iota
+	// HTTPVersion1 only uses HTTP/1.1, even with servers which support HTTP/2. This is useful for downstreams whose
+	// HTTP/2 support misbehaves.
+	HTTPVersion1
+	// HTTPVersion2 only uses HTTP/2: over TLS it must be negotiated (requests to servers which don't support it fail),
+	// and plain-text requests use HTTP/2 with prior knowledge ("h2c"), which the server must support (eg. with
+	// H2cFilter).
+	HTTPVersion2
+)
+
+// HTTPVersionTransport returns a transport which speaks the version(s) of HTTP selected by v, and is otherwise
+// configured like base. If base is nil, the default RoundTripper's configuration is used (if it is an
+// *http.Transport). base isn't modified. Use the transport with HttpService to build a client, or WithRoundTripper to
+// use it for particular requests.
+//
+// HTTPVersion2 transports are built on golang.org/x/net/http2, so only base's TLS configuration and compression
+// setting apply to them; in particular, they don't use proxies.
+func HTTPVersionTransport(base *http.Transport, v HTTPVersion) http.RoundTripper {
+	if base == nil {
+		if rt, ok := RoundTripper.(*http.Transport); ok {
+			base = rt
+		} else {
+			base = &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				IdleConnTimeout:     10 * time.Minute,
+				MaxIdleConnsPerHost: 10}
+		}
+	}
+
+	switch v {
+	case HTTPVersion1:
+		t := base.Clone()
+		t.ForceAttemptHTTP2 = false
+		// A non-nil, empty map disables net/http's automatic HTTP/2 support
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if t.TLSClientConfig != nil {
+			t.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		}
+		return t
+	case HTTPVersion2:
+		var tlsConfig *tls.Config
+		if base.TLSClientConfig != nil {
+			tlsConfig = base.TLSClientConfig.Clone()
+		}
+		return http2OnlyTransport{
+			tls: &http2.Transport{
+				TLSClientConfig:    tlsConfig,
+				DisableCompression: base.DisableCompression},
+			plain: &http2.Transport{
+				AllowHTTP:          true,
+				DisableCompression: base.DisableCompression,
+				DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+					return net.Dial(network, addr)
+				}}}
+	default:
+		t := base.Clone()
+		t.ForceAttemptHTTP2 = true
+		return t
+	}
+}
+
+// http2OnlyTransport sends https requests with HTTP/2 negotiated over TLS, and http requests with h2c.
+type http2OnlyTransport struct {
+	tls, plain *http2.Transport
+}
+
+func (t http2OnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.plain.RoundTrip(req)
+	}
+	return t.tls.RoundTrip(req)
+}
@@ -0,0 +1,80 @@
+package typhon
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPVersionTransport(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	svc := Service(func(req Request) Response {
+		return req.Response(req.Proto)
+	}).Filter(ErrorFilter)
+
+	listen := func(nextProtos ...string) string {
+		var opts []ServerOption
+		scheme := "http"
+		if nextProtos != nil {
+			scheme = "https"
+			opts = append(opts, WithTLS(&tls.Config{
+				Certificates: []tls.Certificate{keypair(t, []string{"localhost"})},
+				NextProtos:   nextProtos}))
+		}
+		s, err := Listen(svc.Filter(H2cFilter), "localhost:0", opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			s.Stop(ctx)
+		})
+		return fmt.Sprintf("%s://%s", scheme, s.Listener().Addr())
+	}
+	h2URL := listen("h2", "http/1.1")
+	h1URL := listen("http/1.1")
+	h2cURL := listen()
+
+	base := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true}}
+	proto := func(v HTTPVersion, url string) (string, error) {
+		client := HttpService(HTTPVersionTransport(base, v)).Filter(ErrorFilter)
+		rsp := NewRequest(ctx, "GET", url, nil).SendVia(client).Response()
+		if rsp.Error != nil {
+			return "", rsp.Error
+		}
+		p := ""
+		err := rsp.Decode(&p)
+		return p, err
+	}
+
+	cases := []struct {
+		version HTTPVersion
+		url     string
+		proto   string // empty if the request should fail
+	}{
+		{HTTPVersionAuto, h2URL, "HTTP/2.0"},
+		{HTTPVersionAuto, h1URL, "HTTP/1.1"},
+		{HTTPVersionAuto, h2cURL, "HTTP/1.1"},
+		{HTTPVersion1, h2URL, "HTTP/1.1"},
+		{HTTPVersion1, h2cURL, "HTTP/1.1"},
+		{HTTPVersion2, h2URL, "HTTP/2.0"},
+		{HTTPVersion2, h2cURL, "HTTP/2.0"},
+		{HTTPVersion2, h1URL, ""}}
+	for _, c := range cases {
+		p, err := proto(c.version, c.url)
+		if c.proto == "" {
+			assert.Error(t, err, "version %d to %s", c.version, c.url)
+		} else if assert.NoError(t, err, "version %d to %s", c.version, c.url) {
+			assert.Equal(t, c.proto, p, "version %d to %s", c.version, c.url)
+		}
+	}
+
+	// The base transport isn't modified
+	assert.Nil(t, base.TLSNextProto)
+	assert.Empty(t, base.TLSClientConfig.NextProtos)
+}
@@ -0,0 +1,103 @@
+package typhon
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/monzo/terrors"
+)
+
+// An IPConcurrencyLimit caps the number of requests from each client IP address which are handled at once, so that a
+// single abusive client can't monopolise a Service. Create one with NewIPConcurrencyLimit; most users will want
+// IPConcurrencyLimitFilter instead.
+type IPConcurrencyLimit struct {
+	// Accessed atomically, so must be first for alignment on 32-bit platforms
+	rejected uint64
+	maxPerIP int
+	clientIP func(req Request) string
+	mtx      sync.Mutex
+	inFlight map[string]int
+}
+
+// IPConcurrencyStats is a snapshot of an IPConcurrencyLimit's counters.
+type IPConcurrencyStats struct {
+	// InFlight is the number of requests currently being handled from each IP address which has any.
+	InFlight map[string]int
+	// Rejected is the total number of requests which were rejected because their IP address was at its limit.
+	Rejected uint64
+}
+
+// NewIPConcurrencyLimit returns an IPConcurrencyLimit which allows up to maxPerIP requests from each client IP address
+// to be handled at once; further requests are rejected immediately with a 429 (Too Many Requests) error.
+//
+// clientIP determines the address of a request's client. Only the connection's peer address can be trusted unless the
+// service is behind a proxy which sets a header such as Forwarded, so clientIP should resolve the address in the way
+// appropriate for the deployment (eg. with ForwardedClientIP). If it is nil, the host part of the request's RemoteAddr
+// is used. Requests for which it returns "" are not limited.
+func NewIPConcurrencyLimit(maxPerIP int, clientIP func(req Request) string) *IPConcurrencyLimit {
+	if clientIP == nil {
+		clientIP = remoteIP
+	}
+	return &IPConcurrencyLimit{
+		maxPerIP: maxPerIP,
+		clientIP: clientIP,
+		inFlight: map[string]int{}}
+}
+
+// IPConcurrencyLimitFilter returns a Filter which bounds the number of concurrent requests from each client IP
+// address, as described by NewIPConcurrencyLimit. Use an IPConcurrencyLimit directly to inspect its counters.
+func IPConcurrencyLimitFilter(maxPerIP int, clientIP func(req Request) string) Filter {
+	return NewIPConcurrencyLimit(maxPerIP, clientIP).Filter
+}
+
+// Stats returns a snapshot of the limit's counters.
+func (l *IPConcurrencyLimit) Stats() IPConcurrencyStats {
+	l.mtx.Lock()
+	inFlight := make(map[string]int, len(l.inFlight))
+	for ip, n := range l.inFlight {
+		inFlight[ip] = n
+	}
+	l.mtx.Unlock()
+	return IPConcurrencyStats{
+		InFlight: inFlight,
+		Rejected: atomic.LoadUint64(&l.rejected)}
+}
+
+// Filter is a Filter which applies the limit to requests. A request is counted until the Service returns (or panics);
+// streamed response bodies are not counted.
+func (l *IPConcurrencyLimit) Filter(req Request, svc Service) Response {
+	ip := l.clientIP(req)
+	if ip == "" {
+		return svc(req)
+	}
+
+	l.mtx.Lock()
+	if l.inFlight[ip] >= l.maxPerIP {
+		l.mtx.Unlock()
+		atomic.AddUint64(&l.rejected, 1)
+		return Response{
+			Error: terrors.RateLimited("concurrency", "Too many concurrent requests from this client", map[string]string{
+				"max_concurrent": strconv.Itoa(l.maxPerIP)})}
+	}
+	l.inFlight[ip]++
+	l.mtx.Unlock()
+	defer func() {
+		l.mtx.Lock()
+		if l.inFlight[ip]--; l.inFlight[ip] <= 0 {
+			delete(l.inFlight, ip)
+		}
+		l.mtx.Unlock()
+	}()
+	return svc(req)
+}
+
+// remoteIP returns the host part of the request's RemoteAddr.
+func remoteIP(req Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
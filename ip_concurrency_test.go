@@ -0,0 +1,76 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPConcurrencyLimit(t *testing.T) {
+	t.Parallel()
+	l := NewIPConcurrencyLimit(2, nil)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		if remoteIP(req) == "10.0.0.1" {
+			started <- struct{}{}
+			<-release
+		}
+		return NewResponse(req)
+	}).Filter(l.Filter)
+
+	reqFrom := func(addr string) Request {
+		req := NewRequest(nil, "GET", "/", nil)
+		req.RemoteAddr = addr
+		return req
+	}
+
+	done := make(chan Response, 2)
+	for _, port := range []string{"1111", "2222"} {
+		go func(port string) {
+			done <- svc(reqFrom("10.0.0.1:" + port))
+		}(port)
+		<-started
+	}
+	assert.Equal(t, map[string]int{"10.0.0.1": 2}, l.Stats().InFlight)
+
+	// A third request from the same IP is rejected,
+	rsp := svc(reqFrom("10.0.0.1:3333"))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrRateLimited))
+	assert.Equal(t, http.StatusTooManyRequests, ErrorStatusCode(rsp.Error))
+	assert.EqualValues(t, 1, l.Stats().Rejected)
+
+	// but one from another IP isn't
+	rsp = svc(reqFrom("10.0.0.2:1111"))
+	assert.NoError(t, rsp.Error)
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, (<-done).Error)
+	}
+	assert.Empty(t, l.Stats().InFlight)
+}
+
+func TestIPConcurrencyLimitPanic(t *testing.T) {
+	t.Parallel()
+	l := NewIPConcurrencyLimit(1, func(req Request) string {
+		return req.Header.Get("X-Client-IP")
+	})
+	svc := Service(func(req Request) Response {
+		panic("boom")
+	}).Filter(l.Filter)
+
+	req := NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("X-Client-IP", "10.0.0.1")
+	for i := 0; i < 2; i++ {
+		assert.Panics(t, func() {
+			svc(req)
+		})
+	}
+	assert.Empty(t, l.Stats().InFlight)
+	assert.Zero(t, l.Stats().Rejected)
+}
@@ -0,0 +1,120 @@
+package typhon
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// A JSONObjectWriter writes the fields of a JSON object one at a time, as passed to the function given to
+// EncodeJSONObject. Once a write fails (eg. because the client has gone away), every subsequent write returns the same
+// error.
+type JSONObjectWriter struct {
+	w      *bufio.Writer
+	fields int
+	err    error
+}
+
+// EncodeJSONObject makes the response's body a JSON object which is streamed to the client as it is produced, so a
+// response with a few huge fields (eg. embedded files) needn't be held in memory in its entirety. fields is called (in
+// a separate goroutine) with a JSONObjectWriter, with which it should write each field in turn; it should return
+// once all have been written, or with the first error from the writer. The object is then closed. Each field is sent
+// as soon as it has been written.
+//
+// If fields returns an error or panics, the body is terminated abruptly, so the client can tell the object is
+// incomplete.
+func (r *Response) EncodeJSONObject(fields func(o *JSONObjectWriter) error) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	// As in EncodeCSV, closing the stream makes writes fail, so the producer stops if the client disconnects
+	s := Streamer().(*streamer)
+	r.Body = s
+	r.ContentLength = -1
+	go func() {
+		var err error
+		defer func() {
+			if v := recover(); v != nil {
+				err = PanicError(v)
+			}
+			s.CloseWithError(err)
+		}()
+
+		o := &JSONObjectWriter{
+			w: bufio.NewWriter(s)}
+		o.w.WriteByte('{')
+		err = fields(o)
+		if err == nil {
+			o.w.WriteByte('}')
+			err = o.flush()
+		}
+	}()
+}
+
+// Field writes a field with the passed value, which is serialised as JSON (using protojson for protobuf messages).
+func (o *JSONObjectWriter) Field(key string, v interface{}) error {
+	var b []byte
+	var err error
+	if m, ok := v.(proto.Message); ok {
+		b, err = protojson.Marshal(m)
+	} else {
+		b, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+	if err := o.key(key); err != nil {
+		return err
+	}
+	o.w.Write(b)
+	return o.flush()
+}
+
+// Base64Field writes a field whose value is a string containing the contents of rdr, base64-encoded (as encoding/json
+// encodes a []byte). The contents are streamed, so they are never held in memory in their entirety.
+func (o *JSONObjectWriter) Base64Field(key string, rdr io.Reader) error {
+	if err := o.key(key); err != nil {
+		return err
+	}
+	o.w.WriteByte('"')
+	enc := base64.NewEncoder(base64.StdEncoding, o.w)
+	if _, err := io.Copy(enc, rdr); err != nil {
+		o.err = err
+		return err
+	}
+	enc.Close()
+	o.w.WriteByte('"')
+	return o.flush()
+}
+
+// key writes the separator from the previous field (if any) and the field's key.
+func (o *JSONObjectWriter) key(key string) error {
+	if o.err != nil {
+		return o.err
+	}
+	b, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	if o.fields > 0 {
+		o.w.WriteByte(',')
+	}
+	o.fields++
+	o.w.Write(b)
+	o.w.WriteByte(':')
+	return nil
+}
+
+func (o *JSONObjectWriter) flush() error {
+	if o.err == nil {
+		o.err = o.w.Flush()
+	}
+	return o.err
+}
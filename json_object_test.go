@@ -0,0 +1,90 @@
+package typhon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/monzo/typhon/prototest"
+)
+
+func TestResponseEncodeJSONObject(t *testing.T) {
+	t.Parallel()
+	blob := bytes.Repeat([]byte{0, 1, 2, 0xff}, 100000)
+
+	rsp := NewResponse(Request{})
+	rsp.EncodeJSONObject(func(o *JSONObjectWriter) error {
+		if err := o.Field("name", "big \"file\""); err != nil {
+			return err
+		}
+		if err := o.Base64Field("data", bytes.NewReader(blob)); err != nil {
+			return err
+		}
+		return o.Field("greeting", &prototest.Greeting{
+			Message: "hello"})
+	})
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	assert.EqualValues(t, -1, rsp.ContentLength)
+
+	v := struct {
+		Name     string                 `json:"name"`
+		Data     []byte                 `json:"data"`
+		Greeting map[string]interface{} `json:"greeting"`
+	}{}
+	require.NoError(t, rsp.Decode(&v))
+	assert.Equal(t, `big "file"`, v.Name)
+	assert.Equal(t, blob, v.Data)
+	assert.Equal(t, "hello", v.Greeting["message"])
+
+	// An empty object is still an object
+	rsp = NewResponse(Request{})
+	rsp.EncodeJSONObject(func(o *JSONObjectWriter) error {
+		return nil
+	})
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(b))
+
+	// A failure truncates the body, so it can't be mistaken for a complete object
+	rsp = NewResponse(Request{})
+	rsp.EncodeJSONObject(func(o *JSONObjectWriter) error {
+		o.Field("a", 1)
+		return errors.New("boom")
+	})
+	b, err = ioutil.ReadAll(rsp.Body)
+	assert.EqualError(t, err, "boom")
+	assert.False(t, json.Valid(b))
+
+	// Closing the body stops the producer
+	done := make(chan error, 1)
+	rsp = NewResponse(Request{})
+	rsp.EncodeJSONObject(func(o *JSONObjectWriter) error {
+		for {
+			if err := o.Base64Field("x", bytes.NewReader(blob)); err != nil {
+				done <- err
+				return err
+			}
+		}
+	})
+	rsp.Body.Close()
+	assert.Error(t, <-done)
+}
+
+func TestResponseEncodeJSONObjectPanic(t *testing.T) {
+	t.Parallel()
+	rsp := NewResponse(Request{})
+	rsp.EncodeJSONObject(func(o *JSONObjectWriter) error {
+		o.Field("a", 1)
+		panic("boom")
+	})
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, "internal_service.panic"))
+	assert.False(t, json.Valid(b))
+}
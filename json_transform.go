@@ -0,0 +1,61 @@
+package typhon
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// JSONTransformFilter returns a Filter which normalises JSON request bodies before they are handled (eg. trimming
+// strings, or lowercasing email addresses), so that the handler's Decode sees the normalised version. The body is
+// buffered and parsed, with objects as map[string]interface{}, arrays as []interface{} and numbers as json.Number
+// (so their precision is preserved). The parsed value is passed to transform, which may modify it in place or return
+// a replacement, and the result is re-serialised to replace the body.
+//
+// Only requests with a JSON Content-Type (application/json or a +json type) and a non-empty body are transformed;
+// others are passed through untouched. A body which isn't valid JSON is rejected with a 400 (Bad Request) error, as is
+// one for which transform fails (unless it returns a terror, which is returned as-is).
+func JSONTransformFilter(transform func(v interface{}) (interface{}, error)) Filter {
+	return func(req Request, svc Service) Response {
+		if req.Body == nil || !isJSONMediaType(req.Header.Get("Content-Type")) {
+			return svc(req)
+		}
+		b, err := req.BodyBytes(true)
+		if err != nil {
+			return Response{
+				Error: terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)}
+		}
+		if len(bytes.TrimSpace(b)) == 0 {
+			req.SetBody(b)
+			return svc(req)
+		}
+
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return Response{
+				Error: terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)}
+		}
+		v, err = transform(v)
+		if err != nil {
+			return Response{
+				Error: terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)}
+		}
+		b, err = json.Marshal(v)
+		if err != nil {
+			return Response{
+				Error: terrors.Wrap(err, nil)}
+		}
+		req.SetBody(b)
+		return svc(req)
+	}
+}
+
+// isJSONMediaType returns whether the passed Content-Type is JSON.
+func isJSONMediaType(contentType string) bool {
+	mediaType, charset := parseContentType(contentType)
+	return charset == "" && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
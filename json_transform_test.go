@@ -0,0 +1,92 @@
+package typhon
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONTransformFilter(t *testing.T) {
+	t.Parallel()
+
+	// Trims all strings, and lowercases emails
+	var normalise func(v interface{}) interface{}
+	normalise = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case string:
+			return strings.TrimSpace(v)
+		case []interface{}:
+			for i := range v {
+				v[i] = normalise(v[i])
+			}
+		case map[string]interface{}:
+			for k := range v {
+				v[k] = normalise(v[k])
+			}
+			if email, ok := v["email"].(string); ok {
+				v["email"] = strings.ToLower(email)
+			}
+		}
+		return v
+	}
+	transform := func(v interface{}) (interface{}, error) {
+		if m, ok := v.(map[string]interface{}); ok && m["reject"] != nil {
+			return nil, terrors.Forbidden("rejected", "Rejected", nil)
+		}
+		return normalise(v), nil
+	}
+
+	var body []byte
+	svc := Service(func(req Request) Response {
+		var err error
+		body, err = req.BodyBytes(true)
+		require.NoError(t, err)
+		return req.Response(nil)
+	}).Filter(JSONTransformFilter(transform)).Filter(ErrorFilter)
+
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Body = ioutil.NopCloser(strings.NewReader(
+		`{"name": "  Alice ", "email": " Alice@Example.COM", "tags": [" a "], "id": 12345678901234567890}`))
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.JSONEq(t, `{"name": "Alice", "email": "alice@example.com", "tags": ["a"], "id": 12345678901234567890}`,
+		string(body))
+	assert.True(t, strings.Contains(string(body), "12345678901234567890"))
+
+	// Other content types are untouched
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Body = ioutil.NopCloser(strings.NewReader(` {"name": " x "} `))
+	require.NoError(t, svc(req).Error)
+	assert.Equal(t, ` {"name": " x "} `, string(body))
+
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"name":`))
+	assert.Equal(t, http.StatusBadRequest, svc(req).StatusCode)
+
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Type", "application/vnd.api+json")
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"reject": true}`))
+	assert.Equal(t, http.StatusForbidden, svc(req).StatusCode)
+
+	// Handlers can decode the normalised body as usual
+	svc = Service(func(req Request) Response {
+		v := map[string]string{}
+		require.NoError(t, req.Decode(&v))
+		return req.Response(v)
+	}).Filter(JSONTransformFilter(transform))
+	req = NewRequest(context.Background(), "POST", "/", map[string]string{"email": "BOB@example.com "})
+	rsp = svc(req)
+	v := map[string]string{}
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&v))
+	assert.Equal(t, "bob@example.com", v["email"])
+}
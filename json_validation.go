@@ -0,0 +1,42 @@
+package typhon
+
+import (
+	"encoding/json"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// JSONResponseValidationFilter returns a Filter which catches handlers that declare a JSON Content-Type but write a
+// body which isn't valid JSON. Such a response is logged as a warning and, if strict is set, replaced with a 500
+// (Internal Server Error) error so the bug can't go unnoticed.
+//
+// Only buffered responses are checked: streamed bodies (which would have to be held in memory) and compressed ones are
+// passed through, as are error responses. Validation costs a pass over every body, so this is a development-time
+// guardrail, and is strictly opt-in.
+func JSONResponseValidationFilter(strict bool) Filter {
+	return func(req Request, svc Service) Response {
+		rsp := svc(req)
+		if rsp.Error != nil || rsp.Response == nil || rsp.Header.Get("Content-Encoding") != "" {
+			return rsp
+		}
+		buf, ok := rsp.Body.(*bufCloser)
+		if !ok || buf.Len() == 0 || !isJSONMediaType(rsp.Header.Get("Content-Type")) {
+			return rsp
+		}
+		if json.Valid(buf.Bytes()) {
+			return rsp
+		}
+
+		slog.Warn(req, "Handler for %s %s responded with %s but its body isn't valid JSON", req.Method, req.URL.Path,
+			rsp.Header.Get("Content-Type"))
+		if !strict {
+			return rsp
+		}
+		return Response{
+			Request: &req,
+			Error: terrors.InternalService("invalid_json_response", "Handler's response body isn't valid JSON",
+				map[string]string{
+					"content_type": rsp.Header.Get("Content-Type")})}
+	}
+}
@@ -0,0 +1,56 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONResponseValidationFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		rsp := req.Response(nil)
+		rsp.Header.Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/valid":
+			rsp.Write([]byte(`{"ok":true}`))
+		case "/invalid":
+			rsp.Write([]byte(`<html>oops</html>`))
+		case "/stream":
+			s := Streamer()
+			go func() {
+				s.Write([]byte(`not json`))
+				s.Close()
+			}()
+			rsp.Body = s
+		case "/text":
+			rsp.Header.Set("Content-Type", "text/plain")
+			rsp.Write([]byte(`not json`))
+		}
+		return rsp
+	})
+	ctx := context.Background()
+
+	lenient := svc.Filter(JSONResponseValidationFilter(false))
+	rsp := lenient(NewRequest(ctx, "GET", "/invalid", nil))
+	require.NoError(t, rsp.Error)
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, `<html>oops</html>`, string(b))
+
+	strict := svc.Filter(JSONResponseValidationFilter(true)).Filter(ErrorFilter)
+	rsp = strict(NewRequest(ctx, "GET", "/invalid", nil))
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, "internal_service.invalid_json_response"))
+
+	for _, path := range []string{"/valid", "/stream", "/text"} {
+		rsp = strict(NewRequest(ctx, "GET", path, nil))
+		assert.NoError(t, rsp.Error, path)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode, path)
+	}
+}
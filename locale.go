@@ -0,0 +1,45 @@
+package typhon
+
+import (
+	"context"
+
+	"golang.org/x/text/language"
+)
+
+type localeContextKeyType struct{}
+
+var localeContextKey = localeContextKeyType{}
+
+// LocaleFilter returns a Filter which chooses the best of the supported locales for each request from its
+// Accept-Language header, and stores it in the request's context, from where it can be read by Locale. The first
+// supported locale is the default, used when the header is absent, malformed, or nothing in it matches.
+//
+// Preferences are weighed by their q-values, and matched using golang.org/x/text/language, so a preference matches a
+// closely-related supported locale (eg. "en-AU" matches "en-GB") if there is no exact match. The chosen locale is
+// always one of those supported.
+func LocaleFilter(supported []language.Tag) Filter {
+	var matcher language.Matcher
+	if len(supported) > 0 {
+		matcher = language.NewMatcher(supported)
+	}
+	return func(req Request, svc Service) Response {
+		if matcher != nil {
+			// A malformed header leaves no preferences, so the default is chosen
+			prefs, _, _ := language.ParseAcceptLanguage(req.Header.Get("Accept-Language"))
+			_, i, _ := matcher.Match(prefs...)
+			req.Context = context.WithValue(req.Context, localeContextKey, supported[i])
+		}
+		return svc(req)
+	}
+}
+
+// Locale returns the locale chosen for the request by LocaleFilter, or language.Und if there is none.
+func Locale(ctx context.Context) language.Tag {
+	if ctx == nil {
+		return language.Und
+	}
+	if locale, ok := ctx.Value(localeContextKey).(language.Tag); ok {
+		return locale
+	}
+	return language.Und
+}
@@ -0,0 +1,47 @@
+package typhon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+)
+
+func TestLocaleFilter(t *testing.T) {
+	t.Parallel()
+
+	supported := []language.Tag{
+		language.BritishEnglish,
+		language.AmericanEnglish,
+		language.French,
+		language.BrazilianPortuguese}
+	svc := Service(func(req Request) Response {
+		return req.Response(Locale(req).String())
+	}).Filter(LocaleFilter(supported))
+
+	cases := map[string]string{
+		"":                                "en-GB",
+		"fr":                              "fr",
+		"en-us":                           "en-US",
+		"fr-CA, en;q=0.8":                 "fr",
+		"de;q=0.9, en-US;q=0.8, fr;q=0.7": "en-US",
+		"de, pt;q=0.5":                    "pt-BR",
+		"en-AU":                           "en-GB",
+		"fr;q=0, en-US;q=0.1":             "en-US",
+		"de, *;q=0.5":                     "en-GB",
+		"ja":                              "en-GB",
+		"not a; valid=header":             "en-GB"}
+	for header, expected := range cases {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		if header != "" {
+			req.Header.Set("Accept-Language", header)
+		}
+		rsp := svc(req)
+		var locale string
+		assert.NoError(t, rsp.Decode(&locale))
+		assert.Equal(t, expected, locale, header)
+	}
+
+	assert.Equal(t, language.Und, Locale(context.Background()))
+}
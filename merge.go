@@ -0,0 +1,67 @@
+package typhon
+
+import (
+	"strconv"
+
+	"github.com/monzo/terrors"
+)
+
+// MergeConfig configures MergeResponses.
+type MergeConfig struct {
+	// Merge is called with each successful response, in order, to decode its body (eg. with Response.Decode) and
+	// merge it into the composite. If it returns an error, the response is treated as having failed.
+	Merge func(i int, rsp Response) error
+	// Partial makes merging continue past failed responses, so that the composite contains whatever could be merged.
+	// Otherwise, the first failure fails the whole response.
+	Partial bool
+	// Failed, if non-nil, is called with each failed response's error when merging partially, so the failure can be
+	// recorded in the composite (eg. in a list of warnings).
+	Failed func(i int, err error)
+}
+
+// MergeResponses combines several downstream responses (eg. those collected by FanOut) into a single response to req
+// whose body is composite, as filled in by config.Merge. This is useful for aggregation endpoints, such as backends
+// for frontends, which assemble one view from several services.
+//
+// A response fails if it has an error, or if merging it fails. Without config.Partial, the first failure is returned
+// as the response's error, with a "response_index" parameter identifying which response failed; with it, failed
+// responses are skipped, and only if every response fails is the response an ErrBadGateway error, whose parameters
+// include each downstream error. Bodies of responses which aren't merged are closed.
+func MergeResponses(req Request, rsps []Response, composite interface{}, config MergeConfig) Response {
+	var failures map[string]string
+	for i, rsp := range rsps {
+		err := rsp.Error
+		if err == nil {
+			err = config.Merge(i, rsp)
+		} else if rsp.Response != nil && rsp.Body != nil {
+			rsp.Body.Close()
+		}
+		if err == nil {
+			continue
+		}
+
+		if !config.Partial {
+			for _, rest := range rsps[i+1:] {
+				if rest.Response != nil && rest.Body != nil {
+					rest.Body.Close()
+				}
+			}
+			return Response{
+				Error: terrors.Wrap(err, map[string]string{
+					"response_index": strconv.Itoa(i)})}
+		}
+		if failures == nil {
+			failures = make(map[string]string, len(rsps))
+		}
+		failures["response_"+strconv.Itoa(i)] = err.Error()
+		if config.Failed != nil {
+			config.Failed(i, err)
+		}
+	}
+
+	if len(rsps) > 0 && len(failures) == len(rsps) {
+		return Response{
+			Error: terrors.New(ErrBadGateway, "All "+strconv.Itoa(len(rsps))+" responses failed", failures)}
+	}
+	return req.Response(composite)
+}
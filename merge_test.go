@@ -0,0 +1,73 @@
+package typhon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeResponses(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(context.Background(), "GET", "/", nil)
+
+	type composite struct {
+		Parts    []string `json:"parts"`
+		Warnings []string `json:"warnings,omitempty"`
+	}
+	merge := func(c *composite) func(i int, rsp Response) error {
+		return func(i int, rsp Response) error {
+			var s string
+			if err := rsp.Decode(&s); err != nil {
+				return err
+			}
+			c.Parts = append(c.Parts, s)
+			return nil
+		}
+	}
+	rsps := func() []Response {
+		return []Response{
+			req.Response("a"),
+			{Error: terrors.NotFound("b", "No b", nil)},
+			req.Response("c")}
+	}
+
+	// By default, the first failure fails the response
+	c := &composite{}
+	rsp := MergeResponses(req, rsps(), c, MergeConfig{
+		Merge: merge(c)})
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound))
+	assert.Equal(t, "1", rsp.Error.(*terrors.Error).Params["response_index"])
+	assert.Equal(t, []string{"a"}, c.Parts)
+
+	// A partial merge skips it
+	c = &composite{}
+	rsp = MergeResponses(req, rsps(), c, MergeConfig{
+		Merge:   merge(c),
+		Partial: true,
+		Failed: func(i int, err error) {
+			c.Warnings = append(c.Warnings, err.Error())
+		}})
+	require.NoError(t, rsp.Error)
+	body := composite{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, []string{"a", "c"}, body.Parts)
+	require.Len(t, body.Warnings, 1)
+	assert.Contains(t, body.Warnings[0], "No b")
+
+	// Unless everything failed
+	c = &composite{}
+	rsp = MergeResponses(req, []Response{
+		{Error: terrors.Timeout("x", "Slow", nil)},
+		req.Response(map[string]string{"not": "a string"})}, c, MergeConfig{
+		Merge:   merge(c),
+		Partial: true})
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, ErrBadGateway))
+	params := rsp.Error.(*terrors.Error).Params
+	assert.Contains(t, params["response_0"], "Slow")
+	assert.NotEmpty(t, params["response_1"])
+}
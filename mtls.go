@@ -0,0 +1,111 @@
+package typhon
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+type clientIdentityContextKeyType struct{}
+
+var clientIdentityContextKey = clientIdentityContextKeyType{}
+
+// A PeerIdentity describes the verified certificate which a client presented over mutual TLS.
+type PeerIdentity struct {
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+	// Certificate is the client's leaf certificate, for inspecting attributes not extracted above.
+	Certificate *x509.Certificate
+}
+
+// WithTLS makes the server serve over TLS with the passed configuration, by wrapping its listener. For HTTP/2 to be
+// negotiated, config.NextProtos must include "h2".
+func WithTLS(config *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.l = tls.NewListener(s.l, config)
+	}
+}
+
+// MutualTLSServerConfig returns a TLS configuration for a server which presents cert, and requires clients to present
+// a certificate signed by one of clientCAs. Pass it to WithTLS; use ClientCertificateFilter to make authorisation
+// decisions based on the client's certificate.
+func MutualTLSServerConfig(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"}}
+}
+
+// MutualTLSTransport returns a transport which presents cert to servers which request a client certificate, and
+// verifies servers against rootCAs (or the system's roots, if nil). It is otherwise configured like the default
+// RoundTripper. Use it with HttpService to build a client, or WithRoundTripper to use it for particular requests.
+func MutualTLSTransport(cert tls.Certificate, rootCAs *x509.CertPool) *http.Transport {
+	var t *http.Transport
+	if rt, ok := RoundTripper.(*http.Transport); ok {
+		t = rt.Clone()
+	} else {
+		t = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			IdleConnTimeout:     10 * time.Minute,
+			MaxIdleConnsPerHost: 10}
+	}
+	t.TLSClientConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      rootCAs,
+		MinVersion:   tls.VersionTLS12}
+	t.ForceAttemptHTTP2 = true
+	return t
+}
+
+// ClientCertificateFilter returns a Filter which requires requests to have been made over TLS with a verified client
+// certificate, and makes the client's identity available to the Service via ClientIdentity. Requests without one are
+// rejected with a 401 (Unauthorized) error.
+//
+// If verify is non-nil, it is called with the client's leaf certificate to decide whether the client may make the
+// request; if it returns an error, the request is rejected with a 403 (Forbidden) error (or with the error itself, if
+// it is a terror).
+func ClientCertificateFilter(verify func(req Request, cert *x509.Certificate) error) Filter {
+	return func(req Request, svc Service) Response {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+			return Response{
+				Error: terrors.Unauthorized("client_certificate", "A verified client certificate is required", nil)}
+		}
+		cert := req.TLS.VerifiedChains[0][0]
+		if verify != nil {
+			if err := verify(req, cert); err != nil {
+				if _, ok := err.(*terrors.Error); !ok {
+					err = terrors.Forbidden("client_certificate", err.Error(), map[string]string{
+						"common_name": cert.Subject.CommonName})
+				}
+				return Response{
+					Error: err}
+			}
+		}
+
+		id := PeerIdentity{
+			CommonName:     cert.Subject.CommonName,
+			DNSNames:       cert.DNSNames,
+			EmailAddresses: cert.EmailAddresses,
+			Certificate:    cert}
+		for _, u := range cert.URIs {
+			id.URIs = append(id.URIs, u.String())
+		}
+		req.Context = context.WithValue(req.Context, clientIdentityContextKey, id)
+		return svc(req)
+	}
+}
+
+// ClientIdentity returns the identity of the client which made the request, as verified by ClientCertificateFilter.
+// ok is false if the request didn't pass through that filter.
+func ClientIdentity(ctx context.Context) (id PeerIdentity, ok bool) {
+	id, ok = ctx.Value(clientIdentityContextKey).(PeerIdentity)
+	return id, ok
+}
@@ -0,0 +1,127 @@
+package typhon
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	t    *testing.T
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{
+		t:    t,
+		cert: cert,
+		key:  key,
+		pool: pool}
+}
+
+func (ca *testCA) issue(cn string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(ca.t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage}}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(ca.t, err)
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCA(t)
+	svc := Service(func(req Request) Response {
+		id, ok := ClientIdentity(req)
+		require.True(t, ok)
+		return req.Response(id.CommonName)
+	})
+	svc = svc.Filter(ClientCertificateFilter(func(req Request, cert *x509.Certificate) error {
+		if cert.Subject.CommonName == "intruder" {
+			return errors.New("Client not permitted")
+		}
+		return nil
+	})).Filter(ErrorFilter)
+	serverCert := ca.issue("server", x509.ExtKeyUsageServerAuth)
+	s, err := Listen(svc, "localhost:0", WithTLS(MutualTLSServerConfig(serverCert, ca.pool)))
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+	url := "https://" + s.Listener().Addr().String() + "/"
+
+	client := func(cn string) Service {
+		transport := MutualTLSTransport(ca.issue(cn, x509.ExtKeyUsageClientAuth), ca.pool)
+		t.Cleanup(transport.CloseIdleConnections)
+		return HttpService(transport).Filter(ErrorFilter)
+	}
+
+	rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(client("payments")).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "HTTP/2.0", rsp.Proto)
+	var cn string
+	require.NoError(t, rsp.Decode(&cn))
+	assert.Equal(t, "payments", cn)
+
+	rsp = NewRequest(context.Background(), "GET", url, nil).SendVia(client("intruder")).Response()
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, "forbidden.client_certificate"))
+
+	// Clients without a certificate can't connect at all
+	noCert := MutualTLSTransport(tls.Certificate{}, ca.pool)
+	noCert.TLSClientConfig.Certificates = nil
+	rsp = NewRequest(context.Background(), "GET", url, nil).SendVia(HttpService(noCert)).Response()
+	require.Error(t, rsp.Error)
+}
+
+func TestClientCertificateFilterWithoutTLS(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		return req.Response(nil)
+	}).Filter(ClientCertificateFilter(nil)).Filter(ErrorFilter)
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+}
@@ -0,0 +1,65 @@
+package typhon
+
+import (
+	"net/http"
+
+	"github.com/monzo/terrors"
+	terrorsproto "github.com/monzo/terrors/proto"
+)
+
+// A MultiStatusItem is the outcome of one item of a batch operation, as reported in a 207 Multi-Status response built
+// by NewMultiStatusResponse.
+type MultiStatusItem struct {
+	// Index is the position of the item in the batch (eg. as passed to a DecodeArrayPartial callback).
+	Index int `json:"index"`
+	// Status is the HTTP status code of the item's outcome.
+	Status int `json:"status"`
+	// Body is the item's result, if it succeeded.
+	Body interface{} `json:"body,omitempty"`
+	// Error describes why the item failed, in the same form as the body of an error response.
+	Error *terrorsproto.Error `json:"error,omitempty"`
+}
+
+// MultiStatusBody is the body of a 207 Multi-Status response.
+type MultiStatusBody struct {
+	Items []MultiStatusItem `json:"items"`
+}
+
+// MultiStatusSuccess returns a MultiStatusItem for a batch item which succeeded with the given status and result.
+func MultiStatusSuccess(index, status int, body interface{}) MultiStatusItem {
+	return MultiStatusItem{
+		Index:  index,
+		Status: status,
+		Body:   body}
+}
+
+// MultiStatusError returns a MultiStatusItem for a batch item which failed with the passed error. The item's status
+// is that which the error would have as a response (see ErrorStatusCode). Errors which aren't terrors are treated as
+// internal errors, except for an ArrayElementError (from DecodeArrayPartial), which is a bad request.
+func MultiStatusError(index int, err error) MultiStatusItem {
+	if ae, ok := err.(ArrayElementError); ok {
+		err = terrors.WrapWithCode(ae.Err, nil, terrors.ErrBadRequest)
+	}
+	terr := asTerror(err)
+	terrp := terrors.Marshal(terr)
+	if !IncludeErrorStacks {
+		terrp.Stack = nil
+	}
+	return MultiStatusItem{
+		Index:  index,
+		Status: ErrorStatusCode(terr),
+		Error:  terrp}
+}
+
+// NewMultiStatusResponse constructs a 207 (Multi-Status) response to a batch request, whose body lists the outcome
+// of each item. The body is a MultiStatusBody, encoded like any other (see Response.Encode). Items are reported in the
+// order given; handlers would usually sort them by Index.
+func NewMultiStatusResponse(req Request, items []MultiStatusItem) Response {
+	rsp := NewResponseWithCode(req, http.StatusMultiStatus)
+	if items == nil {
+		items = []MultiStatusItem{}
+	}
+	rsp.Encode(MultiStatusBody{
+		Items: items})
+	return rsp
+}
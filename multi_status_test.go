@@ -0,0 +1,68 @@
+package typhon
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiStatusResponse(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(nil, "POST", "/", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader(`[1, "two", 3]`))
+
+	var items []MultiStatusItem
+	errs, err := req.DecodeArrayPartial(func(i int, decode func(interface{}) error) error {
+		n := 0
+		if err := decode(&n); err != nil {
+			return nil
+		}
+		if n == 3 {
+			items = append(items, MultiStatusError(i, terrors.NotFound("item", "No such item", nil)))
+			return nil
+		}
+		items = append(items, MultiStatusSuccess(i, http.StatusCreated, map[string]int{"id": n}))
+		return nil
+	})
+	require.NoError(t, err)
+	for _, e := range errs {
+		items = append(items, MultiStatusError(e.Index, e))
+	}
+
+	rsp := NewMultiStatusResponse(req, items)
+	assert.Equal(t, http.StatusMultiStatus, rsp.StatusCode)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	body := MultiStatusBody{}
+	require.NoError(t, rsp.Decode(&body))
+	require.Len(t, body.Items, 3)
+
+	assert.Equal(t, 0, body.Items[0].Index)
+	assert.Equal(t, http.StatusCreated, body.Items[0].Status)
+	assert.Equal(t, map[string]interface{}{"id": float64(1)}, body.Items[0].Body)
+	assert.Nil(t, body.Items[0].Error)
+
+	assert.Equal(t, 2, body.Items[1].Index)
+	assert.Equal(t, http.StatusNotFound, body.Items[1].Status)
+	assert.Equal(t, "not_found.item", body.Items[1].Error.Code)
+	assert.Empty(t, body.Items[1].Error.Stack)
+
+	assert.Equal(t, 1, body.Items[2].Index)
+	assert.Equal(t, http.StatusBadRequest, body.Items[2].Status)
+	assert.Equal(t, terrors.ErrBadRequest, body.Items[2].Error.Code)
+
+	// A nil error doesn't panic
+	item := MultiStatusError(3, nil)
+	assert.Equal(t, http.StatusInternalServerError, item.Status)
+	assert.Equal(t, "internal_service.nil_error", item.Error.Code)
+
+	// An empty batch has an empty list of items, rather than null
+	rsp = NewMultiStatusResponse(req, nil)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"items": []}`, string(b))
+}
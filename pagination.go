@@ -0,0 +1,133 @@
+package typhon
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+const defaultPaginationLimit = 20
+
+// PaginationOpts configures how Request.Pagination parses pagination parameters.
+type PaginationOpts struct {
+	// DefaultLimit is the page size used when the request doesn't specify one. If zero, 20 is used (or MaxLimit, if it
+	// is smaller).
+	DefaultLimit int
+	// MaxLimit, if positive, caps the page size: larger limits are reduced to it.
+	MaxLimit int
+	// SortFields are the fields by which lists may be sorted. If empty, requests which specify a sort are rejected.
+	SortFields []string
+	// DefaultSort is the sort used when the request doesn't specify one, in the same form as the sort parameter.
+	DefaultSort string
+}
+
+// Pagination describes the page of a list requested by a client; see Request.Pagination.
+type Pagination struct {
+	// Limit is the maximum number of items to return.
+	Limit int
+	// Offset is the number of items to skip. It is zero if Cursor is set.
+	Offset int
+	// Cursor is the opaque position from which to continue listing, as previously returned to the client (eg. with
+	// SetPaginationLinks).
+	Cursor string
+	// Sort is the field by which to sort the list (if any), and Descending whether to sort it in descending order.
+	Sort       string
+	Descending bool
+}
+
+// Pagination parses and validates the pagination parameters of a list request from its query string:
+//
+//   - limit: the page size; a positive integer, capped at opts.MaxLimit
+//   - offset: the number of items to skip; a non-negative integer
+//   - cursor: an opaque position to continue from, which can't be combined with an offset
+//   - sort: one of opts.SortFields, optionally prefixed with "-" to sort in descending order
+//
+// Invalid parameters fail with a 400 (Bad Request) error whose "param" parameter names the culprit.
+func (r Request) Pagination(opts PaginationOpts) (Pagination, error) {
+	invalid := func(param, value, reason string) error {
+		return terrors.BadRequest("invalid_pagination", "Invalid "+param+": "+reason, map[string]string{
+			"param": param,
+			"value": value})
+	}
+	var q url.Values
+	if r.URL != nil {
+		q = r.URL.Query()
+	}
+
+	p := Pagination{
+		Limit: opts.DefaultLimit}
+	if p.Limit <= 0 {
+		p.Limit = defaultPaginationLimit
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return Pagination{}, invalid("limit", v, "must be a positive integer")
+		}
+		p.Limit = n
+	}
+	if opts.MaxLimit > 0 && p.Limit > opts.MaxLimit {
+		p.Limit = opts.MaxLimit
+	}
+
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return Pagination{}, invalid("offset", v, "must be a non-negative integer")
+		}
+		p.Offset = n
+	}
+	p.Cursor = q.Get("cursor")
+	if p.Cursor != "" && q.Get("offset") != "" {
+		return Pagination{}, invalid("cursor", p.Cursor, "can't be combined with an offset")
+	}
+
+	sort := opts.DefaultSort
+	if v := q.Get("sort"); v != "" {
+		sort = v
+		field := strings.TrimPrefix(v, "-")
+		allowed := false
+		for _, f := range opts.SortFields {
+			allowed = allowed || f == field
+		}
+		if !allowed {
+			return Pagination{}, invalid("sort", v, "must be one of "+strings.Join(opts.SortFields, ", "))
+		}
+	}
+	p.Sort = strings.TrimPrefix(sort, "-")
+	p.Descending = strings.HasPrefix(sort, "-")
+	return p, nil
+}
+
+// SetPaginationLinks sets RFC 5988 Link headers pointing to the next and previous pages of a paginated list. Each link
+// is built from u (normally the request's URL) by setting the param query parameter to the respective cursor (or page
+// number); all other query parameters are preserved. Links with an empty cursor are omitted.
+func (r *Response) SetPaginationLinks(u *url.URL, param, next, prev string) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	link := func(cursor, rel string) {
+		if cursor == "" {
+			return
+		}
+		l := *u
+		q := l.Query()
+		q.Set(param, cursor)
+		l.RawQuery = q.Encode()
+		r.Header.Add("Link", "<"+l.String()+`>; rel="`+rel+`"`)
+	}
+	link(next, "next")
+	link(prev, "prev")
+}
+
+// SetTotalCount sets the X-Total-Count header, which conventionally carries the total number of items in a paginated
+// list.
+func (r *Response) SetTotalCount(n int64) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	r.Header.Set("X-Total-Count", strconv.FormatInt(n, 10))
+}
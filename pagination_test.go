@@ -0,0 +1,73 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseSetPaginationLinks(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET", "/items?filter=a%20b&cursor=c1&limit=10", nil)
+	rsp := req.Response(nil)
+	rsp.SetPaginationLinks(req.URL, "cursor", "c2", "c0")
+	rsp.SetTotalCount(42)
+	assert.Equal(t, []string{
+		`</items?cursor=c2&filter=a+b&limit=10>; rel="next"`,
+		`</items?cursor=c0&filter=a+b&limit=10>; rel="prev"`}, rsp.Header["Link"])
+	assert.Equal(t, "42", rsp.Header.Get("X-Total-Count"))
+	// The request's URL is untouched
+	assert.Equal(t, "c1", req.URL.Query().Get("cursor"))
+
+	// Missing cursors are omitted
+	rsp = Response{}
+	rsp.SetPaginationLinks(req.URL, "page", "2", "")
+	assert.Equal(t, []string{`</items?cursor=c1&filter=a+b&limit=10&page=2>; rel="next"`}, rsp.Header["Link"])
+}
+
+func TestRequestPagination(t *testing.T) {
+	t.Parallel()
+	opts := PaginationOpts{
+		MaxLimit:    100,
+		SortFields:  []string{"created", "name"},
+		DefaultSort: "-created"}
+	pagination := func(query string) (Pagination, error) {
+		return NewRequest(context.Background(), "GET", "/items?"+query, nil).Pagination(opts)
+	}
+
+	p, err := pagination("")
+	require.NoError(t, err)
+	assert.Equal(t, Pagination{
+		Limit:      20,
+		Sort:       "created",
+		Descending: true}, p)
+
+	p, err = pagination("limit=500&offset=40&sort=name")
+	require.NoError(t, err)
+	assert.Equal(t, Pagination{
+		Limit:  100,
+		Offset: 40,
+		Sort:   "name"}, p)
+
+	p, err = pagination("limit=5&cursor=abc")
+	require.NoError(t, err)
+	assert.Equal(t, 5, p.Limit)
+	assert.Equal(t, "abc", p.Cursor)
+
+	for query, param := range map[string]string{
+		"limit=0":           "limit",
+		"limit=ten":         "limit",
+		"offset=-1":         "offset",
+		"offset=1&cursor=a": "cursor",
+		"sort=password":     "sort"} {
+		_, err := pagination(query)
+		require.Error(t, err, query)
+		assert.Equal(t, http.StatusBadRequest, ErrorStatusCode(err), query)
+		assert.Equal(t, param, err.(*terrors.Error).Params["param"], query)
+	}
+}
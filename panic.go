@@ -0,0 +1,29 @@
+package typhon
+
+import (
+	"fmt"
+
+	"github.com/monzo/terrors"
+)
+
+// PanicError converts a value recovered from a panic, whatever its type, into an internal_service.panic terror, so
+// that panics produce uniform error responses. The message is taken from the value (its Error or String method, if it
+// has one) and the value's type is recorded in the "panic_type" param. It should be called from the deferred function
+// which recovered the value, so the error's stack includes the site of the panic.
+func PanicError(v interface{}) error {
+	var msg string
+	switch v := v.(type) {
+	case *terrors.Error:
+		msg = v.Message
+	case error:
+		msg = v.Error()
+	case fmt.Stringer:
+		msg = v.String()
+	case string:
+		msg = v
+	default:
+		msg = fmt.Sprintf("%v", v)
+	}
+	return terrors.InternalService("panic", "Panic: "+msg, map[string]string{
+		"panic_type": fmt.Sprintf("%T", v)})
+}
@@ -0,0 +1,46 @@
+package typhon
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+)
+
+type panicStringer struct{}
+
+func (panicStringer) String() string {
+	return "stringer"
+}
+
+func TestPanicError(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		value   interface{}
+		message string
+		typ     string
+	}{
+		{"boom", "Panic: boom", "string"},
+		{errors.New("bad"), "Panic: bad", "*errors.errorString"},
+		{terrors.NotFound("thing", "No thing", nil), "Panic: No thing", "*terrors.Error"},
+		{panicStringer{}, "Panic: stringer", "typhon.panicStringer"},
+		{42, "Panic: 42", "int"}}
+	for _, c := range cases {
+		err := PanicError(c.value)
+		terr := err.(*terrors.Error)
+		assert.Equal(t, "internal_service.panic", terr.Code)
+		assert.Equal(t, c.message, terr.Message)
+		assert.Equal(t, c.typ, terr.Params["panic_type"])
+	}
+
+	func() {
+		defer func() {
+			err := PanicError(recover())
+			assert.True(t, terrors.Is(err, "internal_service.panic"))
+			assert.Contains(t, err.(*terrors.Error).StackString(), "panic_test.go")
+		}()
+		panic("from a test")
+	}()
+}
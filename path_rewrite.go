@@ -0,0 +1,52 @@
+package typhon
+
+import (
+	"context"
+	"regexp"
+)
+
+// A PathRewrite rewrites request paths matching Pattern, by replacing the match with Replacement. Replacement may
+// refer to submatches as in regexp.Regexp.ReplaceAllString (eg. "/users/$1").
+type PathRewrite struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+type originalPathContextKeyType struct{}
+
+var originalPathContextKey = originalPathContextKeyType{}
+
+// OriginalPath returns the path of a request before it was rewritten by PathRewriteFilter, if it was.
+func OriginalPath(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	path, ok := ctx.Value(originalPathContextKey).(string)
+	return path, ok
+}
+
+// PathRewriteFilter returns a Filter which rewrites the paths of requests using the first of rewrites whose pattern
+// matches, eg. to strip a version prefix or to map legacy paths onto current ones. Applied around a Router, it
+// rewrites paths before they are routed. The original path is recorded in the request's context (see OriginalPath)
+// so that it can still be logged.
+//
+// Patterns are usually anchored (eg. `^/v1(/.*)$`), as otherwise a match anywhere in the path is replaced.
+func PathRewriteFilter(rewrites ...PathRewrite) Filter {
+	return func(req Request, svc Service) Response {
+		if req.URL == nil {
+			return svc(req)
+		}
+		for _, rw := range rewrites {
+			if !rw.Pattern.MatchString(req.URL.Path) {
+				continue
+			}
+			u := *req.URL
+			u.Path = rw.Pattern.ReplaceAllString(u.Path, rw.Replacement)
+			u.RawPath = ""
+			req.Context = context.WithValue(req.Context, originalPathContextKey, req.URL.Path)
+			req.URL = &u
+			break
+		}
+		return svc(req)
+	}
+}
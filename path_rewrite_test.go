@@ -0,0 +1,43 @@
+package typhon
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathRewriteFilter(t *testing.T) {
+	t.Parallel()
+	router := Router{}
+	router.GET("/foo", func(req Request) Response {
+		original, _ := OriginalPath(req.Context)
+		return req.Response(original)
+	})
+	router.GET("/users/:id", func(req Request) Response {
+		return req.Response(router.Params(req)["id"])
+	})
+	svc := router.Serve().Filter(PathRewriteFilter(
+		PathRewrite{regexp.MustCompile(`^/v[12](/.*)$`), "$1"},
+		PathRewrite{regexp.MustCompile(`^/legacy/user\.php$`), "/users/legacy"},
+		PathRewrite{regexp.MustCompile(`^/v1/.*$`), "/never"}))
+
+	body := func(path string) string {
+		rsp := svc(NewRequest(context.Background(), "GET", path, nil))
+		require.NoError(t, rsp.Error)
+		var s string
+		require.NoError(t, rsp.Decode(&s))
+		return s
+	}
+	assert.Equal(t, "/v1/foo", body("/v1/foo"))
+	assert.Equal(t, "", body("/foo"))
+	assert.Equal(t, "legacy", body("/legacy/user.php"))
+	assert.Equal(t, "123", body("/v2/users/123"))
+
+	req := NewRequest(context.Background(), "GET", "/v1/bar", nil)
+	rsp := svc(req)
+	assert.Error(t, rsp.Error)
+	assert.Equal(t, "/v1/bar", req.URL.Path)
+}
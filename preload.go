@@ -0,0 +1,30 @@
+package typhon
+
+import (
+	"net/http"
+)
+
+// Preload adds a Link header hinting that the client should preload target (a path or URL), which it will need to
+// render the response: eg. Preload("/style.css", "style", false) adds `Link: </style.css>; rel=preload; as=style`. as
+// is the type of the resource, and is omitted if empty.
+//
+// If push is true and the request arrived over HTTP/2, the resource is also pushed to the client (target must then be
+// a path on the same host). Pushing is best-effort: it is silently skipped if the connection doesn't support it (eg.
+// HTTP/1.x, or the client has disabled push), leaving just the hint. Preload must be called before the Service returns
+// the response.
+func (r *Response) Preload(target, as string, push bool) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	link := "<" + target + ">; rel=preload"
+	if as != "" {
+		link += "; as=" + as
+	}
+	r.Header.Add("Link", link)
+
+	if push {
+		if p, ok := r.Writer().(http.Pusher); ok {
+			p.Push(target, nil) // errors just mean the client won't receive the push
+		}
+	}
+}
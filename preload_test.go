@@ -0,0 +1,36 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingPusher struct {
+	targets []string
+}
+
+func (p *recordingPusher) Push(target string, opts *http.PushOptions) error {
+	p.targets = append(p.targets, target)
+	return nil
+}
+
+func TestResponsePreload(t *testing.T) {
+	t.Parallel()
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	rsp := NewResponse(req)
+	rsp.Preload("/style.css", "style", true)
+	rsp.Preload("/data.json", "", false)
+	assert.Equal(t, []string{"</style.css>; rel=preload; as=style", "</data.json>; rel=preload"}, rsp.Header["Link"])
+
+	// Over HTTP/2, resources are pushed too
+	p := &recordingPusher{}
+	req.pusher = p
+	rsp = NewResponse(req)
+	rsp.Preload("/style.css", "style", true)
+	rsp.Preload("/script.js", "script", false)
+	assert.Equal(t, []string{"/style.css"}, p.targets)
+	assert.Len(t, rsp.Header["Link"], 2)
+}
@@ -0,0 +1,38 @@
+package typhon
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// PrettyJSONHeader is the request header which asks PrettyJSONFilter to indent a JSON response.
+const PrettyJSONHeader = "X-Debug-Pretty"
+
+// PrettyJSONFilter is a Filter which indents JSON responses to requests which set PrettyJSONHeader to a true value
+// (as understood by strconv.ParseBool), so they are easier to read when debugging by hand.
+//
+// Only buffered responses with a JSON Content-Type and no Content-Encoding are re-encoded; streamed, compressed and
+// binary responses (and any whose body isn't valid JSON) are passed through untouched.
+func PrettyJSONFilter(req Request, svc Service) Response {
+	rsp := svc(req)
+	if pretty, _ := strconv.ParseBool(req.Header.Get(PrettyJSONHeader)); !pretty || rsp.Response == nil {
+		return rsp
+	}
+	buf, ok := rsp.Body.(*bufCloser)
+	if !ok || rsp.Header.Get("Content-Encoding") != "" || !isJSONMediaType(rsp.Header.Get("Content-Type")) {
+		return rsp
+	}
+	out := &bufCloser{}
+	if err := json.Indent(&out.Buffer, bytes.TrimSpace(buf.Bytes()), "", "  "); err != nil {
+		return rsp
+	}
+	out.WriteByte('\n')
+	rsp.Body = out
+	rsp.ContentLength = int64(out.Len())
+	if rsp.ContentLength >= chunkThreshold {
+		rsp.ContentLength = -1
+	}
+	rsp.Header.Del("Content-Length")
+	return rsp
+}
@@ -0,0 +1,57 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrettyJSONFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		switch req.URL.Path {
+		case "/stream":
+			s := Streamer()
+			go func() {
+				s.Write([]byte(`{"a":1}`))
+				s.Close()
+			}()
+			rsp := req.Response(s)
+			rsp.Header.Set("Content-Type", "application/json")
+			return rsp
+		case "/text":
+			rsp := req.Response(nil)
+			rsp.Header.Set("Content-Type", "text/plain")
+			rsp.Write([]byte(`{"a":1}`))
+			return rsp
+		}
+		return req.Response(map[string]interface{}{
+			"a": 1,
+			"b": []string{"c"}})
+	}).Filter(PrettyJSONFilter)
+
+	body := func(path string, pretty string) string {
+		req := NewRequest(context.Background(), "GET", path, nil)
+		if pretty != "" {
+			req.Header.Set(PrettyJSONHeader, pretty)
+		}
+		rsp := svc(req)
+		require.NoError(t, rsp.Error)
+		b, err := ioutil.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		if rsp.ContentLength >= 0 {
+			assert.EqualValues(t, len(b), rsp.ContentLength)
+		}
+		return string(b)
+	}
+
+	assert.Equal(t, "{\n  \"a\": 1,\n  \"b\": [\n    \"c\"\n  ]\n}\n", body("/", "true"))
+	assert.Equal(t, `{"a":1,"b":["c"]}`+"\n", body("/", ""))
+	assert.Equal(t, `{"a":1,"b":["c"]}`+"\n", body("/", "false"))
+	// Streamed and non-JSON responses are untouched
+	assert.Equal(t, `{"a":1}`, body("/stream", "1"))
+	assert.Equal(t, `{"a":1}`, body("/text", "1"))
+}
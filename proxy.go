@@ -0,0 +1,100 @@
+package typhon
+
+import (
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// hopHeaders are the hop-by-hop headers (RFC 7230 §6.1) which are meaningful only for a single connection, so must not
+// be forwarded by proxies.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade"}
+
+// removeHopHeaders deletes hop-by-hop headers from h, including any nominated by its Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, k := range strings.Split(v, ",") {
+			if k = textproto.TrimString(k); k != "" {
+				h.Del(k)
+			}
+		}
+	}
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
+
+// Proxy returns a new Request, with the same context, which forwards r to the passed target. The target's scheme and
+// host replace those of r's URL, and its path (if any) is prefixed to r's path. Headers are cloned (minus hop-by-hop
+// headers) and the body is copied, so r's body remains readable afterwards.
+//
+// This is a building block for services which forward requests to a downstream with minor modifications.
+func (r *Request) Proxy(target *url.URL) Request {
+	u := *r.URL
+	u.Scheme = target.Scheme
+	u.Host = target.Host
+	if target.Path != "" {
+		u.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(u.Path, "/")
+		u.RawPath = ""
+	}
+
+	out := NewRequest(r.Context, r.Method, u.String(), nil)
+	if out.err != nil {
+		return out
+	}
+	out.Header = cloneHeader(r.Header)
+	removeHopHeaders(out.Header)
+	if r.Body != nil {
+		b, err := r.BodyBytes(false)
+		if err != nil {
+			out.err = err
+			return out
+		}
+		out.Write(b)
+	}
+	return out
+}
+
+// ProxyService returns a Service which forwards requests to target (as described by Request.Proxy) via client, and
+// relays the downstream response verbatim, minus hop-by-hop headers. The downstream body is never buffered: a
+// response of unknown length (eg. one with chunked encoding) keeps a ContentLength of -1, so a Server streams it to
+// the client as it arrives, flushing as it goes, and closes the downstream body once it is done. This makes it
+// suitable for large or long-lived streamed responses. Trailers are relayed too.
+//
+// Downstream error responses are relayed like any other; only if no response is received at all (eg. the downstream
+// can't be reached) does the returned response have an Error. client would usually be BareClient, or another client
+// without ErrorFilter, since decoding errors is unnecessary here.
+func ProxyService(target *url.URL, client Service) Service {
+	return func(req Request) Response {
+		dsRsp := client(req.Proxy(target))
+		if dsRsp.Response == nil {
+			return Response{
+				Request: &req,
+				Error:   dsRsp.Error}
+		}
+
+		rsp := NewResponseWithCode(req, dsRsp.StatusCode)
+		rsp.Header = cloneHeader(dsRsp.Header)
+		removeHopHeaders(rsp.Header)
+		rsp.Body = dsRsp.Body
+		rsp.ContentLength = dsRsp.ContentLength
+		if len(dsRsp.Trailer) > 0 {
+			// The trailers' values are only known once the body has been read; the map is filled in as that happens
+			rsp.Trailer = dsRsp.Trailer
+			for k := range dsRsp.Trailer {
+				rsp.Header.Add("Trailer", k)
+			}
+		}
+		return rsp
+	}
+}
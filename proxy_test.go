@@ -0,0 +1,110 @@
+package typhon
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestProxy(t *testing.T) {
+	t.Parallel()
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "v")
+	req := NewRequest(ctx, "POST", "http://inbound.local/foo/bar?a=b", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader("body"))
+	req.Header.Set("X-Custom", "1")
+	req.Header.Set("Connection", "close, X-Conn-Specific")
+	req.Header.Set("X-Conn-Specific", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+
+	target, _ := url.Parse("https://downstream.local:8443/prefix/")
+	out := req.Proxy(target)
+	require.NoError(t, out.err)
+	assert.Equal(t, "POST", out.Method)
+	assert.Equal(t, "https://downstream.local:8443/prefix/foo/bar?a=b", out.URL.String())
+	assert.Equal(t, "downstream.local:8443", out.Host)
+	assert.Equal(t, "v", out.Context.Value(ctxKey{}))
+
+	assert.Equal(t, "1", out.Header.Get("X-Custom"))
+	assert.Empty(t, out.Header.Get("Connection"))
+	assert.Empty(t, out.Header.Get("X-Conn-Specific"))
+	assert.Empty(t, out.Header.Get("Keep-Alive"))
+	// The original's headers are untouched
+	assert.Equal(t, "timeout=5", req.Header.Get("Keep-Alive"))
+
+	// Both bodies are readable
+	b, err := out.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(b))
+	assert.EqualValues(t, 4, out.ContentLength)
+	b, err = req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "body", string(b))
+}
+
+func TestProxyServiceStreams(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// The downstream streams two chunks, and won't send the second until the first has reached the client through the
+	// proxy; if the proxy buffered the response, this would deadlock
+	firstReceived := make(chan struct{})
+	downstream := Service(func(req Request) Response {
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			s.Write([]byte("first,"))
+			select {
+			case <-firstReceived:
+			case <-time.After(5 * time.Second):
+				return
+			}
+			s.Write([]byte("second"))
+		}()
+		rsp := req.Response(s)
+		rsp.Header.Set("X-Downstream", "1")
+		rsp.Header.Set("Keep-Alive", "timeout=5")
+		return rsp
+	})
+	ds, err := Listen(downstream, "localhost:0")
+	require.NoError(t, err)
+	defer ds.Stop(ctx)
+
+	target, _ := url.Parse(fmt.Sprintf("http://%s", ds.Listener().Addr()))
+	ps, err := Listen(ProxyService(target, BareClient), "localhost:0")
+	require.NoError(t, err)
+	defer ps.Stop(ctx)
+
+	rsp := NewRequest(ctx, "GET", fmt.Sprintf("http://%s/stream", ps.Listener().Addr()), nil).
+		SendVia(BareClient).Response()
+	require.NoError(t, rsp.Error)
+	defer rsp.Body.Close()
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.EqualValues(t, -1, rsp.ContentLength)
+	assert.Equal(t, "1", rsp.Header.Get("X-Downstream"))
+	assert.Empty(t, rsp.Header.Get("Keep-Alive"))
+
+	buf := make([]byte, len("first,"))
+	_, err = io.ReadFull(rsp.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "first,", string(buf))
+	close(firstReceived)
+	rest, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(rest))
+
+	// Downstream errors are relayed as-is, but an unreachable downstream is an error
+	target, _ = url.Parse("http://localhost:1")
+	rsp = ProxyService(target, BareClient)(NewRequest(ctx, "GET", "/", nil))
+	assert.Error(t, rsp.Error)
+}
@@ -0,0 +1,138 @@
+package typhon
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// encodeReadSeekCloser uses a seekable body to answer the request's Range header, if it has one. A single satisfiable
+// byte range is served as a 206 (Partial Content) response containing just that range, which is read straight from
+// the seeker; an unsatisfiable range fails with ErrRangeNotSatisfiable. Otherwise (and for requests which aren't
+// GETs, or responses other than 200 OK) the whole body is sent, with its Content-Length. A body which turns out not to
+// be seekable after all (eg. an *os.File for a pipe) is streamed instead.
+//
+// Multi-range requests are answered with the whole body, which RFC 7233 permits. So are requests with an If-Range
+// header which doesn't match the response's ETag (compared strongly) or Last-Modified header, so those must be set
+// before the body is encoded.
+func (r *Response) encodeReadSeekCloser(rs io.ReadSeekCloser) {
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		r.Body = rs
+		r.ContentLength = -1
+		return
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		rs.Close()
+		r.Error = terrors.Wrap(err, nil)
+		return
+	}
+	r.Body = rs
+	r.ContentLength = size
+	if r.Request == nil || r.Request.Method != http.MethodGet || r.StatusCode != http.StatusOK {
+		return
+	}
+	r.Header.Set("Accept-Ranges", "bytes")
+	h := r.Request.Header.Get("Range")
+	if h == "" {
+		return
+	}
+	if ifRange := r.Request.Header.Get("If-Range"); ifRange != "" && !r.ifRangeMatches(ifRange) {
+		// The client's copy is stale, so it needs the whole body
+		return
+	}
+
+	start, length, ok := parseByteRange(h, size)
+	switch {
+	case !ok:
+		// The header couldn't be understood, so it is ignored
+		return
+	case length <= 0:
+		rs.Close()
+		r.Body = &bufCloser{}
+		r.ContentLength = 0
+		r.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		r.Error = terrors.New(ErrRangeNotSatisfiable, "Requested range not satisfiable", map[string]string{
+			"range": h})
+		return
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		rs.Close()
+		r.Error = terrors.Wrap(err, nil)
+		return
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.LimitReader(rs, length),
+		Closer: rs}
+	r.ContentLength = length
+	r.StatusCode = http.StatusPartialContent
+	r.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+}
+
+// ifRangeMatches returns whether the value of an If-Range header matches the response's validators: an entity tag must
+// match the ETag exactly (weak tags never match), and a date must equal the Last-Modified time.
+func (r *Response) ifRangeMatches(ifRange string) bool {
+	if strings.HasPrefix(ifRange, "\"") || strings.HasPrefix(ifRange, "W/") {
+		etag := r.Header.Get("ETag")
+		return etag != "" && !strings.HasPrefix(ifRange, "W/") && !strings.HasPrefix(etag, "W/") && ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(r.Header.Get("Last-Modified"))
+	return err == nil && t.Equal(lastModified)
+}
+
+// parseByteRange parses a Range header requesting a single byte range of a body of the given size, returning the
+// offset and length of the range. ok is false if the header is malformed or requests multiple ranges. A length of zero
+// indicates that the range is not satisfiable.
+func parseByteRange(h string, size int64) (start, length int64, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(h, prefix) || strings.Contains(h, ",") {
+		return 0, 0, false
+	}
+	spec := strings.TrimSpace(h[len(prefix):])
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, false
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	if first == "" {
+		// A suffix range: the final n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return start, 0, true
+	}
+	return start, end - start + 1, true
+}
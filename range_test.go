@@ -0,0 +1,158 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseByteRange(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		header        string
+		start, length int64
+		ok            bool
+	}{
+		{"bytes=0-4", 0, 5, true},
+		{"bytes=6-", 6, 5, true},
+		{"bytes=-5", 6, 5, true},
+		{"bytes=-50", 0, 11, true},
+		{"bytes=6-100", 6, 5, true},
+		{"bytes=11-", 11, 0, true},
+		{"bytes=0-1,3-4", 0, 0, false},
+		{"bytes=4-2", 0, 0, false},
+		{"bytes=x-", 0, 0, false},
+		{"items=0-4", 0, 0, false}}
+	for _, c := range cases {
+		start, length, ok := parseByteRange(c.header, 11)
+		assert.Equal(t, c.ok, ok, c.header)
+		if c.ok {
+			assert.Equal(t, c.start, start, c.header)
+			assert.Equal(t, c.length, length, c.header)
+		}
+	}
+}
+
+func TestResponseEncodeReadSeekCloser(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello world"), 0644))
+	svc := Service(func(req Request) Response {
+		f, err := os.Open(path)
+		if err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(f)
+	}).Filter(ErrorFilter)
+	get := func(rng string) Response {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		return svc(req)
+	}
+
+	rsp := get("")
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.EqualValues(t, 11, rsp.ContentLength)
+	assert.Equal(t, "bytes", rsp.Header.Get("Accept-Ranges"))
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "hello world", string(b))
+
+	rsp = get("bytes=6-")
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	assert.EqualValues(t, 5, rsp.ContentLength)
+	assert.Equal(t, "bytes 6-10/11", rsp.Header.Get("Content-Range"))
+	b, _ = rsp.BodyBytes(true)
+	assert.Equal(t, "world", string(b))
+
+	rsp = get("bytes=0-1,3-4")
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	rsp = get("bytes=20-")
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, ErrRangeNotSatisfiable))
+	assert.Equal(t, "bytes */11", rsp.Header.Get("Content-Range"))
+
+	// Partial content isn't compressed
+	rsp = svc.Filter(CompressionFilter(CompressionOptions{
+		ContentTypes: []string{"*/*"}}))(func() Request {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		req.Header.Set("Range", "bytes=6-")
+		req.Header.Set("Accept-Encoding", "gzip")
+		return req
+	}())
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusPartialContent, rsp.StatusCode)
+	assert.Empty(t, rsp.Header.Get("Content-Encoding"))
+}
+
+func TestResponseEncodeReadSeekCloserIfRange(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, ioutil.WriteFile(path, []byte("hello world"), 0644))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	svc := Service(func(req Request) Response {
+		f, err := os.Open(path)
+		if err != nil {
+			return Response{Error: err}
+		}
+		rsp := NewResponse(req)
+		rsp.Header.Set("ETag", `"v1"`)
+		rsp.Header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+		rsp.Encode(f)
+		return rsp
+	}).Filter(ErrorFilter)
+
+	cases := map[string]int{
+		`"v1"`:                          http.StatusPartialContent,
+		`"v2"`:                          http.StatusOK,
+		`W/"v1"`:                        http.StatusOK,
+		modTime.Format(http.TimeFormat): http.StatusPartialContent,
+		modTime.Add(time.Hour).Format(http.TimeFormat): http.StatusOK,
+		"nonsense": http.StatusOK}
+	for ifRange, status := range cases {
+		req := NewRequest(context.Background(), "GET", "/", nil)
+		req.Header.Set("Range", "bytes=6-")
+		req.Header.Set("If-Range", ifRange)
+		rsp := svc(req)
+		require.NoError(t, rsp.Error, ifRange)
+		assert.Equal(t, status, rsp.StatusCode, ifRange)
+		rsp.Body.Close()
+	}
+}
+
+func TestResponseEncodeUnseekableFile(t *testing.T) {
+	t.Parallel()
+
+	pr, pw, err := os.Pipe()
+	require.NoError(t, err)
+	go func() {
+		pw.Write([]byte("streamed"))
+		pw.Close()
+	}()
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Range", "bytes=2-")
+	rsp := req.Response(pr)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.EqualValues(t, -1, rsp.ContentLength)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(b))
+}
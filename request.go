@@ -6,8 +6,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/monzo/terrors"
 	"google.golang.org/protobuf/encoding/protojson"
@@ -23,6 +27,7 @@ type Request struct {
 	context.Context
 	err      error // Any error from request construction; read by ErrorFilter
 	hijacker http.Hijacker
+	pusher   http.Pusher
 	server   *Server
 }
 
@@ -42,9 +47,82 @@ func (r *Request) unwrappedContext() context.Context {
 	}
 }
 
-// Encode serialises the passed object as JSON into the body (and sets appropriate headers).
+// Deadline returns the time at which the request's context will be cancelled, if it has one. This reflects any deadline
+// applied by filters upstream of the caller.
+func (r Request) Deadline() (time.Time, bool) {
+	if r.Context == nil {
+		return time.Time{}, false
+	}
+	return r.Context.Deadline()
+}
+
+// WithTimeout returns a copy of the request whose context is cancelled after the passed duration, or when the original
+// context is cancelled (whichever happens first). The returned CancelFunc must be called once the request is finished
+// with, to release the resources associated with the timer.
+func (r Request) WithTimeout(d time.Duration) (Request, context.CancelFunc) {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return r.WithContext(ctx), cancel
+}
+
+// WithContext returns a shallow copy of the request with its context changed to ctx, which must be non-nil. It shadows
+// the method of the embedded http.Request, and unlike setting the Context field directly, it also updates the context
+// of the embedded http.Request so the two can't diverge (as they might if the http.Request is later passed to code
+// which calls its Context method).
+func (r Request) WithContext(ctx context.Context) Request {
+	r.Context = ctx
+	r.Request = *r.Request.WithContext(ctx)
+	return r
+}
+
+// WithHeader returns a shallow copy of the request with the header k set to v. The copy's headers are cloned so the
+// original request is left untouched.
+func (r Request) WithHeader(k, v string) Request {
+	r.Header = cloneHeader(r.Header)
+	r.Header.Set(k, v)
+	return r
+}
+
+// BasicAuthCredentials returns the username and password from the request's Authorization header, if it uses HTTP
+// Basic authentication. ok is false if it doesn't, or if the credentials are malformed.
+func (r Request) BasicAuthCredentials() (username, password string, ok bool) {
+	return r.Request.BasicAuth()
+}
+
+// WithBasicAuth returns a shallow copy of the request with its Authorization header set to use HTTP Basic
+// authentication with the passed credentials (which are base64-encoded, but not encrypted). The copy's headers are
+// cloned so the original request is left untouched.
+func (r Request) WithBasicAuth(username, password string) Request {
+	r.Header = cloneHeader(r.Header)
+	r.Request.SetBasicAuth(username, password)
+	return r
+}
+
+// WithHeaders returns a shallow copy of the request with each of the passed headers set, replacing any existing values
+// for those keys. The copy's headers are cloned so the original request is left untouched.
+func (r Request) WithHeaders(h http.Header) Request {
+	r.Header = cloneHeader(r.Header)
+	for k, vs := range h {
+		r.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+	}
+	return r
+}
+
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return make(http.Header)
+	}
+	return h.Clone()
+}
+
+// Encode serialises the passed object as JSON into the body (and sets appropriate headers). Readers are sent as-is,
+// and values implementing io.WriterTo are streamed (see Response.Encode).
 func (r *Request) Encode(v interface{}) {
-	// If we were given an io.ReadCloser or an io.Reader (that is not also a json.Marshaler), use it directly
+	// If we were given an io.ReadCloser, an io.Reader or an io.WriterTo (that is not also a json.Marshaler), use it
+	// directly
 	switch v := v.(type) {
 	case json.Marshaler:
 	case io.ReadCloser:
@@ -55,6 +133,10 @@ func (r *Request) Encode(v interface{}) {
 		r.Body = ioutil.NopCloser(v)
 		r.ContentLength = -1
 		return
+	case io.WriterTo:
+		r.Body = writerToBody(v)
+		r.ContentLength = bodyLength(v)
+		return
 	}
 
 	if err := json.NewEncoder(r).Encode(v); err != nil {
@@ -81,18 +163,54 @@ func (r *Request) EncodeAsProtobuf(m proto.Message) {
 	r.ContentLength = int64(n)
 }
 
-// Decode de-serialises the body into the passed object.
+// SetBody replaces the request's body with the passed bytes, and updates its Content-Length to match. This is useful
+// for filters which rewrite request bodies. The previous body isn't closed.
+func (r *Request) SetBody(b []byte) {
+	buf := &bufCloser{}
+	buf.Write(b)
+	r.SetBodyReader(buf, int64(len(b)))
+}
+
+// SetBodyReader replaces the request's body with the passed reader, which is closed once the body has been sent if it
+// is an io.ReadCloser. length is the number of bytes it will produce, or -1 if that is unknown (in which case the body
+// is sent chunked). The request's Content-Length (and any Content-Length header, eg. on a received request) is
+// updated to match. The previous body isn't closed, as the new one may wrap it (eg. to decompress it).
+func (r *Request) SetBodyReader(rdr io.Reader, length int64) {
+	rc, ok := rdr.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(rdr)
+	}
+	r.Body = rc
+	if length < 0 {
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+		return
+	}
+	r.ContentLength = length
+	if r.Header.Get("Content-Length") != "" {
+		r.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+}
+
+// Decode de-serialises the body into the passed object. The codec is selected by the Content-Type header's media
+// type; a charset other than UTF-8 is rejected with an ErrUnsupportedMediaType error.
 func (r Request) Decode(v interface{}) error {
+	mediaType, charset := parseContentType(r.Header.Get("Content-Type"))
+	if charset != "" {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return terrors.New(ErrUnsupportedMediaType, "Unsupported charset", map[string]string{
+			"charset": charset})
+	}
+
 	b, err := r.BodyBytes(true)
 	if err != nil {
 		return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
 	}
 
-	switch r.Header.Get("Content-Type") {
-	// application/x-protobuf is the "canonical" use, application/protobuf is defined in an expired IETF draft.
-	// See: https://datatracker.ietf.org/doc/html/draft-rfernando-protocol-buffers-00#section-3.2
-	// See: https://github.com/google/protorpc/blob/eb03145/python/protorpc/protobuf.py#L49-L51
-	case "application/octet-stream", "application/x-google-protobuf", "application/protobuf", "application/x-protobuf":
+	switch {
+	case protobufMediaTypes[mediaType]:
 		m, ok := v.(proto.Message)
 		if !ok {
 			return terrors.InternalService("invalid_type", "could not decode proto message", nil)
@@ -112,6 +230,103 @@ func (r Request) Decode(v interface{}) error {
 	return terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
 }
 
+// An ArrayElementError is the error decoding one element of a JSON array, as reported by DecodeArrayPartial.
+type ArrayElementError struct {
+	// Index is the element's position in the array.
+	Index int
+	Err   error
+}
+
+func (e ArrayElementError) Error() string {
+	return fmt.Sprintf("element %d: %v", e.Index, e.Err)
+}
+
+// DecodeArrayPartial de-serialises a body containing a JSON array one element at a time, as it is read, collecting
+// errors from individual elements rather than failing the whole request; this suits bulk endpoints which report the
+// outcome of each item (eg. with a 207 Multi-Status response).
+//
+// fn is called once per element with the element's index and a function which decodes it into the passed object. If
+// the element can't be decoded into the object, decode returns the error, which is also recorded; fn would usually
+// then move on to the next element by returning nil. If fn returns an error, decoding stops and the error is returned.
+// Elements which fn doesn't decode are skipped.
+//
+// The recorded element errors are returned in order. Only a body which isn't a well-formed JSON array causes an error
+// (with code bad_request) to be returned, in which case the element errors up to that point are still returned.
+func (r Request) DecodeArrayPartial(fn func(i int, decode func(v interface{}) error) error) ([]ArrayElementError,
+	error) {
+	if _, charset := parseContentType(r.Header.Get("Content-Type")); charset != "" {
+		return nil, terrors.New(ErrUnsupportedMediaType, "Unsupported charset", map[string]string{
+			"charset": charset})
+	}
+	if r.Body == nil {
+		return nil, terrors.BadRequest("missing_body", "Request has no body", nil)
+	}
+	defer r.Body.Close()
+
+	dec := json.NewDecoder(r.Body)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, terrors.BadRequest("not_array", "Request body is not a JSON array", nil)
+	}
+
+	var errs []ArrayElementError
+	for i := 0; dec.More(); i++ {
+		// Reading each element whole means a value of the wrong shape doesn't derail decoding of the rest of the array
+		raw := json.RawMessage{}
+		if err := dec.Decode(&raw); err != nil {
+			return errs, terrors.WrapWithCode(err, map[string]string{
+				"index": fmt.Sprint(i)}, terrors.ErrBadRequest)
+		}
+		decode := func(v interface{}) error {
+			var err error
+			if m, ok := v.(proto.Message); ok {
+				err = protojson.Unmarshal(raw, m)
+			} else {
+				err = json.Unmarshal(raw, v)
+			}
+			if err != nil {
+				errs = append(errs, ArrayElementError{
+					Index: i,
+					Err:   err})
+			}
+			return err
+		}
+		if err := fn(i, decode); err != nil {
+			return errs, err
+		}
+	}
+	// Consume the closing bracket, which validates that the array is properly terminated
+	if _, err := dec.Token(); err != nil {
+		return errs, terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)
+	}
+	return errs, nil
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data request body, so that large uploads can be
+// processed (eg. streamed to storage) one part at a time, without buffering the whole body. Requests with another
+// Content-Type are rejected with an ErrUnsupportedMediaType error, and those without a boundary with a bad_request.
+//
+// Unlike the http.Request method it shadows, the error is a terror which can be returned from a Service directly.
+func (r Request) MultipartReader() (*multipart.Reader, error) {
+	v := r.Header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(v)
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, terrors.New(ErrUnsupportedMediaType, "Request body is not multipart/form-data", map[string]string{
+			"content_type": v})
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, terrors.BadRequest("missing_boundary", "Multipart request has no boundary", nil)
+	}
+	if r.Body == nil {
+		return nil, terrors.BadRequest("missing_body", "Multipart request has no body", nil)
+	}
+	return multipart.NewReader(r.Body, boundary), nil
+}
+
 // Write writes the passed bytes to the request's body.
 func (r *Request) Write(b []byte) (n int, err error) {
 	switch rc := r.Body.(type) {
@@ -214,6 +429,39 @@ func (r Request) String() string {
 	return fmt.Sprintf("Request(%s %s://%s%s)", r.Method, r.URL.Scheme, r.Host, r.URL.Path)
 }
 
+// Protocol returns the version of HTTP with which the request was received, as it appears in the request line: eg.
+// "HTTP/1.1" or "HTTP/2.0". Requests constructed in-process (eg. with NewRequest) report "HTTP/1.1".
+func (r Request) Protocol() string {
+	switch {
+	case r.Proto != "":
+		return r.Proto
+	case r.ProtoMajor != 0:
+		return fmt.Sprintf("HTTP/%d.%d", r.ProtoMajor, r.ProtoMinor)
+	default:
+		return "HTTP/1.1"
+	}
+}
+
+// RawRequestURI returns the request-target exactly as it was sent in the request line, without any decoding. For
+// requests constructed in-process, which weren't received over the network, it is the path and query of the URL.
+func (r Request) RawRequestURI() string {
+	if r.RequestURI != "" || r.URL == nil {
+		return r.RequestURI
+	}
+	return r.URL.RequestURI()
+}
+
+// IsTLS returns whether the request was received over a TLS connection. Requests constructed in-process (which have
+// no RequestURI) are treated as such if their URL's scheme is https; the scheme of a request received by a server is
+// chosen by the client, so isn't trusted. Behind a proxy which terminates TLS, consult the Forwarded elements it adds
+// instead.
+func (r Request) IsTLS() bool {
+	if r.TLS != nil {
+		return true
+	}
+	return r.RequestURI == "" && r.URL != nil && r.URL.Scheme == "https"
+}
+
 // NewRequest constructs a new Request with the given parameters, and if non-nil, encodes the given body into it.
 func NewRequest(ctx context.Context, method, url string, body interface{}) Request {
 	if ctx == nil {
@@ -239,3 +487,36 @@ func NewRequest(ctx context.Context, method, url string, body interface{}) Reque
 	}
 	return req
 }
+
+// protobufMediaTypes are the media types whose bodies are decoded as binary protobuf.
+//
+// application/x-protobuf is the "canonical" use, application/protobuf is defined in an expired IETF draft.
+// See: https://datatracker.ietf.org/doc/html/draft-rfernando-protocol-buffers-00#section-3.2
+// See: https://github.com/google/protorpc/blob/eb03145/python/protorpc/protobuf.py#L49-L51
+var protobufMediaTypes = map[string]bool{
+	"application/octet-stream":      true,
+	"application/x-google-protobuf": true,
+	"application/protobuf":          true,
+	"application/x-protobuf":        true}
+
+// parseContentType returns the (lowercase) media type of a Content-Type header value, so parameters don't affect codec
+// selection. Malformed values have an empty media type, which selects the default codec. If a textual media type
+// declares a charset other than UTF-8 (the only one the codecs support), the charset is also returned.
+func parseContentType(v string) (mediaType, unsupportedCharset string) {
+	if v == "" {
+		return "", ""
+	}
+	mediaType, params, err := mime.ParseMediaType(v)
+	if err != nil && err != mime.ErrInvalidMediaParameter {
+		return "", ""
+	}
+	if protobufMediaTypes[mediaType] {
+		return mediaType, ""
+	}
+	switch charset := params["charset"]; strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return mediaType, ""
+	default:
+		return mediaType, charset
+	}
+}
@@ -3,10 +3,17 @@ package typhon
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"io/ioutil"
+	"mime/multipart"
+	"net/http"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/monzo/terrors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -78,6 +85,30 @@ func TestRequestEncodeProtobuf(t *testing.T) {
 	assert.Subset(t, body, []byte("Hello world!"))
 }
 
+func TestRequestDecodeContentTypeParams(t *testing.T) {
+	t.Parallel()
+
+	g := &prototest.Greeting{
+		Message:  "Hello world!",
+		Priority: 1}
+	req := NewRequest(nil, "POST", "/", nil)
+	req.EncodeAsProtobuf(g)
+	req.Header.Set("Content-Type", "application/x-protobuf; charset=binary")
+	gout := &prototest.Greeting{}
+	require.NoError(t, req.Decode(gout))
+	assert.Equal(t, "Hello world!", gout.Message)
+
+	req = NewRequest(nil, "POST", "/", nil)
+	body := &closeRecorder{Reader: strings.NewReader(`{"a":"b"}`)}
+	req.Body = body
+	req.Header.Set("Content-Type", "application/json; charset=Shift_JIS")
+	err := req.Decode(&map[string]string{})
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, ErrUnsupportedMediaType))
+	assert.Equal(t, http.StatusUnsupportedMediaType, ErrorStatusCode(err))
+	assert.True(t, body.closed)
+}
+
 func TestRequestSetMetadata(t *testing.T) {
 	t.Parallel()
 
@@ -89,3 +120,264 @@ func TestRequestSetMetadata(t *testing.T) {
 
 	assert.Equal(t, []string{"data"}, req.Request.Header["meta"])
 }
+
+func TestRequestWithTimeout(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	_, ok := req.Deadline()
+	assert.False(t, ok)
+
+	before := time.Now()
+	reqT, cancel := req.WithTimeout(time.Minute)
+	defer cancel()
+	deadline, ok := reqT.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, before.Add(time.Minute), deadline, time.Second)
+	// The original request is untouched
+	_, ok = req.Deadline()
+	assert.False(t, ok)
+
+	// Cancelling the returned request's context doesn't affect the original
+	cancel()
+	<-reqT.Done()
+	assert.NoError(t, req.Err())
+
+	// A request with no context has no deadline
+	_, ok = Request{}.Deadline()
+	assert.False(t, ok)
+}
+
+type requestTestKey struct{}
+
+func TestRequestWithContext(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	ctx := context.WithValue(context.Background(), requestTestKey{}, "v")
+	req2 := req.WithContext(ctx)
+	assert.Equal(t, "v", req2.Value(requestTestKey{}))
+	// The embedded http.Request's context is kept consistent
+	assert.Equal(t, "v", req2.Request.Context().Value(requestTestKey{}))
+	// The original request is untouched
+	assert.Nil(t, req.Value(requestTestKey{}))
+	assert.Nil(t, req.Request.Context().Value(requestTestKey{}))
+
+	reqT, cancel := req2.WithTimeout(time.Minute)
+	defer cancel()
+	_, ok := reqT.Request.Context().Deadline()
+	assert.True(t, ok)
+}
+
+func TestRequestWithHeader(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("A", "1")
+
+	req2 := req.WithHeader("b", "2")
+	assert.Equal(t, "1", req2.Header.Get("A"))
+	assert.Equal(t, "2", req2.Header.Get("B"))
+	assert.Empty(t, req.Header.Get("B"))
+
+	req3 := req2.WithHeaders(http.Header{
+		"a": []string{"3"},
+		"C": []string{"4", "5"}})
+	assert.Equal(t, []string{"3"}, req3.Header["A"])
+	assert.Equal(t, []string{"4", "5"}, req3.Header["C"])
+	assert.Equal(t, "2", req3.Header.Get("B"))
+	assert.Equal(t, "1", req2.Header.Get("A"))
+	assert.Empty(t, req2.Header.Get("C"))
+
+	// Requests with no headers at all are supported
+	req4 := Request{}.WithHeader("a", "1")
+	assert.Equal(t, "1", req4.Header.Get("A"))
+}
+
+func TestRequestDecodeArrayPartial(t *testing.T) {
+	t.Parallel()
+	type item struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	req := NewRequest(nil, "POST", "/", nil)
+	req.Body = ioutil.NopCloser(strings.NewReader(
+		`[{"name":"a","count":1}, {"name":"b","count":"two"}, "c", {"name":"d","count":4}]`))
+	var items []item
+	var indices []int
+	errs, err := req.DecodeArrayPartial(func(i int, decode func(interface{}) error) error {
+		v := item{}
+		if err := decode(&v); err != nil {
+			return nil
+		}
+		items = append(items, v)
+		indices = append(indices, i)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []item{{"a", 1}, {"d", 4}}, items)
+	assert.Equal(t, []int{0, 3}, indices)
+	require.Len(t, errs, 2)
+	assert.Equal(t, 1, errs[0].Index)
+	assert.Equal(t, 2, errs[1].Index)
+	assert.Contains(t, errs[1].Error(), "element 2: ")
+
+	// A malformed array is an error, but the element errors so far are still reported
+	req.Body = ioutil.NopCloser(strings.NewReader(`[1, "x", {`))
+	errs, err = req.DecodeArrayPartial(func(i int, decode func(interface{}) error) error {
+		n := 0
+		decode(&n)
+		return nil
+	})
+	assert.True(t, terrors.Is(err, terrors.ErrBadRequest))
+	require.Len(t, errs, 1)
+	assert.Equal(t, 1, errs[0].Index)
+
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"a":1}`))
+	_, err = req.DecodeArrayPartial(func(i int, decode func(interface{}) error) error {
+		return nil
+	})
+	assert.True(t, terrors.Is(err, "bad_request.not_array"))
+
+	// An error from fn stops decoding
+	req.Body = ioutil.NopCloser(strings.NewReader(`[1, 2, 3]`))
+	seen := 0
+	_, err = req.DecodeArrayPartial(func(i int, decode func(interface{}) error) error {
+		seen++
+		return errors.New("stop")
+	})
+	assert.EqualError(t, err, "stop")
+	assert.Equal(t, 1, seen)
+}
+
+func TestRequestMultipartReader(t *testing.T) {
+	t.Parallel()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	require.NoError(t, mw.WriteField("name", "report"))
+	fw, err := mw.CreateFormFile("file", "report.csv")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte("a,b\n1,2\n"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	req := NewRequest(context.Background(), "POST", "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	mr, err := req.MultipartReader()
+	require.NoError(t, err)
+
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "name", part.FormName())
+	b, _ := ioutil.ReadAll(part)
+	assert.Equal(t, "report", string(b))
+	part, err = mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "report.csv", part.FileName())
+	b, _ = ioutil.ReadAll(part)
+	assert.Equal(t, "a,b\n1,2\n", string(b))
+	_, err = mr.NextPart()
+	assert.Equal(t, io.EOF, err)
+
+	req = NewRequest(context.Background(), "POST", "/", map[string]string{})
+	_, err = req.MultipartReader()
+	assert.True(t, terrors.Is(err, ErrUnsupportedMediaType))
+
+	req = NewRequest(context.Background(), "POST", "/", "x")
+	req.Header.Set("Content-Type", "multipart/form-data")
+	_, err = req.MultipartReader()
+	assert.True(t, terrors.Is(err, "bad_request.missing_boundary"))
+}
+
+func TestRequestBasicAuth(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	_, _, ok := req.BasicAuthCredentials()
+	assert.False(t, ok)
+
+	authed := req.WithBasicAuth("alice", "s3cret:pass")
+	assert.Equal(t, "Basic YWxpY2U6czNjcmV0OnBhc3M=", authed.Header.Get("Authorization"))
+	username, password, ok := authed.BasicAuthCredentials()
+	require.True(t, ok)
+	assert.Equal(t, "alice", username)
+	assert.Equal(t, "s3cret:pass", password)
+	// The original request is untouched
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	req.Header.Set("Authorization", "Bearer token")
+	_, _, ok = req.BasicAuthCredentials()
+	assert.False(t, ok)
+}
+
+func TestRequestProtocolAccessors(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET", "https://example.com/a%2Fb?c=d", nil)
+	assert.Equal(t, "HTTP/1.1", req.Protocol())
+	assert.Equal(t, "/a%2Fb?c=d", req.RawRequestURI())
+	assert.True(t, req.IsTLS())
+
+	req = NewRequest(context.Background(), "GET", "/foo", nil)
+	assert.False(t, req.IsTLS())
+
+	// A server can't trust the scheme of an absolute-form request target, which the client chooses
+	req = NewRequest(context.Background(), "GET", "https://example.com/foo", nil)
+	req.RequestURI = "https://example.com/foo"
+	assert.False(t, req.IsTLS())
+
+	// As received by a server
+	svc := Service(func(req Request) Response {
+		return req.Response(map[string]interface{}{
+			"proto": req.Protocol(),
+			"uri":   req.RawRequestURI(),
+			"tls":   req.IsTLS()})
+	})
+	s, err := Listen(svc, "localhost:0", WithTLS(&tls.Config{
+		Certificates: []tls.Certificate{keypair(t, []string{"localhost"})},
+		NextProtos:   []string{"h2", "http/1.1"}}))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Stop(context.Background())
+	})
+
+	client := HttpService(HTTPVersionTransport(&http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true}}, HTTPVersionAuto))
+	rsp := client(NewRequest(context.Background(), "GET", "https://"+s.Listener().Addr().String()+"/a%2Fb?c=d", nil))
+	require.NoError(t, rsp.Error)
+	body := map[string]interface{}{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, map[string]interface{}{
+		"proto": "HTTP/2.0",
+		"uri":   "/a%2Fb?c=d",
+		"tls":   true}, body)
+}
+
+func TestRequestSetBody(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "POST", "/", "original")
+	req.Header.Set("Content-Length", "11")
+	req.SetBody([]byte("replaced"))
+	assert.EqualValues(t, 8, req.ContentLength)
+	assert.Equal(t, "8", req.Header.Get("Content-Length"))
+	b, err := req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "replaced", string(b))
+
+	req.SetBodyReader(strings.NewReader("streamed"), -1)
+	assert.EqualValues(t, -1, req.ContentLength)
+	assert.Empty(t, req.Header.Get("Content-Length"))
+	b, err = req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(b))
+
+	// Requests without a Content-Length header don't gain one
+	req = NewRequest(context.Background(), "POST", "/", nil)
+	req.SetBodyReader(strings.NewReader("abc"), 3)
+	assert.EqualValues(t, 3, req.ContentLength)
+	assert.Empty(t, req.Header.Get("Content-Length"))
+}
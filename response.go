@@ -1,12 +1,16 @@
 package typhon
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	legacyproto "github.com/golang/protobuf/proto"
@@ -24,18 +28,30 @@ type Response struct {
 	Error    error
 	Request  *Request // The Request that we are responding to
 	hijacked bool
+	// writtenStatus and bytesWritten record what a handler wrote through Writer()
+	writtenStatus int
+	bytesWritten  int64
 }
 
-// Encode serialises the passed object into the body (and sets appropriate headers).
+// Encode serialises the passed object into the body (and sets appropriate headers). Protobuf messages are sent in the
+// protobuf wire format if the request accepts application/protobuf (unless overridden; see WithCodec), and as JSON
+// otherwise.
+//
+// Readers are sent as-is, except that an io.ReadSeekCloser is used to serve a Range request (see
+// encodeReadSeekCloser). Values implementing io.WriterTo are streamed by calling WriteTo as the body is read, without
+// being buffered first; if they have a Len method it is used as the Content-Length.
 func (r *Response) Encode(v interface{}) {
 	if r.Response == nil {
 		r.Response = newHTTPResponse(Request{}, http.StatusOK)
 	}
 
-	// If we were given an io.ReadCloser or an io.Reader (that is not also
+	// If we were given an io.ReadCloser, an io.Reader or an io.WriterTo (that is not also
 	// a json.Marshaler or proto.Message), use it directly
 	switch v := v.(type) {
 	case proto.Message, json.Marshaler, legacyproto.Message:
+	case io.ReadSeekCloser:
+		r.encodeReadSeekCloser(v)
+		return
 	case io.ReadCloser:
 		r.Body = v
 		r.ContentLength = -1
@@ -44,13 +60,17 @@ func (r *Response) Encode(v interface{}) {
 		r.Body = ioutil.NopCloser(v)
 		r.ContentLength = -1
 		return
+	case io.WriterTo:
+		r.Body = writerToBody(v)
+		r.ContentLength = bodyLength(v)
+		return
 	}
 
 	// If we're a proto.Message check for a protobuf type and send that.
 	switch m := v.(type) {
 	case proto.Message:
 		// if we didn't ask for protobuf, send JSON
-		if !strings.Contains(r.Request.Header.Get("Accept"), "application/protobuf") {
+		if !encodesProtobuf(r.Request) {
 			r.EncodeAsProtobufJSON(m)
 			return
 		}
@@ -59,7 +79,7 @@ func (r *Response) Encode(v interface{}) {
 		return
 	case legacyproto.Message:
 		// if we asked for protobuf, send it using the legacy encoder for the error filter.
-		if strings.Contains(r.Request.Header.Get("Accept"), "application/protobuf") {
+		if encodesProtobuf(r.Request) {
 			r.EncodeAsLegacyProtobuf(m)
 			return
 		}
@@ -68,10 +88,43 @@ func (r *Response) Encode(v interface{}) {
 	r.EncodeAsJSON(v)
 }
 
+// EncodeError makes the response an error response in a single step: it sets Error, replaces the body with the
+// serialised error (using the codec negotiated by the request, like Encode, or as a google.rpc.Status message if the
+// request accepts RPCStatusMediaType) and sets the status code which corresponds to the error's terrors code. The
+// error is wrapped as a terror if necessary; its stack is only included if IncludeErrorStacks is set. ErrorFilter does
+// this for any response whose Error is set but which has a 200 status.
+func (r *Response) EncodeError(err error) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	terr := asTerror(err)
+	if err == nil {
+		err = terr
+	}
+	r.Error = err
+	if r.Body != nil {
+		r.Body.Close()
+	}
+	r.Body = &bufCloser{}
+	r.ContentLength = 0
+	if encodesRPCStatus(r.Request) {
+		r.EncodeAsRPCStatus(terr)
+		r.StatusCode = ErrorStatusCode(terr)
+		return
+	}
+	terrp := terrors.Marshal(terr)
+	if !IncludeErrorStacks {
+		terrp.Stack = nil
+	}
+	r.Encode(terrp)
+	r.StatusCode = ErrorStatusCode(terr)
+	r.Header.Set("Terror", "1")
+}
+
 // EncodeAsJSON writes the response as JSON. This is the default encoding type when using Encode.
 func (r *Response) EncodeAsJSON(v interface{}) {
 	if err := json.NewEncoder(r).Encode(v); err != nil {
-		r.Error = terrors.Wrap(err, nil)
+		r.encodeFailed(err)
 		return
 	}
 	r.Header.Set("Content-Type", "application/json")
@@ -81,12 +134,15 @@ func (r *Response) EncodeAsJSON(v interface{}) {
 func (r *Response) EncodeAsProtobuf(m proto.Message) {
 	b, err := proto.Marshal(m)
 	if err != nil {
-		r.Error = terrors.Wrap(err, nil)
+		r.encodeFailed(err)
 		return
 	}
 
 	n, err := r.Write(b)
-	r.Error = terrors.Wrap(err, nil)
+	if err != nil {
+		r.encodeFailed(err)
+		return
+	}
 	r.Header.Set("Content-Type", "application/protobuf")
 	r.ContentLength = int64(n)
 }
@@ -95,12 +151,15 @@ func (r *Response) EncodeAsProtobuf(m proto.Message) {
 func (r *Response) EncodeAsLegacyProtobuf(m legacyproto.Message) {
 	b, err := legacyproto.Marshal(m)
 	if err != nil {
-		r.Error = terrors.Wrap(err, nil)
+		r.encodeFailed(err)
 		return
 	}
 
 	n, err := r.Write(b)
-	r.Error = terrors.Wrap(err, nil)
+	if err != nil {
+		r.encodeFailed(err)
+		return
+	}
 	r.Header.Set("Content-Type", "application/protobuf")
 	r.ContentLength = int64(n)
 }
@@ -110,16 +169,35 @@ func (r *Response) EncodeAsLegacyProtobuf(m legacyproto.Message) {
 func (r *Response) EncodeAsProtobufJSON(m proto.Message) {
 	b, err := protojson.Marshal(m)
 	if err != nil {
-		r.Error = terrors.Wrap(err, nil)
+		r.encodeFailed(err)
 		return
 	}
 
 	n, err := r.Write(b)
-	r.Error = terrors.Wrap(err, nil)
+	if err != nil {
+		r.encodeFailed(err)
+		return
+	}
 	r.Header.Set("Content-Type", "application/json")
 	r.ContentLength = int64(n)
 }
 
+// encodeFailed puts the response into a clean error state after its body could not be serialised: anything that was
+// written to the body is discarded, and no Content-Type is advertised for the (empty) body. The status is left for
+// ErrorFilter to set when it serialises the error.
+func (r *Response) encodeFailed(err error) {
+	r.Error = terrors.Wrap(err, nil)
+	if r.Response == nil {
+		return
+	}
+	if r.Body != nil {
+		r.Body.Close()
+	}
+	r.Body = &bufCloser{}
+	r.ContentLength = 0
+	r.Header.Del("Content-Type")
+}
+
 // WrapDownstreamErrors is a context key that can be used to enable
 // wrapping of downstream response errors on a per-request basis.
 //
@@ -128,12 +206,29 @@ func (r *Response) EncodeAsProtobufJSON(m proto.Message) {
 // dependency on config to Typhon.
 type WrapDownstreamErrors struct{}
 
-// Decode de-serialises the body into the passed object.
-func (r *Response) Decode(v interface{}) error {
+// wrapDownstreamError wraps an error received from a downstream so it isn't propagated verbatim. The original error is
+// preserved as the cause, and if it is a terror its code, message, and params are copied into the wrapper's params
+// under a "downstream." prefix (so they can't collide with the wrapper's own params).
+func wrapDownstreamError(err error) error {
+	var params map[string]string
+	if terr, ok := err.(*terrors.Error); ok {
+		params = make(map[string]string, len(terr.Params)+2)
+		params["downstream.code"] = terr.Code
+		params["downstream.message"] = terr.Message
+		for k, v := range terr.Params {
+			params["downstream.param."+k] = v
+		}
+	}
+	return terrors.NewInternalWithCause(err, "Downstream request error", params, "downstream")
+}
+
+// decodable returns an error if the response's body can't be decoded, either because the response is an error or
+// because it has no body. It is shared by all the ways of decoding a response.
+func (r *Response) decodable() error {
 	if r.Error != nil {
 		if r.Request != nil && r.Request.Context != nil {
 			if s, ok := r.Request.Context.Value(WrapDownstreamErrors{}).(string); ok && s != "" {
-				return terrors.NewInternalWithCause(r.Error, "Downstream request error", nil, "downstream")
+				return wrapDownstreamError(r.Error)
 			}
 		}
 
@@ -144,12 +239,70 @@ func (r *Response) Decode(v interface{}) error {
 		r.Error = terrors.InternalService("", "Response has no body", nil)
 		return r.Error
 	}
+	return nil
+}
+
+// Err returns the response's error, treating 4xx and 5xx status codes as errors even when Error is nil (eg. because
+// ErrorFilter is not in use). In that case a terror serialised in the body is returned if there is one, and otherwise
+// an error is synthesised with the terrors code corresponding to the status code and the body as its message. The body
+// remains readable afterwards. Responses with other status codes and no Error return nil.
+func (r Response) Err() error {
+	if r.Error != nil {
+		return r.Error
+	}
+	if r.Response == nil || r.StatusCode < 400 || r.StatusCode > 599 {
+		return nil
+	}
+
+	b, _ := r.BodyBytes(false)
+	if r.Header.Get("Terror") == "1" {
+		if terr, err := unmarshalTerror(r.Header, b); err == nil {
+			return terr
+		}
+	}
+	code, ok := mapStatus2Terr[r.StatusCode]
+	switch {
+	case ok:
+	case r.StatusCode < 500:
+		code = terrors.ErrBadRequest
+	default:
+		code = terrors.ErrInternalService
+	}
+	msg := strings.TrimSpace(string(b))
+	if msg == "" {
+		msg = http.StatusText(r.StatusCode)
+	}
+	return terrors.New(code, msg, map[string]string{
+		"status_code": strconv.Itoa(r.StatusCode)})
+}
+
+// Decode de-serialises the body into the passed object. The codec is selected by the Content-Type header's media
+// type; a charset other than UTF-8 is rejected with an ErrBadResponse error. Compressed bodies are decompressed
+// according to their Content-Encoding before they are decoded, whichever the codec.
+func (r *Response) Decode(v interface{}) error {
+	if err := r.decodable(); err != nil {
+		return err
+	}
+
+	mediaType, charset := parseContentType(r.Header.Get("Content-Type"))
+	if charset != "" {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		r.Error = terrors.BadResponse("unsupported_charset", "Unsupported charset", map[string]string{
+			"charset": charset})
+		return r.Error
+	}
 
 	var b []byte
-	b, err := r.BodyBytes(true)
+	var err error
+	if protobufMediaTypes[mediaType] {
+		b, err = r.protoBodyBytes()
+	} else {
+		b, err = r.decodeBytes()
+	}
 	if err != nil {
-		r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
-		return r.Error
+		return err
 	}
 
 	switch m := v.(type) {
@@ -157,11 +310,8 @@ func (r *Response) Decode(v interface{}) error {
 	// This presents a bit of a backwards compatibility issue, though only for those who have been using
 	// proto.Message incorrectly (without encoding/protojson) with Typhon.
 	case proto.Message:
-		switch r.Header.Get("Content-Type") {
-		case "application/octet-stream",
-			"application/x-google-protobuf",
-			"application/protobuf",
-			"application/x-protobuf":
+		switch {
+		case protobufMediaTypes[mediaType]:
 			err = proto.Unmarshal(b, m)
 		default:
 			err = protojson.Unmarshal(b, m)
@@ -171,11 +321,8 @@ func (r *Response) Decode(v interface{}) error {
 	// This is against Google's recommendations, but also doesn't break things for active users of Typhon.
 	// Upgrade to google.golang.org/protobuf/proto.Message as soon as possible.
 	case legacyproto.Message:
-		switch r.Header.Get("Content-Type") {
-		case "application/octet-stream",
-			"application/x-google-protobuf",
-			"application/protobuf",
-			"application/x-protobuf":
+		switch {
+		case protobufMediaTypes[mediaType]:
 			err = legacyproto.Unmarshal(b, m)
 		default:
 			err = json.Unmarshal(b, m)
@@ -190,6 +337,252 @@ func (r *Response) Decode(v interface{}) error {
 	return err
 }
 
+// DecodeJSON de-serialises the body into the passed object as JSON, regardless of the Content-Type header. This is
+// useful for downstreams which return JSON with the wrong (or no) Content-Type. Protobuf messages are decoded with
+// protojson.
+func (r *Response) DecodeJSON(v interface{}) error {
+	if err := r.decodable(); err != nil {
+		return err
+	}
+	b, err := r.decodeBytes()
+	if err != nil {
+		return err
+	}
+
+	if m, ok := v.(proto.Message); ok {
+		err = protojson.Unmarshal(b, m)
+	} else {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		r.Error = err
+	}
+	return err
+}
+
+// DecodeMap de-serialises a JSON object body into a generic map, for handling bodies whose schema isn't known (eg.
+// in pass-through transformations or debugging tools). As with DecodeJSON, the Content-Type header is ignored.
+func (r *Response) DecodeMap() (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if err := r.DecodeJSON(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DecodeSlice de-serialises a JSON array body into a generic slice; see DecodeMap.
+func (r *Response) DecodeSlice() ([]interface{}, error) {
+	s := []interface{}{}
+	if err := r.DecodeJSON(&s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DecodeProto de-serialises the body into the passed message as binary protobuf, regardless of the Content-Type
+// header.
+func (r *Response) DecodeProto(m proto.Message) error {
+	if err := r.decodable(); err != nil {
+		return err
+	}
+	b, err := r.protoBodyBytes()
+	if err != nil {
+		return err
+	}
+
+	if err := proto.Unmarshal(b, m); err != nil {
+		r.Error = err
+		return err
+	}
+	return nil
+}
+
+// decodeBytes consumes the body for decoding, recording any error reading it on the response.
+func (r *Response) decodeBytes() ([]byte, error) {
+	b, err := r.BodyBytes(true)
+	if err != nil {
+		r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+		return nil, r.Error
+	}
+	return b, nil
+}
+
+// maxProtoPreallocation is the most which protoBodyBytes allocates up-front for a body of known length. The length is
+// declared by the peer, so a larger buffer is only grown as the body arrives.
+const maxProtoPreallocation = 1 << 20 // 1 MiB
+
+// protoBodyBytes consumes the body for decoding as a single protobuf message, which can only be unmarshalled once it is
+// entirely in memory. Unlike BodyBytes, it doesn't copy bodies which are already buffered, and reads bodies of a known
+// length into a buffer of (up to maxProtoPreallocation) that size, so decoding needs little more memory than the
+// message itself.
+func (r *Response) protoBodyBytes() ([]byte, error) {
+	if r.Header.Get("Content-Encoding") == "" {
+		switch body := r.Body.(type) {
+		case *bufCloser:
+			return body.Next(body.Len()), nil
+		case nil:
+		default:
+			if r.ContentLength > 0 {
+				defer body.Close()
+				size := r.ContentLength
+				if size > maxProtoPreallocation {
+					size = maxProtoPreallocation
+				}
+				buf := bytes.NewBuffer(make([]byte, 0, size))
+				n, err := buf.ReadFrom(io.LimitReader(body, r.ContentLength))
+				if err == nil && n < r.ContentLength {
+					err = io.ErrUnexpectedEOF
+				}
+				if err != nil {
+					r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+					return nil, r.Error
+				}
+				return buf.Bytes(), nil
+			}
+		}
+	}
+	return r.decodeBytes()
+}
+
+// DecodeProtoDelimited de-serialises a body containing a stream of length-delimited protobuf messages (each prefixed
+// by its size as a varint) one message at a time, as it is read, so only one message is held in memory at once. fn is
+// called once per message with a function which decodes that message into the passed one; messages which fn doesn't
+// decode are skipped. If fn returns an error, decoding stops and the error is returned. The body is closed once
+// decoding finishes.
+func (r *Response) DecodeProtoDelimited(fn func(decode func(m proto.Message) error) error) error {
+	if err := r.decodable(); err != nil {
+		return err
+	}
+	body, err := r.BodyReader(true)
+	if err != nil {
+		r.Error = err
+		return r.Error
+	}
+	defer body.Close()
+
+	rdr := bufio.NewReader(body)
+	var buf []byte
+	for {
+		size, err := binary.ReadUvarint(rdr)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+			return r.Error
+		} else if size > math.MaxInt32 {
+			r.Error = terrors.BadResponse("message_too_large", "Delimited message is too large", map[string]string{
+				"size": strconv.FormatUint(size, 10)})
+			return r.Error
+		}
+		if uint64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		buf = buf[:size]
+		if _, err := io.ReadFull(rdr, buf); err != nil {
+			r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+			return r.Error
+		}
+
+		decode := func(m proto.Message) error {
+			if err := proto.Unmarshal(buf, m); err != nil {
+				r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+				return r.Error
+			}
+			return nil
+		}
+		if err := fn(decode); err != nil {
+			return err
+		}
+	}
+}
+
+// DecodeArray de-serialises a body containing a JSON array one element at a time, as it is read, rather than buffering
+// the whole array in memory. fn is called once per element with a function which decodes that element into the passed
+// object; elements which fn doesn't decode are skipped. If fn returns an error, decoding stops and the error is
+// returned. The body is closed once decoding finishes.
+func (r *Response) DecodeArray(fn func(decode func(v interface{}) error) error) error {
+	if err := r.decodable(); err != nil {
+		return err
+	}
+	body, err := r.BodyReader(true)
+	if err != nil {
+		r.Error = err
+		return r.Error
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	tok, err := dec.Token()
+	if err != nil {
+		r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+		return r.Error
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		r.Error = terrors.BadResponse("not_array", "Response body is not a JSON array", nil)
+		return r.Error
+	}
+
+	for dec.More() {
+		decoded := false
+		decode := func(v interface{}) error {
+			decoded = true
+			if err := dec.Decode(v); err != nil {
+				r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+				return r.Error
+			}
+			return nil
+		}
+		if err := fn(decode); err != nil {
+			return err
+		}
+		if !decoded {
+			if err := dec.Decode(&json.RawMessage{}); err != nil {
+				r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+				return r.Error
+			}
+		}
+	}
+	// Consume the closing bracket, which validates that the array is properly terminated
+	if _, err := dec.Token(); err != nil {
+		r.Error = terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+		return r.Error
+	}
+	return nil
+}
+
+// GetHeader returns the first value of the named response header, or "" if it isn't set. Unlike accessing r.Header
+// directly, it is safe to call on a Response without an http.Response (eg. one containing only an Error).
+func (r Response) GetHeader(key string) string {
+	if r.Response == nil {
+		return ""
+	}
+	return r.Header.Get(key)
+}
+
+// SetHeader sets the named response header to the passed value, replacing any existing values. If the response has no
+// http.Response yet, one is initialised with status 200 (as with Encode and Write), so filters can set headers on
+// error-only responses.
+func (r *Response) SetHeader(key, value string) {
+	r.initHeader()
+	r.Header.Set(key, value)
+}
+
+// AddHeader adds the passed value to the named response header, initialising the response like SetHeader if
+// necessary.
+func (r *Response) AddHeader(key, value string) {
+	r.initHeader()
+	r.Header.Add(key, value)
+}
+
+func (r *Response) initHeader() {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+}
+
 // Write writes the passed bytes to the response's body.
 func (r *Response) Write(b []byte) (n int, err error) {
 	if r.Response == nil {
@@ -232,28 +625,104 @@ func (r *Response) Write(b []byte) (n int, err error) {
 	return n, nil
 }
 
-// BodyBytes fully reads the response body and returns the bytes read. If consume is false, the body is copied into a
-// new buffer such that it may be read again.
-func (r *Response) BodyBytes(consume bool) ([]byte, error) {
+// BodyReader returns a reader over the response body, transparently decompressed according to its Content-Encoding.
+// The reader must be closed once it is finished with.
+//
+// If consume is true, the reader reads directly from the body, which cannot be read again afterwards. If false, the
+// (raw) body is buffered first so that it may be read again.
+func (r *Response) BodyReader(consume bool) (io.ReadCloser, error) {
+	var body io.ReadCloser
 	if consume {
-		defer r.Body.Close()
-		return ioutil.ReadAll(r.Body)
+		body = r.Body
+	} else {
+		switch rc := r.Body.(type) {
+		case *bufCloser:
+			body = ioutil.NopCloser(bytes.NewReader(rc.Bytes()))
+		default:
+			buf := &bufCloser{}
+			r.Body = buf
+			_, err := io.Copy(buf, rc)
+			// rc will never again be accessible: once it's copied it must be closed
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+		}
 	}
 
-	switch rc := r.Body.(type) {
-	case *bufCloser:
-		return rc.Bytes(), nil
+	enc := r.Header.Get("Content-Encoding")
+	rdr, err := decompressingReader(enc, body)
+	if err != nil {
+		body.Close()
+		if err == errUnsupportedEncoding {
+			return nil, terrors.BadResponse("unsupported_encoding", "Unsupported Content-Encoding", map[string]string{
+				"content_encoding": enc})
+		}
+		return nil, terrors.WrapWithCode(err, nil, terrors.ErrBadResponse)
+	}
+	return rdr, nil
+}
 
-	default:
-		buf := &bufCloser{}
-		r.Body = buf
-		rdr := io.TeeReader(rc, buf)
-		// rc will never again be accessible: once it's copied it must be closed
-		defer rc.Close()
-		return ioutil.ReadAll(rdr)
+// BodyBytes fully reads the response body and returns the bytes read, decompressed according to its
+// Content-Encoding. If consume is false, the body is copied into a new buffer such that it may be read again.
+func (r *Response) BodyBytes(consume bool) ([]byte, error) {
+	// Fast path: the body is already buffered and needs no decoding
+	if buf, ok := r.Body.(*bufCloser); ok && !consume && r.Header.Get("Content-Encoding") == "" {
+		return buf.Bytes(), nil
+	}
+
+	rdr, err := r.BodyReader(consume)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	return ioutil.ReadAll(rdr)
+}
+
+// Trailers returns the HTTP trailers received after the response body. Trailers are only known once the body has been
+// read to the end, so if necessary the rest of the body is buffered (so it can still be read afterwards) first. If the
+// body can't be read, the trailers received so far are returned.
+func (r *Response) Trailers() http.Header {
+	if r.Response == nil {
+		return nil
+	}
+	if r.Body != nil {
+		if body, err := r.BodyReader(false); err == nil {
+			body.Close()
+		}
+	}
+	return r.Trailer
+}
+
+// DeclareTrailer announces HTTP trailers which will be sent after the response body, allowing their values to be set
+// (with SetTrailer) as the body is being produced, eg. a checksum of a streamed body. Trailers must be declared before
+// the Service returns the response.
+func (r *Response) DeclareTrailer(keys ...string) {
+	if r.Response == nil {
+		r.Response = newHTTPResponse(Request{}, http.StatusOK)
+	}
+	if r.Trailer == nil {
+		r.Trailer = make(http.Header, len(keys))
+	}
+	for _, k := range keys {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := r.Trailer[k]; !ok {
+			r.Trailer[k] = nil
+			r.Header.Add("Trailer", k)
+		}
 	}
 }
 
+// SetTrailer sets the value of a HTTP trailer to be sent after the response body, declaring it if necessary.
+//
+// Once the Service has returned the response, only trailers which have already been declared may be set, and only
+// until the body has been fully read.
+func (r *Response) SetTrailer(key, value string) {
+	r.DeclareTrailer(key)
+	r.Trailer.Set(key, value)
+}
+
 // Writer returns a ResponseWriter which can be used to populate the response.
 //
 // This is useful when you want to use another HTTP library that is used to wrapping net/http directly. For example,
@@ -265,6 +734,12 @@ func (r *Response) Writer() ResponseWriter {
 				r: r},
 			Hijacker: r.Request.hijacker}
 	}
+	if r.Request != nil && r.Request.pusher != nil {
+		return pusherRw{
+			responseWriterWrapper: responseWriterWrapper{
+				r: r},
+			Pusher: r.Request.pusher}
+	}
 	return responseWriterWrapper{
 		r: r}
 }
@@ -2,6 +2,7 @@ package typhon
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -15,6 +16,8 @@ import (
 	"github.com/monzo/typhon/prototest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -200,6 +203,77 @@ func TestResponseDecodeProtobuf(t *testing.T) {
 	assert.EqualValues(t, 1, gout.Priority)
 }
 
+// TestResponseDecodeGzippedProtobuf verifies that compressed bodies are decompressed before the codec sees them, for
+// both protobuf and JSON bodies, and regardless of whether the length of the compressed body is known
+func TestResponseDecodeGzippedProtobuf(t *testing.T) {
+	t.Parallel()
+
+	g := &prototest.Greeting{
+		Message:  "Hello world!",
+		Priority: 1}
+	gzipped := func(b []byte) []byte {
+		buf := &bytes.Buffer{}
+		gw := gzip.NewWriter(buf)
+		gw.Write(b)
+		gw.Close()
+		return buf.Bytes()
+	}
+	pb, _ := proto.Marshal(g)
+	jb, _ := protojson.Marshal(g)
+	cases := map[string][]byte{
+		"application/protobuf":   gzipped(pb),
+		"application/x-protobuf": gzipped(pb),
+		"application/json":       gzipped(jb)}
+
+	for contentType, body := range cases {
+		for _, length := range []int64{int64(len(body)), -1} {
+			rsp := NewResponse(Request{})
+			rsp.Body = ioutil.NopCloser(bytes.NewReader(body))
+			rsp.ContentLength = length
+			rsp.Header.Set("Content-Type", contentType)
+			rsp.Header.Set("Content-Encoding", "gzip")
+
+			gout := &prototest.Greeting{}
+			require.NoError(t, rsp.Decode(gout), contentType)
+			assert.Equal(t, "Hello world!", gout.Message, contentType)
+			assert.EqualValues(t, 1, gout.Priority, contentType)
+		}
+	}
+
+	rsp := NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(gzipped(pb)))
+	rsp.Header.Set("Content-Encoding", "gzip")
+	gout := &prototest.Greeting{}
+	require.NoError(t, rsp.DecodeProto(gout))
+	assert.Equal(t, "Hello world!", gout.Message)
+}
+
+func TestResponseDecodeMapAndSlice(t *testing.T) {
+	t.Parallel()
+
+	rsp := NewResponse(Request{})
+	rsp.Encode(map[string]interface{}{"a": 1, "b": []string{"x"}})
+	m, err := rsp.DecodeMap()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": float64(1), "b": []interface{}{"x"}}, m)
+
+	rsp = NewResponse(Request{})
+	rsp.Encode([]interface{}{"a", 1, nil})
+	s, err := rsp.DecodeSlice()
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"a", float64(1), nil}, s)
+
+	rsp = NewResponse(Request{})
+	rsp.Encode([]string{"not", "a", "map"})
+	_, err = rsp.DecodeMap()
+	assert.Error(t, err)
+	assert.Equal(t, err, rsp.Error)
+
+	rsp = Response{Error: errors.New("boom")}
+	_, err = rsp.DecodeSlice()
+	assert.EqualError(t, err, "boom")
+}
+
 // TestResponseDecodeProtobufWithAltType verifies decoding of a protobuf message
 func TestResponseDecodeProtobufWithAltType(t *testing.T) {
 	t.Parallel()
@@ -218,6 +292,219 @@ func TestResponseDecodeProtobufWithAltType(t *testing.T) {
 	assert.EqualValues(t, 1, gout.Priority)
 }
 
+// TestResponseDecodeContentTypeParams verifies that Content-Type parameters don't affect codec selection, and that
+// unsupported charsets are rejected
+func TestResponseDecodeContentTypeParams(t *testing.T) {
+	t.Parallel()
+
+	g := &prototest.Greeting{
+		Message:  "Hello world!",
+		Priority: 1}
+	b, _ := proto.Marshal(g)
+	rsp := NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	rsp.Header.Set("Content-Type", "Application/Protobuf; charset=binary")
+	gout := &prototest.Greeting{}
+	require.NoError(t, rsp.Decode(gout))
+	assert.Equal(t, "Hello world!", gout.Message)
+
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(strings.NewReader(`{"a":"b"}`))
+	rsp.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	v := map[string]string{}
+	require.NoError(t, rsp.Decode(&v))
+	assert.Equal(t, map[string]string{"a": "b"}, v)
+
+	rsp = NewResponse(Request{})
+	body := &closeRecorder{Reader: strings.NewReader(`{"a":"b"}`)}
+	rsp.Body = body
+	rsp.Header.Set("Content-Type", "application/json; charset=iso-8859-1")
+	err := rsp.Decode(&v)
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, "bad_response.unsupported_charset"))
+	assert.True(t, body.closed)
+}
+
+// TestResponseDecodeExplicitCodec verifies that DecodeJSON and DecodeProto ignore the Content-Type header
+func TestResponseDecodeExplicitCodec(t *testing.T) {
+	t.Parallel()
+
+	rsp := NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(strings.NewReader(`{"message":"Hello world!","priority":1}`))
+	rsp.Header.Set("Content-Type", "application/protobuf")
+	gout := &prototest.Greeting{}
+	require.NoError(t, rsp.DecodeJSON(gout))
+	assert.Equal(t, "Hello world!", gout.Message)
+	assert.EqualValues(t, 1, gout.Priority)
+
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(strings.NewReader(`{"a":"b"}`))
+	rsp.Header.Set("Content-Type", "text/plain")
+	v := map[string]string{}
+	require.NoError(t, rsp.DecodeJSON(&v))
+	assert.Equal(t, map[string]string{"a": "b"}, v)
+
+	b, _ := proto.Marshal(&prototest.Greeting{
+		Message:  "Hello world!",
+		Priority: 1})
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	rsp.Header.Set("Content-Type", "application/json")
+	gout = &prototest.Greeting{}
+	require.NoError(t, rsp.DecodeProto(gout))
+	assert.Equal(t, "Hello world!", gout.Message)
+
+	// Errors are handled like Decode
+	rsp = Response{Error: terrors.NotFound("foo", "bar", nil)}
+	assert.True(t, terrors.Is(rsp.DecodeJSON(&v), terrors.ErrNotFound))
+	assert.True(t, terrors.Is(rsp.DecodeProto(gout), terrors.ErrNotFound))
+	rsp = Response{}
+	assert.Error(t, rsp.DecodeProto(gout))
+
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(strings.NewReader("not json"))
+	assert.Error(t, rsp.DecodeJSON(&v))
+	assert.Error(t, rsp.Error)
+}
+
+// TestResponseDecodeProtobufBodies verifies that protobuf decoding works for each kind of body
+func TestResponseDecodeProtobufBodies(t *testing.T) {
+	t.Parallel()
+
+	g := &prototest.Greeting{
+		Message:  "Hello world!",
+		Priority: 1}
+	b, _ := proto.Marshal(g)
+	bodies := map[string]func(rsp *Response){
+		"buffered": func(rsp *Response) {
+			rsp.Body = &bufCloser{}
+			rsp.Write(b)
+		},
+		"known length": func(rsp *Response) {
+			rsp.Body = ioutil.NopCloser(bytes.NewReader(b))
+			rsp.ContentLength = int64(len(b))
+		},
+		"unknown length": func(rsp *Response) {
+			rsp.Body = ioutil.NopCloser(bytes.NewReader(b))
+			rsp.ContentLength = -1
+		}}
+	for name, setBody := range bodies {
+		rsp := NewResponse(Request{})
+		setBody(&rsp)
+		rsp.Header.Set("Content-Type", "application/protobuf")
+		gout := &prototest.Greeting{}
+		require.NoError(t, rsp.Decode(gout), name)
+		assert.Equal(t, "Hello world!", gout.Message, name)
+		assert.EqualValues(t, 1, gout.Priority, name)
+	}
+
+	// A body shorter than its declared length is an error
+	rsp := NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	rsp.ContentLength = int64(len(b)) + 10
+	err := rsp.DecodeProto(&prototest.Greeting{})
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, terrors.ErrBadResponse))
+
+	// A huge declared length isn't allocated up-front
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	rsp.ContentLength = 1 << 50
+	err = rsp.DecodeProto(&prototest.Greeting{})
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, terrors.ErrBadResponse))
+}
+
+func TestResponseDecodeProtoDelimited(t *testing.T) {
+	t.Parallel()
+
+	var buf []byte
+	for i := 1; i <= 3; i++ {
+		b, _ := proto.Marshal(&prototest.Greeting{
+			Message:  strings.Repeat("x", i),
+			Priority: int32(i)})
+		buf = protowire.AppendVarint(buf, uint64(len(b)))
+		buf = append(buf, b...)
+	}
+
+	rsp := NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	var messages []string
+	err := rsp.DecodeProtoDelimited(func(decode func(m proto.Message) error) error {
+		g := &prototest.Greeting{}
+		if err := decode(g); err != nil {
+			return err
+		}
+		messages = append(messages, g.Message)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "xx", "xxx"}, messages)
+
+	// Messages which aren't decoded are skipped
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	n := 0
+	require.NoError(t, rsp.DecodeProtoDelimited(func(decode func(m proto.Message) error) error {
+		n++
+		return nil
+	}))
+	assert.Equal(t, 3, n)
+
+	// A truncated stream is an error
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(buf[:len(buf)-1]))
+	err = rsp.DecodeProtoDelimited(func(decode func(m proto.Message) error) error {
+		return decode(&prototest.Greeting{})
+	})
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, terrors.ErrBadResponse))
+}
+
+func TestResponseErr(t *testing.T) {
+	t.Parallel()
+
+	// An explicit error takes precedence
+	rsp := Response{Error: terrors.NotFound("thing", "Not found", nil)}
+	assert.Equal(t, rsp.Error, rsp.Err())
+
+	rsp = NewResponse(Request{})
+	assert.NoError(t, rsp.Err())
+	rsp = Response{}
+	assert.NoError(t, rsp.Err())
+
+	// A serialised terror is decoded
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	rsp = ErrorFilter(req, func(req Request) Response {
+		return Response{Error: terrors.Forbidden("nope", "Go away", nil)}
+	})
+	rsp.Error = nil
+	err := rsp.Err()
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, "forbidden.nope"))
+	assert.Equal(t, "Go away", err.(*terrors.Error).Message)
+
+	// Other error statuses are mapped to a code
+	rsp = NewResponse(Request{})
+	rsp.StatusCode = http.StatusServiceUnavailable
+	rsp.Body = ioutil.NopCloser(strings.NewReader("upstream down\n"))
+	err = rsp.Err()
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, ErrServiceUnavailable))
+	assert.Equal(t, "upstream down", err.(*terrors.Error).Message)
+	assert.Equal(t, "503", err.(*terrors.Error).Params["status_code"])
+	// The body is still readable
+	b, _ := rsp.BodyBytes(true)
+	assert.Equal(t, "upstream down\n", string(b))
+
+	rsp = NewResponse(Request{})
+	rsp.StatusCode = http.StatusConflict
+	err = rsp.Err()
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, terrors.ErrBadRequest))
+	assert.Equal(t, "Conflict", err.(*terrors.Error).Message)
+}
+
 // rc is a helper type used in tests involving a generic io.ReadCloser
 type rc struct {
 	strings.Reader
@@ -350,3 +637,408 @@ func TestResponseEncodeReader(t *testing.T) {
 	require.NoError(t, err)
 	assert.Subset(t, body, []byte("hello"), "'hello' should appear in the wire format")
 }
+
+type writerTo struct {
+	chunks []string
+	err    error
+}
+
+func (w writerTo) WriteTo(dst io.Writer) (int64, error) {
+	n := int64(0)
+	for _, c := range w.chunks {
+		nw, err := io.WriteString(dst, c)
+		n += int64(nw)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, w.err
+}
+
+type sizedWriterTo struct {
+	writerTo
+}
+
+func (w sizedWriterTo) Len() int {
+	return len(strings.Join(w.chunks, ""))
+}
+
+func TestResponseEncodeWriterTo(t *testing.T) {
+	t.Parallel()
+
+	// An io.WriterTo is streamed into the body, with an unknown length
+	rsp := Response{}
+	rsp.Encode(writerTo{chunks: []string{"hello ", "world"}})
+	assert.Nil(t, rsp.Error)
+	assert.EqualValues(t, -1, rsp.ContentLength)
+	assert.Empty(t, rsp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Its length is used if known
+	rsp = Response{}
+	rsp.Encode(sizedWriterTo{writerTo{chunks: []string{"hello ", "world"}}})
+	assert.EqualValues(t, 11, rsp.ContentLength)
+	body, err = ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Errors from WriteTo are returned when reading the body
+	rsp = Response{}
+	rsp.Encode(writerTo{chunks: []string{"partial"}, err: errors.New("boom")})
+	body, err = ioutil.ReadAll(rsp.Body)
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, "partial", string(body))
+
+	// Closing the body early stops WriteTo
+	done := make(chan error, 1)
+	rsp = Response{}
+	rsp.Encode(writerToFunc(func(dst io.Writer) (int64, error) {
+		_, err := io.WriteString(dst, "never read")
+		done <- err
+		return 0, err
+	}))
+	require.NoError(t, rsp.Body.Close())
+	assert.Equal(t, io.ErrClosedPipe, <-done)
+
+	// The request side works the same way
+	req := NewRequest(context.Background(), "POST", "/", writerTo{chunks: []string{"hello"}})
+	assert.EqualValues(t, -1, req.ContentLength)
+	b, err := req.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+type writerToFunc func(io.Writer) (int64, error)
+
+func (f writerToFunc) WriteTo(w io.Writer) (int64, error) {
+	return f(w)
+}
+
+func TestResponseEncodeError(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	rsp := req.Response("partial body")
+	rsp.EncodeError(terrors.NotFound("widget", "No such widget", map[string]string{"id": "1"}))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	assert.Equal(t, "1", rsp.Header.Get("Terror"))
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	b, _ := rsp.BodyBytes(false)
+	assert.EqualValues(t, len(b), rsp.ContentLength)
+	assert.NotContains(t, string(b), "partial body")
+
+	// The error survives a round trip through ErrorFilter, which leaves the response alone
+	rsp = Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.EncodeError(terrors.NotFound("widget", "No such widget", map[string]string{"id": "1"}))
+		return rsp
+	}).Filter(ErrorFilter)(req)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	terr := rsp.Err().(*terrors.Error)
+	assert.Equal(t, "not_found.widget", terr.Code)
+	assert.Equal(t, "1", terr.Params["id"])
+
+	// Protobuf is used if the request accepts it, and plain errors are wrapped
+	req.Header.Set("Accept", "application/protobuf")
+	rsp = NewResponse(req)
+	rsp.EncodeError(errors.New("boom"))
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.Equal(t, "application/protobuf", rsp.Header.Get("Content-Type"))
+	assert.EqualError(t, rsp.Error, "boom")
+
+	// A nil error doesn't panic, and is treated as an internal error
+	rsp = NewResponse(req)
+	rsp.EncodeError(nil)
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, "internal_service.nil_error"))
+}
+
+// TestResponseEncodeProtobufMarshalError verifies that a failure to marshal a protobuf leaves the response in a clean
+// error state, with no partial body or misleading Content-Type
+func TestResponseEncodeProtobufMarshalError(t *testing.T) {
+	t.Parallel()
+
+	req := NewRequest(nil, "GET", "/", nil)
+	req.Header.Set("Accept", "application/protobuf")
+	rsp := NewResponse(req)
+	rsp.Write([]byte("partial"))
+	rsp.Encode(&prototest.Greeting{
+		Message: "\xff\xfe"}) // invalid UTF-8 can't be marshalled to a proto3 string
+	require.Error(t, rsp.Error)
+	assert.Empty(t, rsp.Header.Get("Content-Type"))
+	assert.EqualValues(t, 0, rsp.ContentLength)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Empty(t, b)
+
+	// ErrorFilter serialises the error
+	svc := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.Encode(&prototest.Greeting{
+			Message: "\xff\xfe"})
+		return rsp
+	}).Filter(ErrorFilter)
+	rsp = svc(req)
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.Equal(t, "1", rsp.Header.Get("Terror"))
+	require.Error(t, rsp.Err())
+	assert.True(t, terrors.Is(rsp.Err(), terrors.ErrInternalService))
+
+	// An encoding failure on a zero Response only sets its error
+	rsp = Response{}
+	assert.NotPanics(t, func() {
+		rsp.EncodeAsProtobuf(&prototest.Greeting{
+			Message: "\xff\xfe"})
+	})
+	require.Error(t, rsp.Error)
+}
+
+// TestResponseEncodeJSONMarshalError verifies that a failure to encode JSON is handled as protobuf failures are
+func TestResponseEncodeJSONMarshalError(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		rsp.Write([]byte("partial"))
+		rsp.Encode(map[string]interface{}{
+			"ch": make(chan int)})
+		require.Error(t, rsp.Error)
+		assert.Empty(t, rsp.Header.Get("Content-Type"))
+		return rsp
+	}).Filter(ErrorFilter)
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	assert.Equal(t, "1", rsp.Header.Get("Terror"))
+	require.Error(t, rsp.Err())
+	assert.True(t, terrors.Is(rsp.Err(), terrors.ErrInternalService))
+}
+
+func TestResponseDecodeArray(t *testing.T) {
+	t.Parallel()
+
+	rsp := NewResponse(Request{})
+	body := newDoneReader(ioutil.NopCloser(strings.NewReader(`[{"a":"1"}, {"a":"2"}, {"a":"3"}]`)), -1)
+	rsp.Body = body
+	var out []string
+	err := rsp.DecodeArray(func(decode func(interface{}) error) error {
+		v := map[string]string{}
+		if err := decode(&v); err != nil {
+			return err
+		}
+		out = append(out, v["a"])
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, out)
+	select {
+	case <-body.closed:
+	default:
+		assert.Fail(t, "response body was not closed after DecodeArray()")
+	}
+
+	// Elements which aren't decoded by the callback are skipped
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(strings.NewReader(`[1, [2, 3], {"b": 4}, 5]`))
+	n := 0
+	err = rsp.DecodeArray(func(decode func(interface{}) error) error {
+		n++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	// Callback errors stop decoding
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(strings.NewReader(`[1, 2, 3]`))
+	n = 0
+	stop := errors.New("stop")
+	err = rsp.DecodeArray(func(decode func(interface{}) error) error {
+		n++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, n)
+
+	// Non-arrays and truncated arrays are errors
+	for _, b := range []string{`{"a": 1}`, `[1, 2`, ``} {
+		rsp = NewResponse(Request{})
+		rsp.Body = ioutil.NopCloser(strings.NewReader(b))
+		err = rsp.DecodeArray(func(decode func(interface{}) error) error {
+			var v int
+			return decode(&v)
+		})
+		assert.Error(t, err, b)
+		assert.True(t, terrors.Is(err, terrors.ErrBadResponse), b)
+	}
+
+	// Error responses return their error
+	rsp = Response{Error: terrors.NotFound("foo", "not found", nil)}
+	err = rsp.DecodeArray(func(decode func(interface{}) error) error {
+		assert.Fail(t, "callback should not be called")
+		return nil
+	})
+	assert.Equal(t, rsp.Error, err)
+	err = (&Response{}).DecodeArray(nil)
+	assert.Error(t, err)
+}
+
+func TestResponse_WrapDownstreamErrorsPreservesDetails(t *testing.T) {
+	t.Parallel()
+
+	req := Request{}
+	req.Context = context.WithValue(context.Background(), WrapDownstreamErrors{}, "1")
+	rsp := NewResponse(req)
+	rsp.Error = terrors.NotFound("foo", "not found", map[string]string{
+		"id": "123"})
+	err := rsp.Decode(nil)
+	require.Error(t, err)
+
+	terr := err.(*terrors.Error)
+	assert.Equal(t, "internal_service.downstream", terr.Code)
+	assert.Equal(t, "Downstream request error", terr.Message)
+	assert.Equal(t, map[string]string{
+		"downstream.code":     "not_found.foo",
+		"downstream.message":  "not found",
+		"downstream.param.id": "123"}, terr.Params)
+	// The original error remains available as the cause
+	assert.True(t, terrors.Is(err, "not_found.foo"))
+}
+
+func TestResponseSetTrailer(t *testing.T) {
+	t.Parallel()
+
+	rsp := Response{}
+	rsp.DeclareTrailer("x-checksum", "X-Other")
+	rsp.DeclareTrailer("X-Checksum") // re-declaring is a no-op
+	assert.Equal(t, []string{"X-Checksum", "X-Other"}, rsp.Header["Trailer"])
+	rsp.SetTrailer("X-Checksum", "abc")
+	rsp.SetTrailer("X-Undeclared", "def")
+	assert.Equal(t, "abc", rsp.Trailer.Get("X-Checksum"))
+	assert.Equal(t, "def", rsp.Trailer.Get("X-Undeclared"))
+	assert.Equal(t, []string{"X-Checksum", "X-Other", "X-Undeclared"}, rsp.Header["Trailer"])
+}
+
+func TestResponseBodyReader(t *testing.T) {
+	t.Parallel()
+
+	compressed := &bytes.Buffer{}
+	gw := gzip.NewWriter(compressed)
+	gw.Write([]byte(`{"a":"b"}`))
+	gw.Close()
+
+	// Non-consuming reads decompress, and leave the raw body intact
+	rsp := NewResponse(Request{})
+	rsp.Header.Set("Content-Encoding", "gzip")
+	rsp.Body = &rc{*strings.NewReader(compressed.String()), 0}
+	for i := 0; i < 3; i++ {
+		rdr, err := rsp.BodyReader(false)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rdr)
+		require.NoError(t, err)
+		rdr.Close()
+		assert.Equal(t, `{"a":"b"}`, string(b))
+	}
+	assert.Equal(t, compressed.Bytes(), rsp.Body.(*bufCloser).Bytes())
+
+	// Decode decompresses too
+	v := map[string]string{}
+	require.NoError(t, rsp.Decode(&v))
+	assert.Equal(t, map[string]string{"a": "b"}, v)
+
+	// Bodies that aren't compressed are returned as-is
+	rsp = NewResponse(Request{})
+	rsp.Body = ioutil.NopCloser(strings.NewReader("plain"))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(b))
+
+	// Corrupt or unsupported encodings are errors
+	for _, enc := range []string{"gzip", "br"} {
+		rsp = NewResponse(Request{})
+		rsp.Header.Set("Content-Encoding", enc)
+		rsp.Body = ioutil.NopCloser(strings.NewReader("not compressed"))
+		_, err = rsp.BodyReader(true)
+		assert.True(t, terrors.Is(err, terrors.ErrBadResponse), enc)
+	}
+}
+
+func TestResponseWriterCapturesStatusAndBytes(t *testing.T) {
+	t.Parallel()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusTeapot) // superfluous; ignored
+		io.WriteString(w, "hello ")
+		io.WriteString(w, "world")
+	})
+	svc := Service(func(req Request) Response {
+		rsp := NewResponse(req)
+		handler.ServeHTTP(rsp.Writer(), &req.Request)
+		return rsp
+	})
+
+	var status int
+	var n int64
+	svc = svc.Filter(func(req Request, svc Service) Response {
+		rsp := svc(req)
+		status, n = rsp.WrittenStatus(), rsp.BytesWritten()
+		return rsp
+	})
+	rsp := svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, http.StatusCreated, status)
+	assert.EqualValues(t, 11, n)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+
+	// Writing without calling WriteHeader implies a 200
+	rsp = NewResponse(Request{})
+	rsp.Writer().Write([]byte("abc"))
+	assert.Equal(t, http.StatusOK, rsp.WrittenStatus())
+	assert.EqualValues(t, 3, rsp.BytesWritten())
+
+	// A response not written through its Writer reports nothing
+	rsp = NewResponse(Request{})
+	rsp.Write([]byte("abc"))
+	assert.Equal(t, 0, rsp.WrittenStatus())
+	assert.EqualValues(t, 0, rsp.BytesWritten())
+}
+
+func TestResponseHeaderMethods(t *testing.T) {
+	t.Parallel()
+
+	// A Response with no http.Response, as returned by many filters
+	rsp := Response{
+		Error: terrors.NotFound("thing", "No thing", nil)}
+	assert.Equal(t, "", rsp.GetHeader("X-Foo"))
+	assert.Nil(t, rsp.Response)
+	rsp.SetHeader("X-Foo", "a")
+	require.NotNil(t, rsp.Response)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "a", rsp.GetHeader("x-foo"))
+	rsp.AddHeader("X-Foo", "b")
+	assert.Equal(t, []string{"a", "b"}, rsp.Header["X-Foo"])
+	rsp.SetHeader("X-Foo", "c")
+	assert.Equal(t, []string{"c"}, rsp.Header["X-Foo"])
+
+	// AddHeader initialises a Response too, and copes with a nil header map
+	rsp = Response{}
+	rsp.AddHeader("X-Bar", "1")
+	assert.Equal(t, "1", rsp.GetHeader("X-Bar"))
+	rsp = Response{
+		Response: &http.Response{}}
+	rsp.SetHeader("X-Baz", "1")
+	assert.Equal(t, "1", rsp.GetHeader("X-Baz"))
+
+	// The error is untouched, so ErrorFilter still sets the right status
+	svc := Service(func(req Request) Response {
+		return Response{
+			Error: terrors.NotFound("thing", "No thing", nil)}
+	}).Filter(ErrorFilter).Filter(func(req Request, svc Service) Response {
+		rsp := svc(req)
+		rsp.SetHeader("X-Filtered", "1")
+		return rsp
+	})
+	rsp = svc(NewRequest(nil, "GET", "/", nil))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	assert.Equal(t, "1", rsp.GetHeader("X-Filtered"))
+}
@@ -24,10 +24,25 @@ func (rw responseWriterWrapper) Header() http.Header {
 }
 
 func (rw responseWriterWrapper) Write(b []byte) (int, error) {
-	return rw.r.Write(b)
+	n, err := rw.r.Write(b)
+	if rw.r.writtenStatus == 0 {
+		// Writing the body commits the status the response already has
+		rw.r.writtenStatus = rw.r.StatusCode
+	}
+	rw.r.bytesWritten += int64(n)
+	return n, err
 }
 
+// WriteHeader sets the response's status code. As with http.ResponseWriter, it has no effect once it has already been
+// called or the body has been written.
 func (rw responseWriterWrapper) WriteHeader(status int) {
+	if rw.r.writtenStatus != 0 {
+		return
+	}
+	if rw.r.Response == nil {
+		rw.r.Response = newHTTPResponse(Request{}, status)
+	}
+	rw.r.writtenStatus = status
 	rw.r.StatusCode = status
 }
 
@@ -48,3 +63,22 @@ func (rw hijackerRw) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	rw.r.hijacked = true
 	return rw.Hijacker.Hijack()
 }
+
+// pusherRw is the writer of a response to a request received over HTTP/2, which can push resources to the client
+type pusherRw struct {
+	responseWriterWrapper
+	http.Pusher
+}
+
+// WrittenStatus returns the status code written through the response's Writer: that passed to WriteHeader, or the
+// response's existing status (usually 200) if the body was written without calling it. It is 0 if the Writer hasn't
+// been used to write a response. This, with BytesWritten, lets filters log and measure responses from handlers which
+// use the http.ResponseWriter interface.
+func (r Response) WrittenStatus() int {
+	return r.writtenStatus
+}
+
+// BytesWritten returns the number of bytes of body written through the response's Writer.
+func (r Response) BytesWritten() int64 {
+	return r.bytesWritten
+}
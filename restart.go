@@ -0,0 +1,152 @@
+package typhon
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// InheritedListenerEnv is the environment variable through which Server.Restart tells the new process which file
+// descriptor holds the listener it inherits; see ListenInherited.
+const InheritedListenerEnv = "TYPHON_LISTENER_FD"
+
+// restartReadyEnv names the file descriptor to which the new process signals that it is serving.
+const restartReadyEnv = "TYPHON_RESTART_READY_FD"
+
+// ListenInherited starts a HTTP server on a listener inherited from the process which started this one with
+// Server.Restart, binding the passed Service to it, and tells that process it may begin draining. If the process
+// didn't inherit a listener, it is equivalent to Listen. This lets a service be restarted (eg. to swap its binary)
+// without refusing any connections, and without a load balancer in front of it.
+//
+// Only one listener can be inherited, so a process with several servers should use ListenInherited for just the one
+// which is restarted.
+func ListenInherited(svc Service, addr string, opts ...ServerOption) (*Server, error) {
+	fd := os.Getenv(InheritedListenerEnv)
+	if fd == "" {
+		return Listen(svc, addr, opts...)
+	}
+	// Processes this one starts mustn't think they inherit the listener too
+	os.Unsetenv(InheritedListenerEnv)
+	l, err := inheritedListener(fd)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Serve(svc, l, opts...)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if fd := os.Getenv(restartReadyEnv); fd != "" {
+		os.Unsetenv(restartReadyEnv)
+		if n, err := strconv.Atoi(fd); err == nil {
+			f := os.NewFile(uintptr(n), "typhon-restart-ready")
+			f.Write([]byte{1})
+			f.Close()
+		}
+	}
+	return s, nil
+}
+
+func inheritedListener(fd string) (net.Listener, error) {
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, terrors.InternalService("invalid_listener_fd", "Invalid inherited listener file descriptor",
+			map[string]string{
+				"fd": fd})
+	}
+	f := os.NewFile(uintptr(n), "typhon-listener")
+	defer f.Close() // FileListener duplicates the descriptor
+	return net.FileListener(f)
+}
+
+// Restart hands the server's listener over to a new process, started by cmd (usually a new version of the same
+// binary), which should serve it with ListenInherited. Once the new process signals that it is serving, this server
+// is drained as Drain does, using the passed grace period, and Restart returns; the caller should then exit. Until
+// then, both processes accept connections from the shared listener, so none are refused.
+//
+// cmd is started by Restart; its environment (the current one if unset) and ExtraFiles are extended to pass the
+// listener. If the new process exits without signalling, or ctx expires first (in which case the process is killed),
+// Restart returns an error and this server carries on serving. Listener handoff is only supported on Unix, and only for
+// TCP and Unix socket listeners.
+func (s *Server) Restart(ctx context.Context, cmd *exec.Cmd, grace time.Duration) error {
+	filer, ok := s.baseListener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return terrors.InternalService("unsupported_listener", "Listener can't be handed over", nil)
+	}
+	lf, err := filer.File()
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	defer lf.Close()
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	defer readyR.Close()
+
+	// Descriptors 0-2 are stdio, followed by ExtraFiles in order
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, lf, readyW)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		InheritedListenerEnv+"="+strconv.Itoa(fd),
+		restartReadyEnv+"="+strconv.Itoa(fd+1))
+	err = cmd.Start()
+	// Only the new process should hold the write end, so that reads fail if it exits without signalling
+	readyW.Close()
+	// Passing the listener made it blocking (even if the process couldn't be started), so restore it
+	nbErr := setNonblock(lf)
+	if err != nil {
+		return terrors.Wrap(err, nil)
+	}
+	if nbErr != nil {
+		// This process can't go on serving reliably, and the new one mustn't take over without it draining
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return terrors.Wrap(nbErr, nil)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			go cmd.Wait()
+			return terrors.InternalService("restart_failed", "New process exited before serving", map[string]string{
+				"pid": strconv.Itoa(cmd.Process.Pid)})
+		}
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return terrors.Wrap(ctx.Err(), nil)
+	}
+
+	s.Drain(ctx, grace)
+	return nil
+}
+
+// ListenReusePort starts a HTTP server listening on the passed address with SO_REUSEPORT set, binding the passed
+// Service to it. Several processes can then listen on the same address, with the kernel spreading connections between
+// them, so a new process can be started alongside an old one which is then drained (see Server.Drain). Unlike
+// Restart, this needs no cooperation between the processes, but on Linux connections queued for a listener which
+// closes are dropped. It is supported on Linux and macOS.
+func ListenReusePort(svc Service, addr string, opts ...ServerOption) (*Server, error) {
+	lc := net.ListenConfig{
+		Control: reusePortControl}
+	l, err := lc.Listen(context.Background(), "tcp", listenAddr(addr))
+	if err != nil {
+		return nil, err
+	}
+	return Serve(svc, l, opts...)
+}
@@ -0,0 +1,89 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const restartTestChildEnv = "TYPHON_TEST_RESTART_CHILD"
+
+func TestServerRestart(t *testing.T) {
+	if os.Getenv(restartTestChildEnv) != "" {
+		// This is the new process, started by the test below
+		_, err := ListenInherited(Service(func(req Request) Response {
+			return req.Response("new")
+		}), "")
+		require.NoError(t, err)
+		time.Sleep(30 * time.Second) // until killed
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("listener handoff is not supported on Windows")
+	}
+
+	s, err := Listen(Service(func(req Request) Response {
+		return req.Response("old")
+	}), "localhost:0")
+	require.NoError(t, err)
+	url := "http://" + s.Listener().Addr().String()
+	// Connections to the old process are closed when it drains, so don't reuse them
+	client := HttpService(&http.Transport{
+		DisableKeepAlives: true}).Filter(ErrorFilter)
+	body := func() string {
+		rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(client).Response()
+		require.NoError(t, rsp.Error)
+		var b string
+		require.NoError(t, rsp.Decode(&b))
+		return b
+	}
+	assert.Equal(t, "old", body())
+
+	// A new process which fails to start leaves the server serving
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = s.Restart(ctx, exec.Command(os.Args[0], "-test.run=^$"), 0)
+	require.Error(t, err)
+	assert.True(t, terrors.Is(err, "internal_service.restart_failed"))
+	assert.Equal(t, "old", body())
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestServerRestart$")
+	cmd.Env = append(os.Environ(), restartTestChildEnv+"=1")
+	require.NoError(t, s.Restart(ctx, cmd, 0))
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	<-s.Done()
+	// The listener's address is now served by the new process
+	assert.Equal(t, "new", body())
+}
+
+func TestListenReusePort(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("SO_REUSEPORT is not supported on this platform")
+	}
+	svc := Service(func(req Request) Response {
+		return req.Response("ok")
+	})
+	s1, err := ListenReusePort(svc, "localhost:0")
+	require.NoError(t, err)
+	defer s1.Stop(context.Background())
+	s2, err := ListenReusePort(svc, s1.Listener().Addr().String())
+	require.NoError(t, err)
+	defer s2.Stop(context.Background())
+	assert.Equal(t, s1.Listener().Addr().String(), s2.Listener().Addr().String())
+
+	// Without SO_REUSEPORT, the address is in use
+	_, err = Listen(svc, s1.Listener().Addr().String())
+	assert.Error(t, err)
+}
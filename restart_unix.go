@@ -0,0 +1,25 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package typhon
+
+import (
+	"os"
+	"syscall"
+)
+
+// setNonblock puts the file into non-blocking mode. Passing a file to a child process puts it into blocking mode, and
+// the mode is shared with the descriptors it was duplicated from, such as that of a listener which is still in use.
+func setNonblock(f *os.File) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetNonblock(int(fd), true)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,33 @@
+package typhon
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryAfter returns how long the server asked the client to wait before retrying, from the response's Retry-After
+// header (typically sent with 429 or 503 responses). Both the delta-seconds and HTTP-date forms are understood; a
+// date in the past yields zero. ok is false if the header is absent or unparseable, in which case the caller should
+// fall back to its own backoff. Callers should cap the delay, as it is chosen by the server.
+func (r Response) RetryAfter() (d time.Duration, ok bool) {
+	if r.Response == nil {
+		return 0, false
+	}
+	v := strings.TrimSpace(r.Header.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseUint(v, 10, 32); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d = time.Until(t); d < 0 {
+		d = 0
+	}
+	return d, true
+}
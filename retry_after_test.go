@@ -0,0 +1,41 @@
+package typhon
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	rsp := NewResponseWithCode(Request{}, http.StatusTooManyRequests)
+	_, ok := rsp.RetryAfter()
+	assert.False(t, ok)
+
+	rsp.Header.Set("Retry-After", "120")
+	d, ok := rsp.RetryAfter()
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, d)
+
+	rsp.Header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	d, ok = rsp.RetryAfter()
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour, d, float64(2*time.Second))
+
+	rsp.Header.Set("Retry-After", "Wed, 21 Oct 2015 07:28:00 GMT")
+	d, ok = rsp.RetryAfter()
+	assert.True(t, ok)
+	assert.Zero(t, d)
+
+	for _, v := range []string{"soon", "-1", "1.5"} {
+		rsp.Header.Set("Retry-After", v)
+		_, ok = rsp.RetryAfter()
+		assert.False(t, ok, v)
+	}
+
+	_, ok = Response{}.RetryAfter()
+	assert.False(t, ok)
+}
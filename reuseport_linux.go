@@ -0,0 +1,23 @@
+package typhon
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// reusePortControl sets SO_REUSEPORT on a socket before it is bound.
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	// Package syscall doesn't define SO_REUSEPORT on Linux, where its value depends on the architecture
+	soReusePort := 0xf
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "sparc64":
+		soReusePort = 0x200
+	}
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package typhon
+
+import (
+	"syscall"
+
+	"github.com/monzo/terrors"
+)
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return terrors.InternalService("unsupported", "SO_REUSEPORT is not supported on this platform", nil)
+}
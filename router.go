@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/monzo/terrors"
 )
@@ -13,17 +14,34 @@ import (
 // directly means we'd get a collision with any other package that does the same.
 // https://play.golang.org/p/MxhRiL37R-9
 type routerContextKeyType struct{}
+type routeTemplateContextKeyType struct{}
 
 var (
-	routerContextKey   = routerContextKeyType{}
-	routerComponentsRe = regexp.MustCompile(`(?:^|/)(\*\w*|:\w+)`)
+	routerContextKey        = routerContextKeyType{}
+	routeTemplateContextKey = routeTemplateContextKeyType{}
+	routerComponentsRe      = regexp.MustCompile(`(?:^|/)(\*\w*|:\w+)`)
 )
 
 type routerEntry struct {
-	Method  string
-	Pattern string
-	Service Service
-	re      *regexp.Regexp
+	Method      string
+	Pattern     string
+	Service     Service
+	re          *regexp.Regexp
+	timeout     time.Duration
+	maxBodySize int64
+}
+
+// A RouteOption customises the behaviour of a single route registered with a Router.
+type RouteOption func(*routerEntry)
+
+// WithRouteTimeout applies a deadline to requests dispatched to the route: their context is cancelled if handling them
+// takes longer than d. This lets slow routes have a longer deadline than others (or vice-versa): if the request has
+// passed through a TimeoutFilter whose deadline hasn't yet been fixed, d replaces its timeout (and is measured from
+// when the request reached it).
+func WithRouteTimeout(d time.Duration) RouteOption {
+	return func(e *routerEntry) {
+		e.timeout = d
+	}
 }
 
 func (e routerEntry) String() string {
@@ -33,7 +51,14 @@ func (e routerEntry) String() string {
 // A Router multiplexes requests to a set of Services by pattern matching on method and path, and can also extract
 // parameters from paths.
 type Router struct {
-	entries []routerEntry
+	// NotFound, if set, handles requests which match no route, in place of the default 404 (Not Found) error. It
+	// could, for example, log the attempted path and return a branded error.
+	NotFound Service
+	// MethodNotAllowed, if set, handles requests whose path matches a route but whose method does not. By default these
+	// are treated like any other unmatched request. The Allow header is set on the response (if it has one) to list the
+	// methods which are routed for the path, unless the Service has set it.
+	MethodNotAllowed Service
+	entries          []routerEntry
 }
 
 // RouterForRequest returns a pointer to the Router that successfully dispatched the request, or nil.
@@ -44,6 +69,19 @@ func RouterForRequest(r Request) *Router {
 	return nil
 }
 
+// RouteTemplate returns the pattern of the route which dispatched the request (eg. "/users/:id"), or an empty string
+// if the request was not dispatched by a Router. Unlike the request path, this has low cardinality so it is suitable
+// for use as a label in metrics and traces.
+func RouteTemplate(r Request) string {
+	if r.Context == nil {
+		return ""
+	}
+	if v, ok := r.Context.Value(routeTemplateContextKey).(string); ok {
+		return v
+	}
+	return ""
+}
+
 func (r *Router) compile(pattern string) *regexp.Regexp {
 	re, pos := ``, 0
 	for _, m := range routerComponentsRe.FindAllStringSubmatchIndex(pattern, -1) {
@@ -77,13 +115,25 @@ func (r *Router) compile(pattern string) *regexp.Regexp {
 // runtime, or *residual components which match (potentially) multiple path components.
 //
 // In the case that patterns are ambiguous, the last route to be registered will take precedence.
-func (r *Router) Register(method, pattern string, svc Service) {
+//
+// RouteOptions can be passed to customise the behaviour of the route.
+func (r *Router) Register(method, pattern string, svc Service, opts ...RouteOption) {
 	re := r.compile(pattern)
-	r.entries = append(r.entries, routerEntry{
+	e := routerEntry{
 		Method:  strings.ToUpper(method),
 		Pattern: pattern,
 		Service: svc,
-		re:      re})
+		re:      re}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	if e.timeout > 0 {
+		e.Service = e.Service.Filter(routeTimeoutFilter(e.timeout))
+	}
+	if e.maxBodySize > 0 {
+		e.Service = e.Service.Filter(routeBodySizeFilter(e.maxBodySize))
+	}
+	r.entries = append(r.entries, e)
 }
 
 // lookup is the internal version of Lookup, but it extracts path parameters into the passed map (and skips it if the
@@ -116,14 +166,27 @@ func (r Router) Lookup(method, path string) (Service, string, map[string]string,
 // Serve returns a Service which will route inbound requests to the enclosed routes.
 func (r Router) Serve() Service {
 	return func(req Request) Response {
-		svc, _, ok := r.lookup(req.Method, req.URL.Path, nil)
+		svc, pattern, ok := r.lookup(req.Method, req.URL.Path, nil)
 		if !ok {
+			if r.MethodNotAllowed != nil {
+				if methods := r.Methods(req.URL.Path); len(methods) > 0 {
+					rsp := r.MethodNotAllowed(req)
+					if rsp.Response != nil && rsp.Header.Get("Allow") == "" {
+						rsp.Header.Set("Allow", strings.Join(methods, ", "))
+					}
+					return rsp
+				}
+			}
+			if r.NotFound != nil {
+				return r.NotFound(req)
+			}
 			txt := fmt.Sprintf("No handler for %s %s", req.Method, req.URL.Path)
 			rsp := NewResponse(req)
 			rsp.Error = terrors.NotFound("no_handler", txt, nil)
 			return rsp
 		}
 		req.Context = context.WithValue(req.Context, routerContextKey, &r)
+		req.Context = context.WithValue(req.Context, routeTemplateContextKey, pattern)
 		rsp := svc(req)
 		if rsp.Request == nil {
 			rsp.Request = &req
@@ -132,6 +195,20 @@ func (r Router) Serve() Service {
 	}
 }
 
+// Methods returns the HTTP methods which are routed for the path, in the order they were registered. If a route
+// matches any method, it contains "*".
+func (r Router) Methods(path string) []string {
+	var methods []string
+	seen := map[string]bool{}
+	for _, e := range r.entries {
+		if !seen[e.Method] && e.re.MatchString(path) {
+			seen[e.Method] = true
+			methods = append(methods, e.Method)
+		}
+	}
+	return methods
+}
+
 // Pattern returns the registered pattern which matches the given request.
 func (r Router) Pattern(req Request) string {
 	_, pattern, _ := r.lookup(req.Method, req.URL.Path, nil)
@@ -147,37 +224,55 @@ func (r Router) Params(req Request) map[string]string {
 // Sugar
 
 // GET is shorthand for:
-//  r.Register("GET", pattern, svc)
-func (r *Router) GET(pattern string, svc Service) { r.Register("GET", pattern, svc) }
+//  r.Register("GET", pattern, svc, opts...)
+func (r *Router) GET(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("GET", pattern, svc, opts...)
+}
 
 // CONNECT is shorthand for:
-//  r.Register("CONNECT", pattern, svc)
-func (r *Router) CONNECT(pattern string, svc Service) { r.Register("CONNECT", pattern, svc) }
+//  r.Register("CONNECT", pattern, svc, opts...)
+func (r *Router) CONNECT(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("CONNECT", pattern, svc, opts...)
+}
 
 // DELETE is shorthand for:
-//  r.Register("DELETE", pattern, svc)
-func (r *Router) DELETE(pattern string, svc Service) { r.Register("DELETE", pattern, svc) }
+//  r.Register("DELETE", pattern, svc, opts...)
+func (r *Router) DELETE(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("DELETE", pattern, svc, opts...)
+}
 
 // HEAD is shorthand for:
-//  r.Register("HEAD", pattern, svc)
-func (r *Router) HEAD(pattern string, svc Service) { r.Register("HEAD", pattern, svc) }
+//  r.Register("HEAD", pattern, svc, opts...)
+func (r *Router) HEAD(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("HEAD", pattern, svc, opts...)
+}
 
 // OPTIONS is shorthand for:
-//  r.Register("OPTIONS", pattern, svc)
-func (r *Router) OPTIONS(pattern string, svc Service) { r.Register("OPTIONS", pattern, svc) }
+//  r.Register("OPTIONS", pattern, svc, opts...)
+func (r *Router) OPTIONS(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("OPTIONS", pattern, svc, opts...)
+}
 
 // PATCH is shorthand for:
-//  r.Register("PATCH", pattern, svc)
-func (r *Router) PATCH(pattern string, svc Service) { r.Register("PATCH", pattern, svc) }
+//  r.Register("PATCH", pattern, svc, opts...)
+func (r *Router) PATCH(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("PATCH", pattern, svc, opts...)
+}
 
 // POST is shorthand for:
-//  r.Register("POST", pattern, svc)
-func (r *Router) POST(pattern string, svc Service) { r.Register("POST", pattern, svc) }
+//  r.Register("POST", pattern, svc, opts...)
+func (r *Router) POST(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("POST", pattern, svc, opts...)
+}
 
 // PUT is shorthand for:
-//  r.Register("PUT", pattern, svc)
-func (r *Router) PUT(pattern string, svc Service) { r.Register("PUT", pattern, svc) }
+//  r.Register("PUT", pattern, svc, opts...)
+func (r *Router) PUT(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("PUT", pattern, svc, opts...)
+}
 
 // TRACE is shorthand for:
-//  r.Register("TRACE", pattern, svc)
-func (r *Router) TRACE(pattern string, svc Service) { r.Register("TRACE", pattern, svc) }
+//  r.Register("TRACE", pattern, svc, opts...)
+func (r *Router) TRACE(pattern string, svc Service, opts ...RouteOption) {
+	r.Register("TRACE", pattern, svc, opts...)
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/monzo/terrors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -126,3 +129,148 @@ func TestRouterSetsRequest(t *testing.T) {
 	req.Context = rsp.Request.Context
 	assert.Equal(t, req, *rsp.Request)
 }
+
+func TestRouteTemplate(t *testing.T) {
+	t.Parallel()
+
+	router := Router{}
+	var template string
+	router.GET("/users/:id", func(req Request) Response {
+		template = RouteTemplate(req)
+		return req.Response(nil)
+	})
+
+	ctx := context.Background()
+	router.Serve()(NewRequest(ctx, "GET", "/users/123", nil))
+	assert.Equal(t, "/users/:id", template)
+
+	// Unmatched and un-routed requests have no template
+	rsp := router.Serve()(NewRequest(ctx, "GET", "/nope", nil))
+	assert.Equal(t, "", RouteTemplate(*rsp.Request))
+	assert.Equal(t, "", RouteTemplate(NewRequest(ctx, "GET", "/users/123", nil)))
+}
+
+func TestRouterRouteTimeout(t *testing.T) {
+	t.Parallel()
+
+	router := Router{}
+	deadlines := map[string]time.Time{}
+	svc := func(req Request) Response {
+		deadlines[req.URL.Path], _ = req.Deadline()
+		return req.Response(nil)
+	}
+	router.GET("/fast", svc, WithRouteTimeout(time.Second))
+	router.GET("/slow", svc, WithRouteTimeout(time.Minute))
+	router.GET("/none", svc)
+
+	ctx := context.Background()
+	before := time.Now()
+	for _, p := range []string{"/fast", "/slow", "/none"} {
+		rsp := router.Serve()(NewRequest(ctx, "GET", p, nil))
+		require.NoError(t, rsp.Error)
+	}
+	assert.WithinDuration(t, before.Add(time.Second), deadlines["/fast"], 500*time.Millisecond)
+	assert.WithinDuration(t, before.Add(time.Minute), deadlines["/slow"], 500*time.Millisecond)
+	assert.True(t, deadlines["/none"].IsZero())
+}
+
+func TestRouterRouteTimeoutOverridesTimeoutFilter(t *testing.T) {
+	t.Parallel()
+
+	router := Router{}
+	deadlines := map[string]time.Time{}
+	var mtx sync.Mutex
+	svc := func(req Request) Response {
+		mtx.Lock()
+		deadlines[req.URL.Path], _ = req.Deadline()
+		mtx.Unlock()
+		if req.URL.Path == "/fast" {
+			// The deadline is observed by contexts derived from the request's
+			ctx, cancel := context.WithCancel(req.Context)
+			defer cancel()
+			select {
+			case <-ctx.Done():
+				assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+			case <-time.After(time.Second):
+				assert.Fail(t, "request context was not cancelled at the route's deadline")
+			}
+		}
+		return req.Response(nil)
+	}
+	router.GET("/fast", svc, WithRouteTimeout(10*time.Millisecond))
+	router.GET("/slow", svc, WithRouteTimeout(time.Hour))
+	router.GET("/none", svc)
+	routed := router.Serve().Filter(TimeoutFilter(time.Minute))
+
+	before := time.Now()
+	for _, p := range []string{"/fast", "/slow", "/none"} {
+		rsp := routed(NewRequest(context.Background(), "GET", p, nil))
+		require.NoError(t, rsp.Error)
+	}
+	assert.WithinDuration(t, before.Add(10*time.Millisecond), deadlines["/fast"], 500*time.Millisecond)
+	assert.WithinDuration(t, before.Add(time.Hour), deadlines["/slow"], 500*time.Millisecond)
+	assert.WithinDuration(t, before.Add(time.Minute), deadlines["/none"], 500*time.Millisecond)
+
+	// An earlier deadline on the incoming request still applies
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	rsp := routed(NewRequest(ctx, "GET", "/slow", nil))
+	require.NoError(t, rsp.Error)
+	parent, _ := ctx.Deadline()
+	assert.Equal(t, parent, deadlines["/slow"])
+
+	// Once a filter has used the context, its deadline is fixed, so a route can't extend it
+	var observed time.Time
+	observing := Filter(func(req Request, svc Service) Response {
+		observed, _ = req.Deadline()
+		return svc(req)
+	})
+	routed = router.Serve().Filter(observing).Filter(TimeoutFilter(time.Minute))
+	rsp = routed(NewRequest(context.Background(), "GET", "/slow", nil))
+	require.NoError(t, rsp.Error)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), observed, 500*time.Millisecond)
+	assert.Equal(t, observed, deadlines["/slow"])
+}
+
+func TestRouterCustomHandlers(t *testing.T) {
+	t.Parallel()
+
+	router := Router{}
+	router.GET("/foo/:id", func(req Request) Response {
+		return req.Response("foo")
+	})
+	router.DELETE("/foo/:id", func(req Request) Response {
+		return req.Response("deleted")
+	})
+	svc := router.Serve().Filter(ErrorFilter)
+
+	// By default unmatched methods are treated as not found
+	rsp := svc(NewRequest(context.Background(), "POST", "/foo/1", nil))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	assert.Equal(t, []string{"GET", "DELETE"}, router.Methods("/foo/1"))
+	assert.Empty(t, router.Methods("/bar"))
+
+	var notFoundPath string
+	router.NotFound = func(req Request) Response {
+		notFoundPath = req.URL.Path
+		rsp := NewResponse(req)
+		rsp.Error = terrors.NotFound("route", "Nothing to see here", nil)
+		return rsp
+	}
+	router.MethodNotAllowed = func(req Request) Response {
+		return req.ResponseWithCode(map[string]string{"error": "method not allowed"}, http.StatusMethodNotAllowed)
+	}
+	svc = router.Serve().Filter(ErrorFilter)
+
+	rsp = svc(NewRequest(context.Background(), "GET", "/bar", nil))
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, "not_found.route"))
+	assert.Equal(t, "/bar", notFoundPath)
+
+	rsp = svc(NewRequest(context.Background(), "POST", "/foo/1", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	assert.Equal(t, "GET, DELETE", rsp.Header.Get("Allow"))
+
+	rsp = svc(NewRequest(context.Background(), "GET", "/foo/1", nil))
+	require.NoError(t, rsp.Error)
+}
@@ -0,0 +1,143 @@
+package typhon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// RPCStatusMediaType is the media type which a client can include in its Accept header to receive errors as
+// google.rpc.Status messages (see Response.EncodeAsRPCStatus) rather than serialised terrors.
+const RPCStatusMediaType = "application/x-google-rpc-status+json"
+
+// RetryDelayParam is the terror param which carries how long a client should wait before retrying (in the form
+// accepted by time.ParseDuration). It is reported in google.rpc.Status errors as a RetryInfo detail.
+const RetryDelayParam = "retry_delay"
+
+var (
+	// RPCErrorDomain is the domain of the ErrorInfo details of google.rpc.Status errors: the logical name of the
+	// service which generated them (eg. "pubsub.googleapis.com"). It can be overridden globally but MUST only be done
+	// before use takes place; access is not synchronised.
+	RPCErrorDomain = ""
+
+	// terrorGRPCCodes maps terrors codes to the closest gRPC status codes.
+	// See: https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+	terrorGRPCCodes = map[string]int{
+		ErrClientClosedRequest:         1,  // CANCELLED
+		terrors.ErrBadRequest:          3,  // INVALID_ARGUMENT
+		terrors.ErrTimeout:             4,  // DEADLINE_EXCEEDED
+		terrors.ErrNotFound:            5,  // NOT_FOUND
+		terrors.ErrForbidden:           7,  // PERMISSION_DENIED
+		terrors.ErrRateLimited:         8,  // RESOURCE_EXHAUSTED
+		ErrRequestEntityTooLarge:       8,  // RESOURCE_EXHAUSTED
+		ErrRequestHeaderFieldsTooLarge: 8,  // RESOURCE_EXHAUSTED
+		terrors.ErrPreconditionFailed:  9,  // FAILED_PRECONDITION
+		ErrRangeNotSatisfiable:         11, // OUT_OF_RANGE
+		ErrUnsupportedMediaType:        12, // UNIMPLEMENTED
+		terrors.ErrInternalService:     13, // INTERNAL
+		ErrServiceUnavailable:          14, // UNAVAILABLE
+		ErrBadGateway:                  14, // UNAVAILABLE
+		terrors.ErrUnauthorized:        16} // UNAUTHENTICATED
+)
+
+// rpcStatus is the JSON form of a google.rpc.Status message, as produced by protojson.
+type rpcStatus struct {
+	Code    int           `json:"code,omitempty"`
+	Message string        `json:"message,omitempty"`
+	Details []interface{} `json:"details,omitempty"`
+}
+
+// rpcErrorInfo is the JSON form of a google.rpc.ErrorInfo message, packed in an Any.
+type rpcErrorInfo struct {
+	Type     string            `json:"@type"`
+	Reason   string            `json:"reason,omitempty"`
+	Domain   string            `json:"domain,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// rpcRetryInfo is the JSON form of a google.rpc.RetryInfo message, packed in an Any.
+type rpcRetryInfo struct {
+	Type       string `json:"@type"`
+	RetryDelay string `json:"retryDelay"`
+}
+
+// EncodeAsRPCStatus writes the passed error to the response as a google.rpc.Status message in its JSON form (as
+// protojson would produce), for consumers of gRPC-style errors such as gRPC-web and gRPC-gateway clients. The error's
+// code is mapped to the closest gRPC status code, and it is described by an ErrorInfo detail whose reason is the
+// terror code, whose domain is RPCErrorDomain and whose metadata are the error's params. If the error has a
+// RetryDelayParam, it is reported in a RetryInfo detail instead.
+//
+// EncodeError (and so ErrorFilter) uses this for requests which accept RPCStatusMediaType.
+func (r *Response) EncodeAsRPCStatus(err error) {
+	terr := asTerror(err)
+	code, ok := terrorGRPCCodes[strings.SplitN(terr.Code, ".", 2)[0]]
+	if !ok {
+		code = 2 // UNKNOWN
+	}
+	status := rpcStatus{
+		Code:    code,
+		Message: terr.Message}
+
+	info := rpcErrorInfo{
+		Type:   "type.googleapis.com/google.rpc.ErrorInfo",
+		Reason: terr.Code,
+		Domain: RPCErrorDomain}
+	var retryDelay string
+	for k, v := range terr.Params {
+		if k == RetryDelayParam {
+			if d, err := time.ParseDuration(v); err == nil {
+				retryDelay = formatProtoDuration(d)
+				continue
+			}
+		}
+		if info.Metadata == nil {
+			info.Metadata = make(map[string]string, len(terr.Params))
+		}
+		info.Metadata[k] = v
+	}
+	status.Details = append(status.Details, info)
+	if retryDelay != "" {
+		status.Details = append(status.Details, rpcRetryInfo{
+			Type:       "type.googleapis.com/google.rpc.RetryInfo",
+			RetryDelay: retryDelay})
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		r.encodeFailed(err)
+		return
+	}
+	n, err := r.Write(b)
+	if err != nil {
+		r.encodeFailed(err)
+		return
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.ContentLength = int64(n)
+}
+
+// encodesRPCStatus returns whether errors in response to the request should be encoded as google.rpc.Status messages.
+func encodesRPCStatus(req *Request) bool {
+	return req != nil && strings.Contains(req.Header.Get("Accept"), RPCStatusMediaType)
+}
+
+// formatProtoDuration formats a duration as protojson does for google.protobuf.Duration: in seconds, with 0, 3, 6 or 9
+// fractional digits and an "s" suffix.
+func formatProtoDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	s := sign + strconv.FormatInt(int64(d/time.Second), 10)
+	if nanos := int64(d % time.Second); nanos != 0 {
+		frac := fmt.Sprintf("%09d", nanos)
+		frac = strings.TrimSuffix(frac, "000")
+		frac = strings.TrimSuffix(frac, "000")
+		s += "." + frac
+	}
+	return s + "s"
+}
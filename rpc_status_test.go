@@ -0,0 +1,72 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseEncodeAsRPCStatus(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		return Response{
+			Error: terrors.RateLimited("too_many_widgets", "Slow down", map[string]string{
+				"widget":        "w1",
+				RetryDelayParam: "1.5s"})}
+	}).Filter(ErrorFilter)
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept", RPCStatusMediaType)
+	rsp := svc(req)
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	assert.Empty(t, rsp.Header.Get("Terror"))
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"code": 8,
+		"message": "Slow down",
+		"details": [
+			{
+				"@type": "type.googleapis.com/google.rpc.ErrorInfo",
+				"reason": "rate_limited.too_many_widgets",
+				"metadata": {"widget": "w1"}
+			},
+			{
+				"@type": "type.googleapis.com/google.rpc.RetryInfo",
+				"retryDelay": "1.500s"
+			}
+		]
+	}`, string(b))
+
+	// Other clients still get terrors
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, "1", rsp.Header.Get("Terror"))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrRateLimited))
+
+	// A nil error doesn't panic
+	rsp = NewResponse(Request{})
+	rsp.EncodeAsRPCStatus(nil)
+	b, err = rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"code":13`)
+}
+
+func TestFormatProtoDuration(t *testing.T) {
+	t.Parallel()
+	for d, s := range map[time.Duration]string{
+		0:                                 "0s",
+		time.Second:                       "1s",
+		1500 * time.Millisecond:           "1.500s",
+		time.Second + time.Microsecond:    "1.000001s",
+		time.Second + time.Nanosecond:     "1.000000001s",
+		-2*time.Second - time.Millisecond: "-2.001s"} {
+		assert.Equal(t, s, formatProtoDuration(d), d.String())
+	}
+}
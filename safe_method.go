@@ -0,0 +1,50 @@
+package typhon
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// mutatingStatuses are the response statuses which indicate that a request has changed (or begun changing) state on
+// the server.
+var mutatingStatuses = map[int]bool{
+	http.StatusCreated:  true,
+	http.StatusAccepted: true}
+
+// SafeMethodFilter returns a Filter which catches handlers that have side effects in response to requests with safe
+// methods (GET, HEAD and OPTIONS), which clients, caches and proxies are entitled to send speculatively or repeat. A
+// response to such a request with a status indicating a change of state (201 Created or 202 Accepted) is logged as a
+// warning and, if strict is set, replaced with a 500 (Internal Server Error) error so the bug can't go unnoticed.
+//
+// This is a development-time guardrail for teams enforcing REST semantics, and is strictly opt-in.
+func SafeMethodFilter(strict bool) Filter {
+	return func(req Request, svc Service) Response {
+		rsp := svc(req)
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			return rsp
+		}
+		if rsp.Error != nil || rsp.Response == nil || !mutatingStatuses[rsp.StatusCode] {
+			return rsp
+		}
+
+		slog.Warn(req, "Handler for %s %s responded %d, indicating a side effect of a safe method", req.Method,
+			req.URL.Path, rsp.StatusCode)
+		if !strict {
+			return rsp
+		}
+		if rsp.Body != nil {
+			rsp.Body.Close()
+		}
+		return Response{
+			Request: &req,
+			Error: terrors.InternalService("unsafe_side_effect", "Handler responded to a safe method with "+
+				http.StatusText(rsp.StatusCode), map[string]string{
+				"method": req.Method,
+				"status": strconv.Itoa(rsp.StatusCode)})}
+	}
+}
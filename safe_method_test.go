@@ -0,0 +1,42 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeMethodFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		return NewResponseWithCode(req, http.StatusCreated)
+	})
+	ctx := context.Background()
+
+	// Outside strict mode, the response is only logged
+	rsp := svc.Filter(SafeMethodFilter(false))(NewRequest(ctx, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+
+	strict := svc.Filter(SafeMethodFilter(true)).Filter(ErrorFilter)
+	for _, method := range []string{"GET", "HEAD", "OPTIONS"} {
+		rsp = strict(NewRequest(ctx, method, "/", nil))
+		assert.Equal(t, http.StatusInternalServerError, rsp.StatusCode, method)
+		assert.True(t, terrors.Is(rsp.Error, "internal_service.unsafe_side_effect"), method)
+	}
+
+	// Unsafe methods may create things
+	rsp = strict(NewRequest(ctx, "POST", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+
+	// Other statuses are fine
+	rsp = Service(func(req Request) Response {
+		return NewResponseWithCode(req, http.StatusNoContent)
+	}).Filter(SafeMethodFilter(true))(NewRequest(ctx, "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+}
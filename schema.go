@@ -0,0 +1,400 @@
+package typhon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
+)
+
+// ResponseSchemaValidation enables the Filters returned by JSONSchema.ResponseFilter. It is off by default, so that
+// production services don't pay for validating every response; turn it on in tests (eg. from TestMain) or in
+// development environments. It MUST only be modified before use takes place; access is not synchronised.
+var ResponseSchemaValidation = false
+
+// A JSONSchema is a compiled JSON Schema, which can validate JSON documents. Create one with CompileJSONSchema.
+//
+// The commonly-used validation keywords are supported: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum, exclusiveMinimum
+// and exclusiveMaximum (as numbers), along with the annotations $schema, $id, $comment, title, description, default
+// and examples. Other keywords (eg. $ref, allOf and format) are rejected when the schema is compiled, as a schema which
+// relies on them would otherwise accept documents it is meant to reject.
+type JSONSchema struct {
+	root *schemaNode
+}
+
+type schemaNode struct {
+	Type                 schemaTypes            `json:"type"`
+	Enum                 []interface{}          `json:"enum"`
+	Const                *json.RawMessage       `json:"const"`
+	Properties           map[string]*schemaNode `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *schemaNodeOrBool      `json:"additionalProperties"`
+	Items                *schemaNode            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     *float64               `json:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64               `json:"exclusiveMaximum"`
+	// Annotations, which don't affect validation
+	Schema      string          `json:"$schema"`
+	ID          string          `json:"$id"`
+	Comment     string          `json:"$comment"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Default     json.RawMessage `json:"default"`
+	Examples    json.RawMessage `json:"examples"`
+	pattern     *regexp.Regexp
+	constValue  interface{}
+}
+
+// schemaTypes is the value of the "type" keyword, which may be a single type name or an array of them.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// schemaNodeOrBool is the value of the "additionalProperties" keyword, which may be a schema or a boolean.
+type schemaNodeOrBool struct {
+	allowed bool
+	schema  *schemaNode
+}
+
+func (s *schemaNodeOrBool) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &s.allowed); err == nil {
+		return nil
+	}
+	s.allowed = true
+	s.schema = &schemaNode{}
+	return decodeSchemaNode(b, s.schema)
+}
+
+// decodeSchemaNode decodes a schema, rejecting unsupported keywords.
+func decodeSchemaNode(b []byte, n *schemaNode) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(n)
+	// The error for an unknown field has no type, so it can only be recognised by its message
+	if err != nil && strings.HasPrefix(err.Error(), "json: unknown field ") {
+		return fmt.Errorf("unsupported keyword %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	} else if err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after schema")
+	}
+	return nil
+}
+
+// CompileJSONSchema parses a JSON Schema document, returning an error if it is malformed.
+func CompileJSONSchema(schema []byte) (*JSONSchema, error) {
+	root := &schemaNode{}
+	if err := decodeSchemaNode(schema, root); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if err := root.compile(""); err != nil {
+		return nil, err
+	}
+	return &JSONSchema{
+		root: root}, nil
+}
+
+// MustCompileJSONSchema is like CompileJSONSchema but panics if the schema is malformed. It is intended for schemas
+// which are fixed at compile time.
+func MustCompileJSONSchema(schema []byte) *JSONSchema {
+	s, err := CompileJSONSchema(schema)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func (n *schemaNode) compile(path string) error {
+	for _, t := range n.Type {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return fmt.Errorf("invalid JSON schema: unknown type %q at %q", t, path)
+		}
+	}
+	if n.Pattern != "" {
+		re, err := regexp.Compile(n.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid JSON schema: bad pattern at %q: %w", path, err)
+		}
+		n.pattern = re
+	}
+	if n.Const != nil {
+		if err := json.Unmarshal(*n.Const, &n.constValue); err != nil {
+			return err
+		}
+	}
+	for name, prop := range n.Properties {
+		if err := prop.compile(path + "/properties/" + escapeJSONPointer(name)); err != nil {
+			return err
+		}
+	}
+	if n.AdditionalProperties != nil && n.AdditionalProperties.schema != nil {
+		if err := n.AdditionalProperties.schema.compile(path + "/additionalProperties"); err != nil {
+			return err
+		}
+	}
+	if n.Items != nil {
+		if err := n.Items.compile(path + "/items"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the JSON document against the schema. It returns nil if the document is valid; otherwise it returns
+// the problems found, keyed by the JSON Pointer (RFC 6901) of the offending value ("" for the document itself).
+func (s *JSONSchema) Validate(doc []byte) map[string]string {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return map[string]string{
+			"": "invalid JSON: " + err.Error()}
+	}
+	violations := map[string]string{}
+	s.root.validate(v, "", violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	return violations
+}
+
+// Filter is a Filter which validates the JSON bodies of requests against the schema, rejecting those which don't
+// conform with a 400 (Bad Request) error whose params describe each violation, keyed by JSON Pointer. Only non-empty
+// bodies with a JSON Content-Type are validated. The body is buffered, so the Service can still decode it afterwards.
+func (s *JSONSchema) Filter(req Request, svc Service) Response {
+	if req.Body == nil || !isJSONMediaType(req.Header.Get("Content-Type")) {
+		return svc(req)
+	}
+	b, err := req.BodyBytes(false)
+	if err != nil {
+		return Response{
+			Error: terrors.WrapWithCode(err, nil, terrors.ErrBadRequest)}
+	}
+	if len(b) == 0 {
+		return svc(req)
+	}
+	if violations := s.Validate(b); violations != nil {
+		rsp := NewResponse(req)
+		rsp.Error = terrors.BadRequest("schema_validation", schemaViolationsMessage(violations), violations)
+		return rsp
+	}
+	return svc(req)
+}
+
+// JSONSchemaFilter returns a Filter which validates request bodies against the passed JSON Schema, as described by
+// JSONSchema.Filter. The schema is compiled once, up front; it panics if the schema is malformed.
+func JSONSchemaFilter(schema []byte) Filter {
+	return MustCompileJSONSchema(schema).Filter
+}
+
+// ResponseFilter returns a Filter which, when ResponseSchemaValidation is enabled, validates the JSON bodies of
+// responses against the schema, to catch accidental changes to a Service's contract. Violations are logged as a
+// warning and, if strict is set, the response is replaced with a 500 (Internal Server Error) error whose params
+// describe each violation, keyed by JSON Pointer.
+//
+// Error responses and those without a JSON Content-Type are not validated. The body is buffered (decompressing it if
+// necessary), and passed on unchanged if it conforms.
+func (s *JSONSchema) ResponseFilter(strict bool) Filter {
+	return func(req Request, svc Service) Response {
+		rsp := svc(req)
+		if !ResponseSchemaValidation || rsp.Error != nil || rsp.Response == nil || rsp.Body == nil {
+			return rsp
+		}
+		if !isJSONMediaType(rsp.Header.Get("Content-Type")) {
+			return rsp
+		}
+		b, err := rsp.BodyBytes(false)
+		if err != nil {
+			return Response{
+				Request: &req,
+				Error:   terrors.Wrap(err, nil)}
+		}
+		violations := s.Validate(b)
+		if violations == nil {
+			return rsp
+		}
+
+		msg := schemaViolationsMessage(violations)
+		slog.Warn(req, "Handler for %s %s responded with a body which does not match its schema: %s", req.Method,
+			req.URL.Path, msg)
+		if !strict {
+			return rsp
+		}
+		rsp.Body.Close()
+		return Response{
+			Request: &req,
+			Error:   terrors.InternalService("response_schema_validation", msg, violations)}
+	}
+}
+
+// JSONSchemaResponseFilter returns a Filter which validates response bodies against the passed JSON Schema, as
+// described by JSONSchema.ResponseFilter. The schema is compiled once, up front; it panics if the schema is malformed.
+func JSONSchemaResponseFilter(schema []byte, strict bool) Filter {
+	return MustCompileJSONSchema(schema).ResponseFilter(strict)
+}
+
+func schemaViolationsMessage(violations map[string]string) string {
+	paths := make([]string, 0, len(violations))
+	for p := range violations {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	parts := make([]string, len(paths))
+	for i, p := range paths {
+		if p == "" {
+			parts[i] = violations[p]
+		} else {
+			parts[i] = p + ": " + violations[p]
+		}
+	}
+	return "Body does not match schema: " + strings.Join(parts, "; ")
+}
+
+func (n *schemaNode) validate(v interface{}, path string, violations map[string]string) {
+	fail := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		if prev, ok := violations[path]; ok {
+			msg = prev + "; " + msg
+		}
+		violations[path] = msg
+	}
+
+	if len(n.Type) > 0 {
+		matched := false
+		for _, t := range n.Type {
+			if schemaTypeMatches(t, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must be of type %s", strings.Join(n.Type, " or "))
+			return
+		}
+	}
+	if n.Enum != nil {
+		matched := false
+		for _, e := range n.Enum {
+			if reflect.DeepEqual(e, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must be one of the enumerated values")
+		}
+	}
+	if n.Const != nil && !reflect.DeepEqual(n.constValue, v) {
+		fail("must be %s", string(*n.Const))
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range n.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childPath := path + "/" + escapeJSONPointer(name)
+			if prop, ok := n.Properties[name]; ok {
+				prop.validate(v[name], childPath, violations)
+			} else if ap := n.AdditionalProperties; ap != nil {
+				if !ap.allowed {
+					fail("unexpected property %q", name)
+				} else if ap.schema != nil {
+					ap.schema.validate(v[name], childPath, violations)
+				}
+			}
+		}
+	case []interface{}:
+		if n.MinItems != nil && len(v) < *n.MinItems {
+			fail("must have at least %d items", *n.MinItems)
+		}
+		if n.MaxItems != nil && len(v) > *n.MaxItems {
+			fail("must have at most %d items", *n.MaxItems)
+		}
+		if n.Items != nil {
+			for i, item := range v {
+				n.Items.validate(item, path+"/"+strconv.Itoa(i), violations)
+			}
+		}
+	case string:
+		l := utf8.RuneCountInString(v)
+		if n.MinLength != nil && l < *n.MinLength {
+			fail("must be at least %d characters", *n.MinLength)
+		}
+		if n.MaxLength != nil && l > *n.MaxLength {
+			fail("must be at most %d characters", *n.MaxLength)
+		}
+		if n.pattern != nil && !n.pattern.MatchString(v) {
+			fail("must match pattern %s", n.Pattern)
+		}
+	case float64:
+		if n.Minimum != nil && v < *n.Minimum {
+			fail("must be at least %v", *n.Minimum)
+		}
+		if n.Maximum != nil && v > *n.Maximum {
+			fail("must be at most %v", *n.Maximum)
+		}
+		if n.ExclusiveMinimum != nil && v <= *n.ExclusiveMinimum {
+			fail("must be greater than %v", *n.ExclusiveMinimum)
+		}
+		if n.ExclusiveMaximum != nil && v >= *n.ExclusiveMaximum {
+			fail("must be less than %v", *n.ExclusiveMaximum)
+		}
+	}
+}
+
+func schemaTypeMatches(t string, v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case map[string]interface{}:
+		return t == "object"
+	case []interface{}:
+		return t == "array"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	}
+	return false
+}
+
+// escapeJSONPointer escapes a reference token for use in a JSON Pointer, as described in RFC 6901.
+func escapeJSONPointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,179 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 10, "pattern": "^[a-z]+$"},
+		"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"a/b": {"type": ["string", "null"]}
+	}
+}`
+
+func TestJSONSchemaValidate(t *testing.T) {
+	t.Parallel()
+
+	schema, err := CompileJSONSchema([]byte(testSchema))
+	require.NoError(t, err)
+
+	cases := map[string]map[string]string{
+		`{"name": "bob", "age": 30}`:                                 nil,
+		`{"name": "bob", "age": 30, "role": "admin", "tags": ["x"]}`: nil,
+		`{"name": "bob", "age": 30, "a/b": null}`:                    nil,
+		`{"name": "bob"}`: {
+			"": `missing required property "age"`},
+		`{"name": "Bob", "age": 1.5}`: {
+			"/name": "must match pattern ^[a-z]+$",
+			"/age":  "must be of type integer"},
+		`{"name": "", "age": 150}`: {
+			"/name": "must be at least 1 characters; must match pattern ^[a-z]+$",
+			"/age":  "must be less than 150"},
+		`{"name": "bob", "age": 30, "role": "root"}`: {
+			"/role": "must be one of the enumerated values"},
+		`{"name": "bob", "age": 30, "tags": ["a", 1, "c"]}`: {
+			"/tags":   "must have at most 2 items",
+			"/tags/1": "must be of type string"},
+		`{"name": "bob", "age": 30, "extra": true}`: {
+			"": `unexpected property "extra"`},
+		`{"name": "bob", "age": 30, "a/b": 1}`: {
+			"/a~1b": "must be of type string or null"},
+		`[]`: {
+			"": "must be of type object"},
+	}
+	for doc, expected := range cases {
+		assert.Equal(t, expected, schema.Validate([]byte(doc)), doc)
+	}
+	assert.Contains(t, schema.Validate([]byte(`{`))[""], "invalid JSON")
+
+	_, err = CompileJSONSchema([]byte(`{"type": "widget"}`))
+	assert.Error(t, err)
+	_, err = CompileJSONSchema([]byte(`{"properties": {"a": {"pattern": "("}}}`))
+	assert.Error(t, err)
+
+	// Unsupported keywords are rejected, wherever they appear, but annotations are allowed
+	for _, unsupported := range []string{
+		`{"$ref": "#/definitions/a"}`,
+		`{"properties": {"a": {"anyOf": [{"type": "string"}]}}}`,
+		`{"items": {"format": "date-time"}}`,
+		`{"additionalProperties": {"oneOf": []}}`,
+		`{"type": "object"} {}`} {
+		_, err = CompileJSONSchema([]byte(unsupported))
+		assert.Error(t, err, unsupported)
+	}
+	_, err = CompileJSONSchema([]byte(`{"allOf": []}`))
+	assert.EqualError(t, err, `invalid JSON schema: unsupported keyword "allOf"`)
+	_, err = CompileJSONSchema([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title": "Widget",
+		"description": "A widget",
+		"properties": {"a": {"type": "string", "default": "x", "examples": ["y"], "$comment": "z"}}}`))
+	assert.NoError(t, err)
+}
+
+func TestJSONSchemaFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		body := map[string]interface{}{}
+		if err := req.Decode(&body); err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(body)
+	})
+	svc = svc.Filter(JSONSchemaFilter([]byte(testSchema))).Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "POST", "/", map[string]interface{}{"name": "bob", "age": 30}))
+	require.NoError(t, rsp.Error)
+	body := map[string]interface{}{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "bob", body["name"])
+
+	rsp = svc(NewRequest(context.Background(), "POST", "/", map[string]interface{}{"name": "bob", "age": -1}))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, "bad_request.schema_validation"))
+	terr := rsp.Error.(*terrors.Error)
+	assert.Equal(t, "must be at least 0", terr.Params["/age"])
+	assert.Equal(t, "Body does not match schema: /age: must be at least 0", terr.Message)
+
+	// Only non-empty JSON bodies are validated
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.False(t, terrors.Is(rsp.Error, "bad_request.schema_validation"))
+	req := NewRequest(context.Background(), "POST", "/", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Body = ioutil.NopCloser(strings.NewReader("name=Bob"))
+	rsp = svc(req)
+	assert.False(t, terrors.Is(rsp.Error, "bad_request.schema_validation"))
+
+	assert.Panics(t, func() {
+		JSONSchemaFilter([]byte(`{"type": 1}`))
+	})
+}
+
+func TestJSONSchemaResponseFilter(t *testing.T) {
+	// Not parallel, as it enables ResponseSchemaValidation
+	ResponseSchemaValidation = true
+	defer func() { ResponseSchemaValidation = false }()
+
+	svc := Service(func(req Request) Response {
+		switch req.URL.Path {
+		case "/valid":
+			return req.Response(map[string]interface{}{"name": "bob", "age": 30})
+		case "/text":
+			rsp := req.Response(nil)
+			rsp.Header.Set("Content-Type", "text/plain")
+			rsp.Write([]byte(`not json`))
+			return rsp
+		}
+		return req.Response(map[string]interface{}{"name": "bob", "age": 200})
+	})
+	ctx := context.Background()
+
+	lenient := svc.Filter(JSONSchemaResponseFilter([]byte(testSchema), false))
+	strict := svc.Filter(JSONSchemaResponseFilter([]byte(testSchema), true))
+	for _, s := range []Service{lenient, strict} {
+		rsp := s(NewRequest(ctx, "GET", "/valid", nil))
+		require.NoError(t, rsp.Error)
+		body := map[string]interface{}{}
+		require.NoError(t, rsp.Decode(&body))
+		assert.Equal(t, "bob", body["name"])
+
+		rsp = s(NewRequest(ctx, "GET", "/text", nil))
+		require.NoError(t, rsp.Error)
+		b, err := rsp.BodyBytes(true)
+		require.NoError(t, err)
+		assert.Equal(t, "not json", string(b))
+	}
+
+	// Lenient validation only logs, so the response is passed through
+	rsp := lenient(NewRequest(ctx, "GET", "/invalid", nil))
+	require.NoError(t, rsp.Error)
+	body := map[string]interface{}{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.EqualValues(t, 200, body["age"])
+
+	rsp = strict(NewRequest(ctx, "GET", "/invalid", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, "internal_service.response_schema_validation"))
+	assert.Equal(t, "must be less than 150", rsp.Error.(*terrors.Error).Params["/age"])
+
+	// Validation is disabled by default
+	ResponseSchemaValidation = false
+	rsp = strict(NewRequest(ctx, "GET", "/invalid", nil))
+	assert.NoError(t, rsp.Error)
+}
@@ -8,17 +8,58 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/monzo/slog"
+	"github.com/monzo/terrors"
 )
 
+// A Server serves a Service over HTTP. It is created by Serve or Listen.
 type Server struct {
 	l              net.Listener
+	baseListener   net.Listener // the listener passed to Serve, before any wrapping (eg. by WithTLS)
 	srv            *http.Server
+	draining       chan struct{}
+	drainOnce      sync.Once
 	shuttingDown   chan struct{}
 	shutdownOnce   sync.Once
 	shutdownFuncs  []func(context.Context)
 	shutdownFuncsM sync.Mutex
+	passThrough    bool
+	requestHooks   []func(Request) Request
+}
+
+// A ServerOption customises a Server before it begins serving.
+type ServerOption func(*Server)
+
+// WithMaxHeaderBytes sets the maximum number of bytes the server will read when parsing request headers, including the
+// request line. It does not limit the size of the request body. If not set, http.DefaultMaxHeaderBytes is used.
+func WithMaxHeaderBytes(n int) ServerOption {
+	return func(s *Server) {
+		s.srv.MaxHeaderBytes = n
+	}
+}
+
+// WithPassThrough puts the server into pass-through mode, where response bodies are always streamed to the client as
+// they are read from the Service, with each read flushed immediately. By default, bodies of a known length are instead
+// written through net/http's buffering, which is more efficient but adds latency when the body is itself being streamed
+// (eg. by a proxy relaying a downstream response).
+//
+// Pass-through mode is intended for streaming proxies. The trade-off is that bodies are never buffered on the way out,
+// so filters must not expect to be able to re-read them, and that many small flushes cost more than a few large writes.
+func WithPassThrough() ServerOption {
+	return func(s *Server) {
+		s.passThrough = true
+	}
+}
+
+// WithRequestHook adds a hook which is run on every request before it is passed to the Service, allowing the request
+// (typically its context) to be enriched in one place, eg. to attach authentication details or a logger. Hooks run in
+// the order they are added, before any Filters applied to the Service.
+func WithRequestHook(hook func(req Request) Request) ServerOption {
+	return func(s *Server) {
+		s.requestHooks = append(s.requestHooks, hook)
+	}
 }
 
 // Listener returns the network listener that this server is active on.
@@ -32,6 +73,30 @@ func (s *Server) Done() <-chan struct{} {
 	return s.shuttingDown
 }
 
+// Draining returns a channel that will be closed when the server begins to drain, before it shuts down. See Drain.
+func (s *Server) Draining() <-chan struct{} {
+	return s.draining
+}
+
+// Drain prepares the server to be removed from service without dropping requests. It immediately marks the server as
+// not ready (so ReadinessService starts to fail, prompting load balancers to stop routing new requests to it), waits
+// for the passed grace period for that to take effect, and then stops the server gracefully as Stop does.
+//
+// Drain returns once the server has stopped. If the context expires during the grace period, shutdown begins
+// immediately and any outstanding connections are forcibly terminated.
+func (s *Server) Drain(ctx context.Context, grace time.Duration) {
+	s.drainOnce.Do(func() {
+		close(s.draining)
+	})
+	t := time.NewTimer(grace)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	s.Stop(ctx)
+}
+
 // Stop shuts down the server, returning when there are no more connections still open. Graceful shutdown will be
 // attempted until the passed context expires, at which time all connections will be forcibly terminated.
 func (s *Server) Stop(ctx context.Context) {
@@ -73,19 +138,27 @@ func (s *Server) addShutdownFunc(f func(context.Context)) {
 }
 
 // Serve starts a HTTP server, binding the passed Service to the passed listener.
-func Serve(svc Service, l net.Listener) (*Server, error) {
+func Serve(svc Service, l net.Listener, opts ...ServerOption) (*Server, error) {
 	s := &Server{
 		l:            l,
+		baseListener: l,
+		draining:     make(chan struct{}),
 		shuttingDown: make(chan struct{})}
 	svc = svc.Filter(func(req Request, svc Service) Response {
 		req.server = s
+		for _, hook := range s.requestHooks {
+			req = hook(req)
+		}
 		return svc(req)
 	})
 	s.srv = &http.Server{
-		Handler:        HttpHandler(svc),
 		MaxHeaderBytes: http.DefaultMaxHeaderBytes}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.srv.Handler = httpHandler(svc, s.passThrough)
 	go func() {
-		err := s.srv.Serve(l)
+		err := s.srv.Serve(s.l)
 		if err != nil && err != http.ErrServerClosed {
 			slog.Error(nil, "HTTP server error: %v", err)
 			// Stopping with an already-closed context means we go immediately to "forceful" mode
@@ -97,21 +170,9 @@ func Serve(svc Service, l net.Listener) (*Server, error) {
 	return s, nil
 }
 
-func Listen(svc Service, addr string) (*Server, error) {
-	// Determine on which address to listen, choosing in order one of:
-	// 1. The passed addr
-	// 2. PORT variable (listening on all interfaces)
-	// 3. Random, available port, on the loopback interface only
-	if addr == "" {
-		if _addr := os.Getenv("LISTEN_ADDR"); _addr != "" {
-			addr = _addr
-		} else if port, err := strconv.Atoi(os.Getenv("PORT")); err == nil && port >= 0 {
-			addr = fmt.Sprintf(":%d", port)
-		} else {
-			addr = ":0"
-		}
-	}
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+// Listen starts a HTTP server listening on the passed address, binding the passed Service to it.
+func Listen(svc Service, addr string, opts ...ServerOption) (*Server, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", listenAddr(addr))
 	if err != nil {
 		return nil, err
 	}
@@ -120,5 +181,41 @@ func Listen(svc Service, addr string) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Serve(svc, l)
+	return Serve(svc, l, opts...)
+}
+
+// listenAddr determines on which address to listen, choosing in order one of:
+// 1. The passed addr
+// 2. LISTEN_ADDR variable
+// 3. PORT variable (listening on all interfaces)
+// 4. Random, available port
+func listenAddr(addr string) string {
+	if addr != "" {
+		return addr
+	}
+	if _addr := os.Getenv("LISTEN_ADDR"); _addr != "" {
+		return _addr
+	} else if port, err := strconv.Atoi(os.Getenv("PORT")); err == nil && port >= 0 {
+		return fmt.Sprintf(":%d", port)
+	}
+	return ":0"
+}
+
+// ReadinessService is a Service suitable for use as a readiness check. It responds with 200 (OK) while the server
+// handling the request is serving normally, and 503 (Service Unavailable) once it has begun to drain or shut down.
+func ReadinessService(req Request) Response {
+	if s := req.server; s != nil {
+		select {
+		case <-s.Draining():
+			rsp := NewResponse(req)
+			rsp.Error = terrors.New(ErrServiceUnavailable, "Server is draining", nil)
+			return rsp
+		case <-s.Done():
+			rsp := NewResponse(req)
+			rsp.Error = terrors.New(ErrServiceUnavailable, "Server is shutting down", nil)
+			return rsp
+		default:
+		}
+	}
+	return req.Response("ok")
 }
@@ -0,0 +1,136 @@
+package typhon
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerDrain(t *testing.T) {
+	t.Parallel()
+
+	router := Router{}
+	router.GET("/ready", ReadinessService)
+	svc := router.Serve().Filter(ErrorFilter)
+	s, err := Listen(svc, "localhost:0")
+	require.NoError(t, err)
+	url := "http://" + s.Listener().Addr().String() + "/ready"
+	// Other tests swap out the global Client, so use one of our own
+	client := Service(BareClient).Filter(ErrorFilter)
+
+	rsp := NewRequest(context.Background(), "GET", url, nil).SendVia(client).Response()
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		s.Drain(context.Background(), 200*time.Millisecond)
+	}()
+	<-s.Draining()
+
+	// During the grace period, the server still serves requests but is not ready
+	rsp = NewRequest(context.Background(), "GET", url, nil).SendVia(client).Response()
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	select {
+	case <-s.Done():
+		assert.Fail(t, "server shut down before the grace period elapsed")
+	default:
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "server did not shut down after draining")
+	}
+	<-s.Done()
+}
+
+func TestServerPassThrough(t *testing.T) {
+	t.Parallel()
+
+	// The service streams a fixed-length body, only writing the second half once the client has received the first
+	received := make(chan struct{})
+	svc := Service(func(req Request) Response {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.Write([]byte("hello "))
+			select {
+			case <-received:
+			case <-time.After(5 * time.Second):
+			}
+			pw.Write([]byte("world"))
+			pw.Close()
+		}()
+		rsp := req.Response(nil)
+		rsp.Body = pr
+		rsp.ContentLength = 11
+		return rsp
+	})
+	s, err := Listen(svc, "localhost:0", WithPassThrough())
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	var httpRsp *http.Response
+	first := make([]byte, 6)
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		httpRsp, err = http.Get("http://" + s.Listener().Addr().String() + "/")
+		if err == nil {
+			_, err = io.ReadFull(httpRsp.Body, first)
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+		assert.Equal(t, "hello ", string(first))
+		assert.EqualValues(t, 11, httpRsp.ContentLength)
+	case <-time.After(time.Second):
+		require.FailNow(t, "first part of the body was not flushed")
+	}
+	defer httpRsp.Body.Close()
+	close(received)
+
+	rest, err := ioutil.ReadAll(httpRsp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(rest))
+}
+
+func TestServerRequestHook(t *testing.T) {
+	t.Parallel()
+
+	type ctxKey struct{}
+	appendTo := func(v string) func(Request) Request {
+		return func(req Request) Request {
+			prev, _ := req.Value(ctxKey{}).(string)
+			req.Context = context.WithValue(req.Context, ctxKey{}, prev+v)
+			return req
+		}
+	}
+	svc := Service(func(req Request) Response {
+		return req.Response(req.Value(ctxKey{}))
+	})
+	// Hooks run before the Service's own filters
+	svc = svc.Filter(func(req Request, svc Service) Response {
+		return svc(appendTo("filter")(req))
+	})
+	s, err := Listen(svc, "localhost:0", WithRequestHook(appendTo("a,")), WithRequestHook(appendTo("b,")))
+	require.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	client := Service(BareClient).Filter(ErrorFilter)
+	rsp := NewRequest(context.Background(), "GET", "http://"+s.Listener().Addr().String()+"/", nil).
+		SendVia(client).Response()
+	var body string
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, "a,b,filter", body)
+}
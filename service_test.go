@@ -0,0 +1,107 @@
+package typhon
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestServiceCall(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		assert.NotNil(t, req.Context)
+		assert.Equal(t, req.Context, req.Request.Context())
+		req.Header.Set("X-Mutated", "yes")
+		// The request body is streamed back, so it is still needed after the Service returns
+		rsp := req.Response(io.MultiReader(strings.NewReader("echo: "), req.Body))
+		rsp.Header.Set("X-Filtered", req.Header.Get("X-Filtered"))
+		return rsp
+	}).Filter(func(req Request, svc Service) Response {
+		req.Header.Set("X-Filtered", "1")
+		return svc(req)
+	})
+
+	body := &closeRecorder{
+		Reader: strings.NewReader("hello")}
+	req := NewRequest(nil, "POST", "/", nil)
+	req.Body = body
+	rsp := svc.Call(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "1", rsp.Header.Get("X-Filtered"))
+	assert.Empty(t, req.Header.Get("X-Mutated"))
+	assert.Equal(t, &req, rsp.Request)
+
+	// The request body stays open until the response body is closed
+	assert.False(t, body.closed)
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "echo: hello", string(b))
+	assert.True(t, body.closed)
+
+	// Buffered and streamed bodies keep their types, so their fast paths still apply
+	svc = func(req Request) Response {
+		return req.Response("buffered")
+	}
+	body = &closeRecorder{
+		Reader: strings.NewReader("hello")}
+	req.Body = body
+	rsp = svc.Call(req)
+	assert.IsType(t, &bufCloser{}, rsp.Body)
+	assert.True(t, body.closed)
+
+	svc = func(req Request) Response {
+		s := Streamer()
+		go func() {
+			s.Write([]byte("streamed"))
+			s.Close()
+		}()
+		return req.Response(s)
+	}
+	body = &closeRecorder{
+		Reader: strings.NewReader("hello")}
+	req.Body = body
+	rsp = svc.Call(req)
+	assert.IsType(t, &streamer{}, rsp.Body)
+	assert.True(t, isStreamingRsp(rsp))
+	b, err = rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(b))
+	assert.True(t, body.closed)
+
+	// Services which respond with just an error still produce a complete response
+	svc = func(req Request) Response {
+		assert.NotNil(t, req.Body)
+		return Response{
+			Error: terrors.NotFound("thing", "No thing", nil)}
+	}
+	rsp = svc.Call(NewRequest(context.Background(), "GET", "/", nil))
+	require.NotNil(t, rsp.Response)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound))
+	assert.Equal(t, http.StatusNotFound, svc.Filter(ErrorFilter).Call(NewRequest(nil, "GET", "/", nil)).StatusCode)
+
+	// Requests which failed to encode never reach the Service
+	called := false
+	svc = func(req Request) Response {
+		called = true
+		return req.Response(nil)
+	}
+	rsp = svc.Call(NewRequest(nil, "POST", "/", make(chan int)))
+	assert.Error(t, rsp.Error)
+	assert.False(t, called)
+}
@@ -0,0 +1,69 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningFilter(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("sekrit")
+	svc := Service(func(req Request) Response {
+		body := map[string]string{}
+		if err := req.Decode(&body); err != nil {
+			return Response{Error: err}
+		}
+		return req.Response(body)
+	})
+	server := svc.Filter(VerifySignatureFilter(key, time.Minute)).Filter(ErrorFilter)
+
+	// A correctly-signed request is accepted, and its body is still readable
+	client := server.Filter(SigningFilter(key))
+	req := NewRequest(context.Background(), "POST", "/foo?bar=baz", map[string]string{"a": "b"})
+	rsp := client(req)
+	require.NoError(t, rsp.Error)
+	body := map[string]string{}
+	require.NoError(t, rsp.Decode(&body))
+	assert.Equal(t, map[string]string{"a": "b"}, body)
+
+	// Unsigned requests are rejected
+	rsp = server(NewRequest(context.Background(), "POST", "/foo", nil))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, "unauthorized.missing_signature"))
+
+	// Requests signed with the wrong key are rejected
+	rsp = server.Filter(SigningFilter([]byte("wrong")))(NewRequest(context.Background(), "POST", "/foo", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, "unauthorized.invalid_signature"))
+
+	// Tampered requests are rejected
+	tamper := Filter(func(req Request, svc Service) Response {
+		req.Body = &bufCloser{}
+		req.Write([]byte(`{"a":"tampered"}`))
+		return svc(req)
+	})
+	rsp = server.Filter(tamper).Filter(SigningFilter(key))(
+		NewRequest(context.Background(), "POST", "/foo", map[string]string{"a": "b"}))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, "unauthorized.invalid_signature"))
+
+	// Requests signed too long ago are rejected
+	req = NewRequest(context.Background(), "POST", "/foo", nil)
+	ts := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	sig, err := requestSignature(&req, key, ts)
+	require.NoError(t, err)
+	req.Header.Set(SignatureTimestampHeader, ts)
+	req.Header.Set(SignatureHeader, sig)
+	rsp = server(req)
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, "unauthorized.expired_signature"))
+}
@@ -0,0 +1,100 @@
+package typhon
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/monzo/terrors"
+)
+
+type singleflightCall struct {
+	done chan struct{}
+	rsp  Response
+	body []byte
+}
+
+// SingleflightFilter returns a Filter which coalesces concurrent requests with the same key into a single call to the
+// Service, whose response is shared by all of them. This is useful in front of expensive idempotent requests (such as
+// GETs to a slow downstream), which are commonly made by many callers at once. Requests for which key returns "" are
+// passed through individually.
+//
+// Results are only shared while the call is in flight: once it completes the key is forgotten, so the next request
+// makes a fresh call and responses (including errors) are never cached. Each waiter receives its own copy of the
+// response, with a separate body buffer and headers. The call is made with the context of whichever request arrived
+// first, so if that request is cancelled all of its waiters see the cancellation.
+func SingleflightFilter(key func(req Request) string) Filter {
+	mtx := sync.Mutex{}
+	calls := map[string]*singleflightCall{}
+
+	return func(req Request, svc Service) Response {
+		k := key(req)
+		if k == "" {
+			return svc(req)
+		}
+
+		mtx.Lock()
+		if c, ok := calls[k]; ok {
+			mtx.Unlock()
+			select {
+			case <-c.done:
+				return c.response(req)
+			case <-req.Done():
+				return Response{
+					Error: ContextError(req)}
+			}
+		}
+		c := &singleflightCall{
+			done: make(chan struct{})}
+		calls[k] = c
+		mtx.Unlock()
+
+		defer func() {
+			if v := recover(); v != nil {
+				// Don't leave waiters hanging if the Service panics
+				c.rsp = Response{
+					Error: PanicError(v)}
+				c.finish(&mtx, calls, k)
+				panic(v)
+			}
+		}()
+		c.rsp = svc(req)
+		if c.rsp.Response != nil && c.rsp.Body != nil {
+			// Buffer the body so it can be given to each waiter
+			b, err := ioutil.ReadAll(c.rsp.Body)
+			c.rsp.Body.Close()
+			c.body = b
+			if err != nil && c.rsp.Error == nil {
+				c.rsp.Error = terrors.Wrap(err, nil)
+			}
+		}
+		c.finish(&mtx, calls, k)
+		return c.response(req)
+	}
+}
+
+func (c *singleflightCall) finish(mtx *sync.Mutex, calls map[string]*singleflightCall, k string) {
+	mtx.Lock()
+	delete(calls, k)
+	mtx.Unlock()
+	close(c.done)
+}
+
+// response returns a copy of the call's response, addressed to req.
+func (c *singleflightCall) response(req Request) Response {
+	rsp := c.rsp
+	rsp.Request = &req
+	if rsp.Response != nil {
+		httpRsp := *rsp.Response
+		httpRsp.Header = cloneHeader(httpRsp.Header)
+		if httpRsp.Trailer != nil {
+			httpRsp.Trailer = httpRsp.Trailer.Clone()
+		}
+		if httpRsp.Body != nil {
+			buf := &bufCloser{}
+			buf.Write(c.body)
+			httpRsp.Body = buf
+		}
+		rsp.Response = &httpRsp
+	}
+	return rsp
+}
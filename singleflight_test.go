@@ -0,0 +1,111 @@
+package typhon
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleflightFilter(t *testing.T) {
+	t.Parallel()
+
+	calls := int32(0)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	svc := Service(func(req Request) Response {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		rsp := req.Response(map[string]string{"path": req.URL.Path})
+		rsp.Header.Set("X-Shared", "yes")
+		return rsp
+	})
+	svc = svc.Filter(SingleflightFilter(func(req Request) string {
+		if req.Method != "GET" {
+			return ""
+		}
+		return req.URL.Path
+	}))
+
+	const n = 10
+	rsps := make([]Response, n)
+	wg := sync.WaitGroup{}
+	get := func(i int) {
+		defer wg.Done()
+		rsps[i] = svc(NewRequest(context.Background(), "GET", "/foo", nil))
+	}
+	wg.Add(n)
+	go get(0)
+	<-started
+	for i := 1; i < n; i++ {
+		go get(i)
+	}
+	time.Sleep(20 * time.Millisecond) // allow the other requests to join the in-flight call
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, rsp := range rsps {
+		require.NoError(t, rsp.Error)
+		body := map[string]string{}
+		require.NoError(t, rsp.Decode(&body))
+		assert.Equal(t, "/foo", body["path"])
+		assert.Equal(t, "yes", rsp.Header.Get("X-Shared"))
+	}
+	// Each response has its own headers
+	rsps[0].Header.Set("X-Shared", "no")
+	assert.Equal(t, "yes", rsps[1].Header.Get("X-Shared"))
+
+	// Completed calls aren't cached
+	before := atomic.LoadInt32(&calls)
+	rsp := svc(NewRequest(context.Background(), "GET", "/foo", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, before+1, atomic.LoadInt32(&calls))
+
+	// Unkeyed requests pass straight through
+	rsp = svc(NewRequest(context.Background(), "POST", "/foo", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, before+2, atomic.LoadInt32(&calls))
+}
+
+func TestSingleflightFilterCancelledWaiter(t *testing.T) {
+	t.Parallel()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	svc := Service(func(req Request) Response {
+		close(started)
+		<-release
+		return req.Response("done")
+	}).Filter(SingleflightFilter(func(req Request) string {
+		return "key"
+	}))
+
+	done := make(chan Response)
+	go func() {
+		done <- svc(NewRequest(context.Background(), "GET", "/", nil))
+	}()
+	<-started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rsp := svc(NewRequest(ctx, "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, ErrClientClosedRequest))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	rsp = svc(NewRequest(ctx, "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrTimeout))
+
+	close(release)
+	rsp = <-done
+	require.NoError(t, rsp.Error)
+}
@@ -0,0 +1,19 @@
+package typhon
+
+// StatusRewriteFilter returns a Filter which rewrites the status codes of responses according to the passed mapping
+// (eg. {418: 400}), leaving their bodies and headers intact. Responses with unmapped codes pass through unchanged.
+//
+// On a server, the status of an error response is only known once ErrorFilter has run, so this filter must be applied
+// after (ie. outside) ErrorFilter to rewrite those.
+func StatusRewriteFilter(mapping map[int]int) Filter {
+	return func(req Request, svc Service) Response {
+		rsp := svc(req)
+		if rsp.Response != nil {
+			if code, ok := mapping[rsp.StatusCode]; ok {
+				rsp.StatusCode = code
+				rsp.Status = ""
+			}
+		}
+		return rsp
+	}
+}
@@ -0,0 +1,43 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusRewriteFilter(t *testing.T) {
+	t.Parallel()
+
+	svc := Service(func(req Request) Response {
+		switch req.URL.Path {
+		case "/teapot":
+			rsp := req.ResponseWithCode("I'm a teapot", http.StatusTeapot)
+			rsp.Header.Set("X-Kept", "yes")
+			return rsp
+		case "/missing":
+			return Response{Error: terrors.NotFound("thing", "No such thing", nil)}
+		}
+		return req.Response("ok")
+	}).Filter(ErrorFilter).Filter(StatusRewriteFilter(map[int]int{
+		http.StatusTeapot:   http.StatusBadRequest,
+		http.StatusNotFound: http.StatusGone}))
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/teapot", nil))
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	assert.Equal(t, "yes", rsp.Header.Get("X-Kept"))
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, `"I'm a teapot"`+"\n", string(b))
+
+	rsp = svc(NewRequest(context.Background(), "GET", "/missing", nil))
+	assert.Equal(t, http.StatusGone, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound))
+
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
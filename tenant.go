@@ -0,0 +1,71 @@
+package typhon
+
+import (
+	"context"
+
+	"github.com/monzo/terrors"
+)
+
+// TenantHeader carries the ID of the tenant on whose behalf a request is made.
+const TenantHeader = "X-Typhon-Tenant"
+
+type tenantContextKeyType struct{}
+
+var tenantContextKey = tenantContextKeyType{}
+
+// WithTenant returns a copy of the passed context which records that work done with it is on behalf of the passed
+// tenant. Most users will want TenantFilter, which does this for inbound requests.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// Tenant returns the tenant recorded in the context (by TenantFilter or WithTenant), if any.
+func Tenant(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	tenant, ok := ctx.Value(tenantContextKey).(string)
+	return tenant, ok && tenant != ""
+}
+
+// TenantFilter returns a server Filter which determines the tenant of each request using extract (eg. from a claim in
+// an authenticated token) and records it in the request's context; see Tenant. If extract is nil, the TenantHeader is
+// used, which is only appropriate if it is set by a trusted party. Requests for which extract fails are rejected with
+// its error, and those for which it returns no tenant with a 400 (Bad Request) error.
+func TenantFilter(extract func(req Request) (string, error)) Filter {
+	if extract == nil {
+		extract = func(req Request) (string, error) {
+			return req.Header.Get(TenantHeader), nil
+		}
+	}
+	return func(req Request, svc Service) Response {
+		tenant, err := extract(req)
+		if err != nil {
+			return Response{
+				Error: terrors.Wrap(err, nil)}
+		} else if tenant == "" {
+			return Response{
+				Error: terrors.BadRequest("missing_tenant", "Request has no tenant", nil)}
+		}
+		req.Context = WithTenant(req.Context, tenant)
+		return svc(req)
+	}
+}
+
+// TenantClientFilter is a client Filter which propagates the tenant of the context of outbound requests (see Tenant)
+// in their TenantHeader, so that downstream services act on behalf of the same tenant. An outbound request which
+// already names a different tenant is rejected with a 403 (Forbidden) error without being sent, so that a handler
+// can't accidentally cross tenants. Requests whose context has no tenant are sent unchanged.
+func TenantClientFilter(req Request, svc Service) Response {
+	tenant, ok := Tenant(req.Context)
+	if !ok {
+		return svc(req)
+	}
+	if h := req.Header.Get(TenantHeader); h != "" && h != tenant {
+		return Response{
+			Error: terrors.Forbidden("tenant_mismatch", "Request would change tenant", map[string]string{
+				"tenant":         tenant,
+				"request_tenant": h})}
+	}
+	return svc(req.WithHeader(TenantHeader, tenant))
+}
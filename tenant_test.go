@@ -0,0 +1,87 @@
+package typhon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantPropagation(t *testing.T) {
+	t.Parallel()
+
+	var downstreamTenant string
+	client := Service(func(req Request) Response {
+		downstreamTenant = req.Header.Get(TenantHeader)
+		return req.Response(nil)
+	}).Filter(TenantClientFilter)
+
+	var outbound Request
+	svc := Service(func(req Request) Response {
+		tenant, ok := Tenant(req)
+		assert.True(t, ok)
+		assert.Equal(t, "acme", tenant)
+		return client(outbound.WithContext(req))
+	}).Filter(TenantFilter(nil))
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set(TenantHeader, "acme")
+	outbound = NewRequest(nil, "GET", "/downstream", nil)
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "acme", downstreamTenant)
+
+	// An outbound request naming another tenant is never sent
+	downstreamTenant = ""
+	outbound = NewRequest(nil, "GET", "/downstream", nil)
+	outbound.Header.Set(TenantHeader, "initech")
+	rsp = svc(req)
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, "forbidden.tenant_mismatch"))
+	assert.Empty(t, downstreamTenant)
+
+	// Requests without a tenant are rejected
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.True(t, terrors.Is(rsp.Error, "bad_request.missing_tenant"))
+
+	// Outbound requests made without a tenant are unaffected
+	rsp = client(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Empty(t, downstreamTenant)
+	_, ok := Tenant(context.Background())
+	assert.False(t, ok)
+}
+
+func TestTenantFilterExtractor(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		tenant, _ := Tenant(req)
+		return req.Response(tenant)
+	}).Filter(TenantFilter(func(req Request) (string, error) {
+		if req.Header.Get("Authorization") == "" {
+			return "", terrors.Unauthorized("", "No token", nil)
+		}
+		if req.Header.Get("Authorization") == "bad" {
+			return "", errors.New("bad token")
+		}
+		return "token-tenant", nil
+	})).Filter(ErrorFilter)
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Authorization", "bad")
+	assert.Equal(t, http.StatusInternalServerError, svc(req).StatusCode)
+
+	req.Header.Set("Authorization", "good")
+	rsp = svc(req)
+	require.NoError(t, rsp.Error)
+	tenant := ""
+	require.NoError(t, rsp.Decode(&tenant))
+	assert.Equal(t, "token-tenant", tenant)
+}
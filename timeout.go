@@ -0,0 +1,176 @@
+package typhon
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/monzo/terrors"
+)
+
+// TimeoutFilter returns a Filter which applies a deadline of d to the context of each request. Services should
+// observe the context's cancellation and stop work once it fires.
+//
+// As with any context, the deadline can only be shortened: if the incoming request already has an earlier deadline,
+// that deadline continues to apply. The deadline isn't fixed until the request has been routed, so that routes with
+// their own timeout (see WithRouteTimeout) can replace d, whether it is shorter or longer. If the context is used
+// before then (eg. by a filter between this one and the Router which derives a context from it), d is fixed, and a
+// route's timeout can then only shorten it.
+func TimeoutFilter(d time.Duration) Filter {
+	return func(req Request, svc Service) Response {
+		ctx := req.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		tctx := &timeoutContext{
+			parent:  ctx,
+			start:   time.Now(),
+			timeout: d}
+		return releaseContext(svc(req.WithContext(tctx)), tctx.cancel)
+	}
+}
+
+// releaseContext arranges for a request context to be cancelled once the response is finished with.
+func releaseContext(rsp Response, cancel context.CancelFunc) Response {
+	// A streaming response body may depend on the request context (eg. if it is proxied from a downstream), so
+	// release the context only once the body has been closed. Buffered bodies need no such care.
+	if rsp.Response != nil && rsp.Body != nil {
+		if _, ok := rsp.Body.(*bufCloser); !ok {
+			rsp.Body = &cancelOnClose{
+				ReadCloser: rsp.Body,
+				cancel:     cancel}
+			return rsp
+		}
+	}
+	cancel()
+	return rsp
+}
+
+// cancelOnClose is a ReadCloser which cancels a context when it is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// routeTimeoutFilter applies a route's timeout, replacing that of an enclosing TimeoutFilter if its deadline hasn't
+// been fixed yet.
+func routeTimeoutFilter(d time.Duration) Filter {
+	return func(req Request, svc Service) Response {
+		ctx := req.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if c, ok := ctx.Value(timeoutContextKey).(*timeoutContext); ok && c.setTimeout(d) {
+			return svc(req)
+		}
+		ctx, cancel := context.WithTimeout(ctx, d)
+		return releaseContext(svc(req.WithContext(ctx)), cancel)
+	}
+}
+
+type timeoutContextKeyType struct{}
+
+var timeoutContextKey = timeoutContextKeyType{}
+
+// timeoutContext is the context applied by TimeoutFilter. Its deadline is fixed when it is first used, or when a
+// route sets its own timeout; from then on it behaves exactly like the context.WithDeadline it delegates to, so it
+// never reports more than one deadline. Timeouts are measured from the context's creation.
+type timeoutContext struct {
+	parent  context.Context
+	start   time.Time
+	timeout time.Duration
+	mtx     sync.Mutex
+	ctx     context.Context
+	release context.CancelFunc
+}
+
+// setTimeout fixes the deadline with the passed timeout, returning false if it was already fixed.
+func (c *timeoutContext) setTimeout(d time.Duration) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.ctx != nil {
+		return false
+	}
+	c.ctx, c.release = context.WithDeadline(c.parent, c.start.Add(d))
+	return true
+}
+
+// context returns the context to which c delegates, fixing the deadline with the default timeout if need be.
+func (c *timeoutContext) context() context.Context {
+	c.setTimeout(c.timeout)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.ctx
+}
+
+func (c *timeoutContext) cancel() {
+	c.context()
+	c.release()
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	return c.context().Deadline()
+}
+
+func (c *timeoutContext) Done() <-chan struct{} {
+	return c.context().Done()
+}
+
+func (c *timeoutContext) Err() error {
+	return c.context().Err()
+}
+
+func (c *timeoutContext) Value(key interface{}) interface{} {
+	if key == timeoutContextKey {
+		return c
+	}
+	// Values don't fix the deadline, but once it is fixed they come from the delegate, which lets the context package
+	// see that contexts derived from this one can be cancelled along with it (without a goroutine to watch it)
+	c.mtx.Lock()
+	ctx := c.ctx
+	c.mtx.Unlock()
+	if ctx != nil {
+		return ctx.Value(key)
+	}
+	return c.parent.Value(key)
+}
+
+// ContextError returns an error describing why ctx is done, or nil if it isn't. Expiry of the context's deadline is a
+// terrors.ErrTimeout error (504), as it means the server ran out of time. Any other cancellation is attributed to the
+// client going away, and is an ErrClientClosedRequest error (499).
+//
+// context.Cause isn't available in the Go versions this module supports, so a context cancelled for some other
+// reason (eg. by a CancellationRegistry, or during a forced shutdown) is also reported as ErrClientClosedRequest.
+func ContextError(ctx context.Context) error {
+	switch ctx.Err() {
+	case nil:
+		return nil
+	case context.DeadlineExceeded:
+		return terrors.Timeout("deadline_exceeded", "Request deadline exceeded", nil)
+	default:
+		return terrors.New(ErrClientClosedRequest, "Client closed request", nil)
+	}
+}
+
+// ContextErrorFilter is a Filter which replaces the error of a response to a request whose context is done with one
+// from ContextError, so timeouts and client disconnects (which otherwise both tend to surface as generic errors) can be
+// told apart: eg. to alert only on the former. The original error is kept as the "error" parameter. To see deadlines
+// applied by TimeoutFilter, it must be applied inside it.
+func ContextErrorFilter(req Request, svc Service) Response {
+	rsp := svc(req)
+	if rsp.Error == nil || req.Context == nil {
+		return rsp
+	}
+	// Errors which already have the right status (eg. a timeout from a downstream) are left alone
+	if err := ContextError(req.Context); err != nil && ErrorStatusCode(err) != ErrorStatusCode(rsp.Error) {
+		rsp.Error = terrors.Wrap(err, map[string]string{
+			"error": rsp.Error.Error()})
+	}
+	return rsp
+}
@@ -0,0 +1,90 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutFilter(t *testing.T) {
+	t.Parallel()
+
+	var reqCtx context.Context
+	svc := Service(func(req Request) Response {
+		reqCtx = req.Context
+		select {
+		case <-req.Done():
+		case <-time.After(time.Second):
+			assert.Fail(t, "request context was not cancelled at its deadline")
+		}
+		return req.Response("done")
+	})
+	svc = svc.Filter(TimeoutFilter(10 * time.Millisecond))
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, context.DeadlineExceeded, reqCtx.Err())
+}
+
+func TestTimeoutFilter_StreamingBody(t *testing.T) {
+	t.Parallel()
+
+	var reqCtx context.Context
+	svc := Service(func(req Request) Response {
+		reqCtx = req.Context
+		s := Streamer()
+		go func() {
+			defer s.Close()
+			s.Write([]byte("streamed"))
+		}()
+		return req.Response(s)
+	})
+	svc = svc.Filter(TimeoutFilter(time.Minute))
+
+	rsp := svc(NewRequest(context.Background(), "GET", "/", nil))
+	require.NoError(t, rsp.Error)
+	// The context remains live until the body has been consumed
+	assert.NoError(t, reqCtx.Err())
+	b, err := rsp.BodyBytes(true)
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(b))
+	assert.Equal(t, context.Canceled, reqCtx.Err())
+}
+
+func TestContextErrorFilter(t *testing.T) {
+	t.Parallel()
+	svc := Service(func(req Request) Response {
+		<-req.Done()
+		return Response{
+			Error: terrors.Wrap(req.Err(), nil)}
+	}).Filter(ContextErrorFilter)
+
+	// Our own deadline
+	rsp := svc.Filter(TimeoutFilter(time.Millisecond))(NewRequest(context.Background(), "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrTimeout))
+	assert.Equal(t, http.StatusGatewayTimeout, ErrorStatusCode(rsp.Error))
+	assert.Contains(t, rsp.Error.(*terrors.Error).Params["error"], context.DeadlineExceeded.Error())
+
+	// The client going away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rsp = svc.Filter(TimeoutFilter(time.Second))(NewRequest(ctx, "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.True(t, terrors.Is(rsp.Error, ErrClientClosedRequest))
+	assert.Equal(t, 499, ErrorStatusCode(rsp.Error))
+
+	// Errors unrelated to the context are untouched
+	svc = Service(func(req Request) Response {
+		return Response{
+			Error: terrors.NotFound("thing", "Not found", nil)}
+	}).Filter(ContextErrorFilter)
+	rsp = svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.True(t, terrors.Is(rsp.Error, terrors.ErrNotFound))
+	assert.NoError(t, ContextError(context.Background()))
+}
@@ -0,0 +1,61 @@
+package typhon
+
+import (
+	"github.com/monzo/terrors"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtobufTranscodingFilter returns a Filter for gateways which accept JSON from clients but talk protobuf to the
+// Service (typically a client for a downstream). reqType and rspType are messages of the types of the request and
+// response bodies; they are used only to create new messages, so may be empty.
+//
+// A JSON request body is decoded into a new reqType (with protojson) and sent as binary protobuf, and the request is
+// sent accepting protobuf. A protobuf response is then decoded into a new rspType and re-encoded as JSON, keeping its
+// status code and other headers. Clients which send or accept protobuf themselves get it without transcoding, as do
+// error responses, which ErrorFilter handles. A request body which can't be decoded is rejected with a 400 (Bad
+// Request) error.
+func ProtobufTranscodingFilter(reqType, rspType proto.Message) Filter {
+	return func(req Request, svc Service) Response {
+		mediaType, _ := parseContentType(req.Header.Get("Content-Type"))
+		downReq := req
+		downReq.Header = cloneHeader(req.Header)
+		downReq.Header.Set("Accept", "application/protobuf")
+		if req.Body != nil && req.ContentLength != 0 && !protobufMediaTypes[mediaType] {
+			m := reqType.ProtoReflect().New().Interface()
+			if err := req.Decode(m); err != nil {
+				return Response{
+					Error: err}
+			}
+			b, err := proto.Marshal(m)
+			if err != nil {
+				return Response{
+					Error: terrors.Wrap(err, nil)}
+			}
+			downReq.SetBody(b)
+			downReq.Header.Set("Content-Type", "application/protobuf")
+		}
+
+		rsp := svc(downReq)
+		if rsp.Error != nil || rsp.Response == nil || encodesProtobuf(&req) {
+			return rsp
+		}
+		if rspMediaType, _ := parseContentType(rsp.Header.Get("Content-Type")); !protobufMediaTypes[rspMediaType] {
+			return rsp
+		}
+		m := rspType.ProtoReflect().New().Interface()
+		if err := rsp.Decode(m); err != nil {
+			return Response{
+				Error: err}
+		}
+		out := NewResponseWithCode(req, rsp.StatusCode)
+		for k, v := range rsp.Header {
+			switch k {
+			case "Content-Type", "Content-Length", "Content-Encoding":
+			default:
+				out.Header[k] = v
+			}
+		}
+		out.EncodeAsProtobufJSON(m)
+		return out
+	}
+}
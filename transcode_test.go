@@ -0,0 +1,65 @@
+package typhon
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/monzo/typhon/prototest"
+)
+
+func TestProtobufTranscodingFilter(t *testing.T) {
+	t.Parallel()
+	// The downstream only speaks protobuf
+	downstream := Service(func(req Request) Response {
+		assert.Equal(t, "application/protobuf", req.Header.Get("Accept"))
+		g := &prototest.Greeting{}
+		if req.ContentLength != 0 {
+			assert.Equal(t, "application/protobuf", req.Header.Get("Content-Type"))
+			b, err := req.BodyBytes(true)
+			require.NoError(t, err)
+			require.NoError(t, proto.Unmarshal(b, g))
+		}
+		rsp := NewResponseWithCode(req, http.StatusCreated)
+		rsp.Header.Set("X-Downstream", "1")
+		rsp.EncodeAsProtobuf(&prototest.Greeting{
+			Message:  "Hello, " + g.Message,
+			Priority: g.Priority + 1})
+		return rsp
+	})
+	svc := downstream.Filter(ProtobufTranscodingFilter(&prototest.Greeting{}, &prototest.Greeting{}))
+
+	req := NewRequest(context.Background(), "POST", "/", map[string]interface{}{
+		"message":  "world",
+		"priority": 1})
+	rsp := svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+	assert.Equal(t, "application/json", rsp.Header.Get("Content-Type"))
+	assert.Equal(t, "1", rsp.Header.Get("X-Downstream"))
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"message":"Hello, world","priority":2}`, string(b))
+
+	// Clients which accept protobuf get it untouched
+	req = NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("Accept", "application/protobuf")
+	rsp = svc(req)
+	require.NoError(t, rsp.Error)
+	assert.Equal(t, "application/protobuf", rsp.Header.Get("Content-Type"))
+	g := &prototest.Greeting{}
+	require.NoError(t, rsp.Decode(g))
+	assert.Equal(t, "Hello, ", g.Message)
+
+	// Malformed request bodies are rejected
+	req = NewRequest(context.Background(), "POST", "/", map[string]interface{}{
+		"nope": true})
+	rsp = svc(req)
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusBadRequest, ErrorStatusCode(rsp.Error))
+}
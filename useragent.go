@@ -0,0 +1,12 @@
+package typhon
+
+// UserAgentFilter returns a client Filter which sets the User-Agent header of outgoing requests to the passed value
+// (eg. "service.foo/1.2.3"), unless the request already has one. This lets callers override it per-request.
+func UserAgentFilter(userAgent string) Filter {
+	return func(req Request, svc Service) Response {
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", userAgent)
+		}
+		return svc(req)
+	}
+}
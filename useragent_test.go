@@ -0,0 +1,27 @@
+package typhon
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAgentFilter(t *testing.T) {
+	t.Parallel()
+
+	var ua string
+	svc := Service(func(req Request) Response {
+		ua = req.Header.Get("User-Agent")
+		return req.Response(nil)
+	})
+	svc = svc.Filter(UserAgentFilter("service.foo/1.0"))
+
+	svc(NewRequest(context.Background(), "GET", "/", nil))
+	assert.Equal(t, "service.foo/1.0", ua)
+
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set("User-Agent", "custom")
+	svc(req)
+	assert.Equal(t, "custom", ua)
+}
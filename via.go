@@ -0,0 +1,62 @@
+package typhon
+
+import (
+	"context"
+	"strings"
+
+	"github.com/monzo/terrors"
+)
+
+// ViaHeader carries the identities of the services a request has passed through, in order, separated by commas.
+const ViaHeader = "X-Typhon-Via"
+
+type viaContextKeyType struct{}
+
+var viaContextKey = viaContextKeyType{}
+
+// LoopDetectionFilter returns a server Filter which rejects requests that have already passed through the service
+// with the passed identity (according to their ViaHeader) with a 508 (Loop Detected) error, breaking request loops
+// between services deterministically. Requests which are accepted have their chain recorded in their context, so that
+// ViaClientFilter can extend it on downstream requests.
+func LoopDetectionFilter(identity string) Filter {
+	return func(req Request, svc Service) Response {
+		chain := parseVia(req.Header.Get(ViaHeader))
+		for _, hop := range chain {
+			if hop == identity {
+				return Response{
+					Error: terrors.New(ErrLoopDetected, "Request has already passed through "+identity,
+						map[string]string{
+							"via": strings.Join(chain, ", ")})}
+			}
+		}
+		req.Context = context.WithValue(req.Context, viaContextKey, chain)
+		return svc(req)
+	}
+}
+
+// ViaClientFilter returns a client Filter which sets the ViaHeader of outbound requests to the chain of the inbound
+// request they were made on behalf of (as recorded by LoopDetectionFilter) followed by the passed identity.
+func ViaClientFilter(identity string) Filter {
+	return func(req Request, svc Service) Response {
+		var chain []string
+		if req.Context != nil {
+			chain, _ = req.Value(viaContextKey).([]string)
+		}
+		req = req.WithHeader(ViaHeader, strings.Join(append(chain[:len(chain):len(chain)], identity), ", "))
+		return svc(req)
+	}
+}
+
+func parseVia(h string) []string {
+	if h == "" {
+		return nil
+	}
+	parts := strings.Split(h, ",")
+	chain := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			chain = append(chain, p)
+		}
+	}
+	return chain
+}
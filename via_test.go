@@ -0,0 +1,48 @@
+package typhon
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/monzo/terrors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoopDetection(t *testing.T) {
+	t.Parallel()
+
+	// Services a and b call each other, forming a loop; they're connected in-process here
+	services := map[string]Service{}
+	newService := func(identity, next string) Service {
+		client := Service(func(req Request) Response {
+			return services[next](req)
+		}).Filter(ViaClientFilter(identity))
+		return Service(func(req Request) Response {
+			return client(NewRequest(req, "GET", "/", nil))
+		}).Filter(LoopDetectionFilter(identity)).Filter(ErrorFilter)
+	}
+	services["a"] = newService("a", "b")
+	services["b"] = newService("b", "a")
+
+	rsp := services["a"](NewRequest(context.Background(), "GET", "/", nil))
+	require.Error(t, rsp.Error)
+	assert.Equal(t, http.StatusLoopDetected, rsp.StatusCode)
+	assert.True(t, terrors.Is(rsp.Error, ErrLoopDetected))
+	assert.Equal(t, "a, b", rsp.Error.(*terrors.Error).Params["via"])
+
+	// A request which doesn't loop is accepted, and its chain is extended downstream
+	var via string
+	svc := Service(func(req Request) Response {
+		client := Service(func(req Request) Response {
+			via = req.Header.Get(ViaHeader)
+			return req.Response(nil)
+		}).Filter(ViaClientFilter("c"))
+		return client(NewRequest(req, "GET", "/", nil))
+	}).Filter(LoopDetectionFilter("c"))
+	req := NewRequest(context.Background(), "GET", "/", nil)
+	req.Header.Set(ViaHeader, "a,b")
+	require.NoError(t, svc(req).Error)
+	assert.Equal(t, "a, b, c", via)
+}